@@ -0,0 +1,204 @@
+// Package fastcgi is a minimal FastCGI client to request PHP-FPM without a web server.
+package fastcgi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FastCGI record types and the responder role (https://fast-cgi.github.io/spec)
+const (
+	fcgiVersion      = 1
+	fcgiBeginRequest = 1
+	fcgiEndRequest   = 3
+	fcgiParams       = 4
+	fcgiStdin        = 5
+	fcgiStdout       = 6
+	fcgiStderr       = 7
+	fcgiResponder    = 1
+	fcgiMaxContent   = 65535
+	fcgiRequestID    = 1
+)
+
+type fcgiHeader struct {
+	Version       uint8
+	Type          uint8
+	RequestID     uint16
+	ContentLength uint16
+	PaddingLength uint8
+	Reserved      uint8
+}
+
+func writeRecord(w io.Writer, recType uint8, content []byte) error {
+	for {
+		n := len(content)
+		if n > fcgiMaxContent {
+			n = fcgiMaxContent
+		}
+		h := fcgiHeader{
+			Version:       fcgiVersion,
+			Type:          recType,
+			RequestID:     fcgiRequestID,
+			ContentLength: uint16(n),
+		}
+		if err := binary.Write(w, binary.BigEndian, h); err != nil {
+			return err
+		}
+		if _, err := w.Write(content[:n]); err != nil {
+			return err
+		}
+		content = content[n:]
+		if len(content) == 0 {
+			return nil
+		}
+	}
+}
+
+func encodeLength(b *bytes.Buffer, n int) {
+	if n < 128 {
+		b.WriteByte(byte(n))
+		return
+	}
+	binary.Write(b, binary.BigEndian, uint32(n)|1<<31)
+}
+
+func encodeParams(params map[string]string) []byte {
+	var b bytes.Buffer
+	for k, v := range params {
+		encodeLength(&b, len(k))
+		encodeLength(&b, len(v))
+		b.WriteString(k)
+		b.WriteString(v)
+	}
+	return b.Bytes()
+}
+
+// Get sends a GET request to the FastCGI server at network ("tcp" or "unix") and address,
+// and returns the status and the body of the CGI response.
+// The params are added to the CGI variables, e.g. SCRIPT_FILENAME.
+func Get(network, address, uri string, params map[string]string, timeout time.Duration) (int, []byte, error) {
+	conn, err := net.DialTimeout(network, address, timeout)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer conn.Close()
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	path, query := uri, ""
+	if i := strings.Index(uri, "?"); i >= 0 {
+		path, query = uri[:i], uri[i+1:]
+	}
+	env := map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"SERVER_PROTOCOL":   "HTTP/1.1",
+		"SERVER_SOFTWARE":   "mackerel-agent-plugins",
+		"REQUEST_METHOD":    "GET",
+		"REQUEST_URI":       uri,
+		"SCRIPT_NAME":       path,
+		"SCRIPT_FILENAME":   path,
+		"QUERY_STRING":      query,
+		"REMOTE_ADDR":       "127.0.0.1",
+		"CONTENT_LENGTH":    "0",
+	}
+	for k, v := range params {
+		env[k] = v
+	}
+
+	w := bufio.NewWriter(conn)
+	begin := []byte{0, fcgiResponder, 0, 0, 0, 0, 0, 0}
+	if err := writeRecord(w, fcgiBeginRequest, begin); err != nil {
+		return 0, nil, err
+	}
+	if err := writeRecord(w, fcgiParams, encodeParams(env)); err != nil {
+		return 0, nil, err
+	}
+	// empty records terminate the streams
+	if err := writeRecord(w, fcgiParams, nil); err != nil {
+		return 0, nil, err
+	}
+	if err := writeRecord(w, fcgiStdin, nil); err != nil {
+		return 0, nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return 0, nil, err
+	}
+
+	stdout, err := readResponse(bufio.NewReader(conn))
+	if err != nil {
+		return 0, nil, err
+	}
+	return parseCGIResponse(stdout)
+}
+
+// ParseAddress parses "unix:/path/to/sock", "tcp:host:port", a path or "host:port" into network and address.
+func ParseAddress(s string) (string, string) {
+	switch {
+	case strings.HasPrefix(s, "unix:"):
+		return "unix", strings.TrimPrefix(s, "unix:")
+	case strings.HasPrefix(s, "tcp:"):
+		return "tcp", strings.TrimPrefix(s, "tcp:")
+	case strings.HasPrefix(s, "/"):
+		return "unix", s
+	}
+	return "tcp", s
+}
+
+// readResponse reads the records until FCGI_END_REQUEST and returns the content of FCGI_STDOUT.
+func readResponse(r io.Reader) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	for {
+		var h fcgiHeader
+		if err := binary.Read(r, binary.BigEndian, &h); err != nil {
+			return nil, err
+		}
+		content := make([]byte, int(h.ContentLength)+int(h.PaddingLength))
+		if _, err := io.ReadFull(r, content); err != nil {
+			return nil, err
+		}
+		content = content[:h.ContentLength]
+		switch h.Type {
+		case fcgiStdout:
+			stdout.Write(content)
+		case fcgiStderr:
+			stderr.Write(content)
+		case fcgiEndRequest:
+			if stdout.Len() == 0 && stderr.Len() > 0 {
+				return nil, errors.New(strings.TrimSpace(stderr.String()))
+			}
+			return stdout.Bytes(), nil
+		}
+	}
+}
+
+// parseCGIResponse parses the headers of the CGI response, whose status is given by the "Status" header.
+func parseCGIResponse(b []byte) (int, []byte, error) {
+	r := bufio.NewReader(bytes.NewReader(b))
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return 0, nil, err
+	}
+	status := http.StatusOK
+	if s := strings.Fields(header.Get("Status")); len(s) > 0 {
+		status, err = strconv.Atoi(s[0])
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	return status, body, nil
+}
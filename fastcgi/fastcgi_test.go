@@ -0,0 +1,49 @@
+package fastcgi
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGet(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go fcgi.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/status" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, "query:%s\n", r.URL.RawQuery)
+	}))
+
+	status, body, err := Get("tcp", l.Addr().String(), "/status?json", map[string]string{"SCRIPT_FILENAME": "/tmp/status.php"}, 5*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "query:json\n", string(body))
+
+	status, _, err = Get("tcp", l.Addr().String(), "/missing", nil, 5*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestParseAddress(t *testing.T) {
+	for _, c := range []struct{ socket, network, address string }{
+		{"unix:/run/php-fpm/www.sock", "unix", "/run/php-fpm/www.sock"},
+		{"/run/php-fpm/www.sock", "unix", "/run/php-fpm/www.sock"},
+		{"tcp:127.0.0.1:9000", "tcp", "127.0.0.1:9000"},
+		{"127.0.0.1:9000", "tcp", "127.0.0.1:9000"},
+	} {
+		network, address := ParseAddress(c.socket)
+		assert.Equal(t, c.network, network)
+		assert.Equal(t, c.address, address)
+	}
+}
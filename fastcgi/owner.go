@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package fastcgi
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// checkOwner refuses the file not owned by the running user, or writable by the group or others
+func checkOwner(path string, fi os.FileInfo) error {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("failed to get the owner of %s", path)
+	}
+	if int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("%s is not owned by uid %d", path, os.Getuid())
+	}
+	if fi.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("%s is writable by the group or others", path)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+package fastcgi
+
+import (
+	"fmt"
+	"os"
+)
+
+// checkOwner is not supported on Windows, where the script cannot be written safely
+func checkOwner(path string, fi os.FileInfo) error {
+	return fmt.Errorf("writing the script is not supported on windows: %s", path)
+}
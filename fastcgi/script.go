@@ -0,0 +1,85 @@
+package fastcgi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RunScript writes the script to path, and executes it by the FastCGI server at socket
+// (e.g. "unix:/run/php-fpm/www.sock"). It returns the body of the response.
+func RunScript(socket, path, script string, timeout time.Duration) ([]byte, error) {
+	if err := WriteScript(path, script); err != nil {
+		return nil, err
+	}
+	network, address := ParseAddress(socket)
+	status, body, err := Get(network, address, path, nil, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("FastCGI status error: %d", status)
+	}
+	return body, nil
+}
+
+// WriteScript writes the script to path unless it is up to date.
+// Since the FastCGI server executes the file, the directory and the file must be owned by the running user
+// and not writable by others, e.g. /var/lib/mackerel-agent, not /tmp. The file is written to a temporary file
+// created exclusively in the directory, and renamed to path.
+func WriteScript(path, script string) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("script path must be absolute: %s", path)
+	}
+	dir := filepath.Dir(path)
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if err := checkOwner(dir, fi); err != nil {
+		return err
+	}
+
+	fi, err = os.Lstat(path)
+	switch {
+	case err == nil:
+		if !fi.Mode().IsRegular() {
+			return fmt.Errorf("%s is not a regular file", path)
+		}
+		if err := checkOwner(path, fi); err != nil {
+			return err
+		}
+		if b, err := ioutil.ReadFile(path); err == nil && string(b) == script {
+			return nil
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.WriteString(script)
+	if err == nil {
+		// readable by the FastCGI server running as another user
+		err = f.Chmod(0644)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
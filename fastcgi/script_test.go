@@ -0,0 +1,87 @@
+package fastcgi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testScript = "<?php echo 'ok';\n"
+
+func TestRunScript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mackerel-plugin-fastcgi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "status.php")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go fcgi.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadFile(r.URL.Path)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, "%s", b)
+	}))
+
+	body, err := RunScript("tcp:"+l.Addr().String(), script, testScript, 5*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, testScript, string(body))
+
+	fi, err := os.Stat(script)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0644), fi.Mode().Perm())
+}
+
+func TestWriteScript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mackerel-plugin-fastcgi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "status.php")
+
+	// the outdated script is replaced
+	assert.Nil(t, ioutil.WriteFile(script, []byte("<?php\n"), 0644))
+	assert.Nil(t, WriteScript(script, testScript))
+	b, err := ioutil.ReadFile(script)
+	assert.Nil(t, err)
+	assert.Equal(t, testScript, string(b))
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, files, 1, "temporary files should not be left")
+
+	assert.NotNil(t, WriteScript("status.php", testScript), "relative path should be refused")
+
+	// the script writable by others should be refused
+	assert.Nil(t, os.Chmod(script, 0666))
+	assert.NotNil(t, WriteScript(script, testScript))
+	assert.Nil(t, os.Remove(script))
+
+	// the symlink should be refused
+	target := filepath.Join(dir, "target.php")
+	assert.Nil(t, ioutil.WriteFile(target, []byte(testScript), 0644))
+	assert.Nil(t, os.Symlink(target, script))
+	assert.NotNil(t, WriteScript(script, testScript))
+	assert.Nil(t, os.Remove(script))
+
+	// the directory writable by others such as /tmp should be refused
+	assert.Nil(t, os.Chmod(dir, 01777))
+	assert.NotNil(t, WriteScript(script, testScript))
+	_, err = os.Stat(script)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,256 @@
+package mpaccesslog
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+	"github.com/mackerelio/mackerel-agent/logging"
+)
+
+var logger = logging.GetLogger("metrics.plugin.accesslog")
+
+var graphdef = map[string]mp.Graphs{
+	"requests.#": {
+		Label: "Access Log Requests",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "1xx", Label: "1xx", Stacked: true},
+			{Name: "2xx", Label: "2xx", Stacked: true},
+			{Name: "3xx", Label: "3xx", Stacked: true},
+			{Name: "4xx", Label: "4xx", Stacked: true},
+			{Name: "5xx", Label: "5xx", Stacked: true},
+		},
+	},
+	"bytes.#": {
+		Label: "Access Log Bytes",
+		Unit:  "bytes",
+		Metrics: []mp.Metrics{
+			{Name: "bytes", Label: "bytes"},
+		},
+	},
+	"latency.#": {
+		Label: "Access Log Response Time (sec)",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "p50", Label: "p50"},
+			{Name: "p90", Label: "p90"},
+			{Name: "p99", Label: "p99"},
+			{Name: "average", Label: "average"},
+		},
+	},
+}
+
+var statusClasses = []string{"1xx", "2xx", "3xx", "4xx", "5xx"}
+
+var nonMetricNamePattern = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+// AccesslogPlugin mackerel plugin for access logs
+type AccesslogPlugin struct {
+	Prefix  string
+	File    string
+	Posfile string
+	parser  parser
+	// Group aggregates requests by the first submatch (or the whole match) of the path
+	Group *regexp.Regexp
+}
+
+type groupStat struct {
+	status   map[string]uint64
+	bytes    float64
+	reqtimes []float64
+}
+
+// MetricKeyPrefix interface for PluginWithPrefix
+func (p AccesslogPlugin) MetricKeyPrefix() string {
+	if p.Prefix == "" {
+		p.Prefix = "accesslog"
+	}
+	return p.Prefix
+}
+
+// GraphDefinition interface for mackerelplugin
+func (p AccesslogPlugin) GraphDefinition() map[string]mp.Graphs {
+	return graphdef
+}
+
+// FetchMetrics interface for mackerelplugin
+// Metrics are the values of the lines appended since the last run.
+func (p AccesslogPlugin) FetchMetrics() (map[string]interface{}, error) {
+	groups := map[string]*groupStat{}
+	var invalid int
+	err := tail(p.File, p.Posfile, func(line string) {
+		r, err := p.parser.parse(line)
+		if err != nil {
+			invalid++
+			return
+		}
+		p.aggregate(groups, "all", r)
+		if p.Group != nil {
+			p.aggregate(groups, p.groupName(r.Path), r)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if invalid > 0 {
+		logger.Warningf("%d lines of %s cannot be parsed", invalid, p.File)
+	}
+
+	stat := make(map[string]interface{})
+	if _, ok := groups["all"]; !ok {
+		groups["all"] = &groupStat{status: map[string]uint64{}}
+	}
+	for name, g := range groups {
+		setGroupMetrics(stat, name, g)
+	}
+	return stat, nil
+}
+
+func (p AccesslogPlugin) groupName(path string) string {
+	m := p.Group.FindStringSubmatch(path)
+	if m == nil {
+		return "other"
+	}
+	name := m[0]
+	if len(m) > 1 {
+		name = m[1]
+	}
+	name = nonMetricNamePattern.ReplaceAllString(name, "_")
+	if name == "" || name == "all" {
+		return "other"
+	}
+	return name
+}
+
+func (p AccesslogPlugin) aggregate(groups map[string]*groupStat, name string, r record) {
+	g, ok := groups[name]
+	if !ok {
+		g = &groupStat{status: map[string]uint64{}}
+		groups[name] = g
+	}
+	if r.Status >= 100 && r.Status < 600 {
+		g.status[statusClasses[r.Status/100-1]]++
+	}
+	g.bytes += r.Bytes
+	if r.ReqTime >= 0 {
+		g.reqtimes = append(g.reqtimes, r.ReqTime)
+	}
+}
+
+func setGroupMetrics(stat map[string]interface{}, name string, g *groupStat) {
+	for _, c := range statusClasses {
+		stat[fmt.Sprintf("requests.%s.%s", name, c)] = float64(g.status[c])
+	}
+	stat[fmt.Sprintf("bytes.%s.bytes", name)] = g.bytes
+	if len(g.reqtimes) == 0 {
+		return
+	}
+	sort.Float64s(g.reqtimes)
+	stat[fmt.Sprintf("latency.%s.p50", name)] = percentile(g.reqtimes, 50)
+	stat[fmt.Sprintf("latency.%s.p90", name)] = percentile(g.reqtimes, 90)
+	stat[fmt.Sprintf("latency.%s.p99", name)] = percentile(g.reqtimes, 99)
+	var sum float64
+	for _, t := range g.reqtimes {
+		sum += t
+	}
+	stat[fmt.Sprintf("latency.%s.average", name)] = sum / float64(len(g.reqtimes))
+}
+
+// percentile returns the nearest-rank percentile of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+var defaultMappings = map[string]fieldMapping{
+	// nginx and Apache LTSV formats in common use
+	"ltsv": {Status: "status", Bytes: "size", ReqTime: "reqtime", Path: "uri", ReqTimeScale: 1},
+	"json": {Status: "status", Bytes: "size", ReqTime: "reqtime", Path: "uri", ReqTimeScale: 1},
+	// the path and the status of the combined format are positional
+	"combined": {ReqTimeScale: 1},
+}
+
+var reqTimeScales = map[string]float64{
+	"s":  1,
+	"ms": 0.001,
+	"us": 0.000001,
+}
+
+// Do the plugin
+func Do() {
+	optFile := flag.String("file", "", "Path to the access log")
+	optFormat := flag.String("format", "ltsv", "Format of the access log: ltsv, combined or json")
+	optStatusKey := flag.String("status-key", "", "Field name of the status code for ltsv and json (default: status)")
+	optBytesKey := flag.String("bytes-key", "", "Field name of the response size for ltsv and json (default: size)")
+	optReqTimeKey := flag.String("reqtime-key", "", "Field name of the response time for ltsv and json (default: reqtime)")
+	optPathKey := flag.String("path-key", "", "Field name of the request path for ltsv and json (default: uri)")
+	optReqTimeUnit := flag.String("reqtime-unit", "s", "Unit of the response time: s, ms or us")
+	optGroup := flag.String("group", "", "Regexp to group requests by the path (e.g. `^/(api|static)/`)")
+	optPrefix := flag.String("metric-key-prefix", "accesslog", "Metric key prefix")
+	optTempfile := flag.String("tempfile", "", "Temp file name to store the position of the log")
+	flag.Parse()
+
+	if *optFile == "" {
+		fmt.Fprintln(os.Stderr, "failed to exec mackerel-plugin-accesslog: -file is required")
+		os.Exit(1)
+	}
+	mapping, ok := defaultMappings[*optFormat]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-accesslog: unknown format %q\n", *optFormat)
+		os.Exit(1)
+	}
+	for _, o := range []struct {
+		opt   string
+		field *string
+	}{
+		{*optStatusKey, &mapping.Status},
+		{*optBytesKey, &mapping.Bytes},
+		{*optReqTimeKey, &mapping.ReqTime},
+		{*optPathKey, &mapping.Path},
+	} {
+		if o.opt != "" {
+			*o.field = o.opt
+		}
+	}
+	mapping.ReqTimeScale, ok = reqTimeScales[*optReqTimeUnit]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-accesslog: unknown reqtime-unit %q\n", *optReqTimeUnit)
+		os.Exit(1)
+	}
+
+	p := AccesslogPlugin{
+		Prefix:  *optPrefix,
+		File:    *optFile,
+		Posfile: *optTempfile,
+	}
+	p.parser, _ = newParser(*optFormat, mapping)
+	if *optGroup != "" {
+		re, err := regexp.Compile(*optGroup)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-accesslog: invalid group: %s\n", err)
+			os.Exit(1)
+		}
+		p.Group = re
+	}
+	if p.Posfile == "" {
+		dir := os.Getenv("MACKEREL_PLUGIN_WORKDIR")
+		if dir == "" {
+			dir = os.TempDir()
+		}
+		p.Posfile = filepath.Join(dir, fmt.Sprintf("mackerel-plugin-accesslog-%s", nonMetricNamePattern.ReplaceAllString(*optFile, "_")))
+	}
+
+	helper := mp.NewMackerelPlugin(p)
+	// the helper saves the values to another file since the tempfile keeps the position of the log
+	helper.Tempfile = p.Posfile + "-values"
+	helper.Run()
+}
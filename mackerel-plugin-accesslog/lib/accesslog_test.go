@@ -0,0 +1,135 @@
+package mpaccesslog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphDefinition(t *testing.T) {
+	var p AccesslogPlugin
+
+	graphdef := p.GraphDefinition()
+	if len(graphdef) != 3 {
+		t.Errorf("GraphDef's size: %d should be 3", len(graphdef))
+	}
+}
+
+func TestParseLTSV(t *testing.T) {
+	p, _ := newParser("ltsv", fieldMapping{Status: "status", Bytes: "size", ReqTime: "reqtime", Path: "uri", ReqTimeScale: 1})
+	r, err := p.parse("time:10/Oct/2026:13:55:36 +0900\thost:192.0.2.1\tmethod:GET\turi:/api/users?id=1\tstatus:200\tsize:2326\treqtime:0.012")
+	assert.Nil(t, err)
+	assert.Equal(t, record{Status: 200, Bytes: 2326, ReqTime: 0.012, Path: "/api/users?id=1"}, r)
+
+	// Apache's %D is in microseconds
+	p, _ = newParser("ltsv", fieldMapping{Status: "status", Bytes: "size", ReqTime: "taken", Path: "uri", ReqTimeScale: 0.000001})
+	r, err = p.parse("status:304\tsize:-\ttaken:1500\turi:/")
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, r.Bytes)
+	assert.InDelta(t, 0.0015, r.ReqTime, 0.0000001)
+
+	_, err = p.parse("this is not ltsv")
+	assert.NotNil(t, err)
+}
+
+func TestParseJSON(t *testing.T) {
+	p, _ := newParser("json", fieldMapping{Status: "code", Bytes: "bytes", ReqTime: "duration_ms", Path: "path", ReqTimeScale: 0.001})
+	r, err := p.parse(`{"code": "503", "bytes": 512, "duration_ms": 250, "path": "/static/app.js"}`)
+	assert.Nil(t, err)
+	assert.Equal(t, record{Status: 503, Bytes: 512, ReqTime: 0.25, Path: "/static/app.js"}, r)
+
+	r, err = p.parse(`{"code": 200, "path": "/"}`)
+	assert.Nil(t, err)
+	assert.EqualValues(t, -1, r.ReqTime)
+}
+
+func TestParseCombined(t *testing.T) {
+	p, _ := newParser("combined", fieldMapping{ReqTimeScale: 1})
+	r, err := p.parse(`192.0.2.1 - frank [10/Oct/2026:13:55:36 +0900] "GET /index.html HTTP/1.1" 200 2326 "http://example.com/" "Mozilla/5.0 (X11; \"Linux\")" 0.012`)
+	assert.Nil(t, err)
+	assert.Equal(t, record{Status: 200, Bytes: 2326, ReqTime: 0.012, Path: "/index.html"}, r)
+
+	// common log format without response time
+	r, err = p.parse(`192.0.2.1 - - [10/Oct/2026:13:55:36 +0900] "POST /api/login HTTP/1.1" 401 -`)
+	assert.Nil(t, err)
+	assert.Equal(t, record{Status: 401, Bytes: 0, ReqTime: -1, Path: "/api/login"}, r)
+}
+
+func TestPercentile(t *testing.T) {
+	values := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	assert.EqualValues(t, 5, percentile(values, 50))
+	assert.EqualValues(t, 9, percentile(values, 90))
+	assert.EqualValues(t, 10, percentile(values, 99))
+	assert.EqualValues(t, 3, percentile([]float64{3}, 99))
+}
+
+func TestFetchMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mackerel-plugin-accesslog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	logfile := filepath.Join(dir, "access.log")
+	posfile := filepath.Join(dir, "pos")
+
+	parser, _ := newParser("ltsv", defaultMappings["ltsv"])
+	p := AccesslogPlugin{File: logfile, Posfile: posfile, parser: parser, Group: regexp.MustCompile(`^/(api|static)/`)}
+
+	// the first run only saves the position
+	assert.Nil(t, ioutil.WriteFile(logfile, []byte("status:200\tsize:100\treqtime:1\turi:/\n"), 0644))
+	stat, err := p.FetchMetrics()
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, stat["requests.all.2xx"])
+
+	appendLog(t, logfile, "status:200\tsize:100\treqtime:0.1\turi:/api/a\n"+
+		"status:500\tsize:10\treqtime:0.5\turi:/api/b\n"+
+		"status:404\tsize:20\treqtime:0.2\turi:/favicon.ico\n"+
+		"broken line\n"+
+		"status:200\tsize:30\treqtime:0.3\turi:/static/app.js\n"+
+		"status:200\tsize:999\treqtime:9\turi:/api/being-written")
+	stat, err = p.FetchMetrics()
+	assert.Nil(t, err)
+	assert.EqualValues(t, 2, stat["requests.all.2xx"])
+	assert.EqualValues(t, 1, stat["requests.all.4xx"])
+	assert.EqualValues(t, 1, stat["requests.all.5xx"])
+	assert.EqualValues(t, 160, stat["bytes.all.bytes"])
+	assert.EqualValues(t, 0.2, stat["latency.all.p50"])
+	assert.EqualValues(t, 0.5, stat["latency.all.p99"])
+	assert.InDelta(t, 0.275, stat["latency.all.average"], 0.0001)
+	assert.EqualValues(t, 1, stat["requests.api.5xx"])
+	assert.EqualValues(t, 1, stat["requests.static.2xx"])
+	assert.EqualValues(t, 1, stat["requests.other.4xx"])
+
+	// the rest of the line being written and rotation
+	appendLog(t, logfile, "\nstatus:302\tsize:0\treqtime:0.01\turi:/\n")
+	assert.Nil(t, os.Rename(logfile, logfile+".1"))
+	assert.Nil(t, ioutil.WriteFile(logfile, []byte("status:201\tsize:1\treqtime:0.02\turi:/api/c\n"), 0644))
+	stat, err = p.FetchMetrics()
+	assert.Nil(t, err)
+	assert.EqualValues(t, 2, stat["requests.all.2xx"])
+	assert.EqualValues(t, 1, stat["requests.all.3xx"])
+	assert.EqualValues(t, 1000, stat["bytes.all.bytes"])
+
+	// truncation
+	assert.Nil(t, ioutil.WriteFile(logfile, []byte("status:503\tsize:5\treqtime:1\turi:/\n"), 0644))
+	stat, err = p.FetchMetrics()
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, stat["requests.all.5xx"])
+	assert.EqualValues(t, 0, stat["requests.all.2xx"])
+
+	// no new lines
+	stat, err = p.FetchMetrics()
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, stat["requests.all.5xx"])
+	assert.Nil(t, stat["latency.all.p50"])
+}
+
+func appendLog(t *testing.T, file, lines string) {
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	defer f.Close()
+	_, err = f.WriteString(lines)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,135 @@
+package mpaccesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// record is a parsed line of an access log
+type record struct {
+	Status int
+	Bytes  float64
+	// ReqTime is the response time in seconds, or negative if the log has no response time
+	ReqTime float64
+	Path    string
+}
+
+// fieldMapping maps the fields of LTSV and JSON logs to a record
+type fieldMapping struct {
+	Status  string
+	Bytes   string
+	ReqTime string
+	Path    string
+	// ReqTimeScale converts the response time to seconds, e.g. 0.001 for milliseconds
+	ReqTimeScale float64
+}
+
+type parser interface {
+	parse(line string) (record, error)
+}
+
+func newParser(format string, m fieldMapping) (parser, error) {
+	switch format {
+	case "ltsv":
+		return ltsvParser{m}, nil
+	case "json":
+		return jsonParser{m}, nil
+	case "combined":
+		return combinedParser{m}, nil
+	}
+	return nil, fmt.Errorf("unknown format: %s", format)
+}
+
+// reqtime:0.012	status:200	size:1024	uri:/index.html
+type ltsvParser struct {
+	mapping fieldMapping
+}
+
+func (p ltsvParser) parse(line string) (record, error) {
+	fields := make(map[string]string)
+	for _, f := range strings.Split(line, "\t") {
+		kv := strings.SplitN(f, ":", 2)
+		if len(kv) == 2 {
+			fields[kv[0]] = kv[1]
+		}
+	}
+	return p.mapping.record(func(key string) (string, bool) {
+		v, ok := fields[key]
+		return v, ok
+	})
+}
+
+// {"status": 200, "size": 1024, "reqtime": 0.012, "uri": "/index.html"}
+type jsonParser struct {
+	mapping fieldMapping
+}
+
+func (p jsonParser) parse(line string) (record, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return record{}, err
+	}
+	return p.mapping.record(func(key string) (string, bool) {
+		v, ok := fields[key]
+		if !ok || v == nil {
+			return "", false
+		}
+		switch v := v.(type) {
+		case string:
+			return v, true
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), true
+		}
+		return fmt.Sprint(v), true
+	})
+}
+
+func (m fieldMapping) record(get func(string) (string, bool)) (record, error) {
+	r := record{ReqTime: -1}
+	status, ok := get(m.Status)
+	if !ok {
+		return r, fmt.Errorf("%s is not found", m.Status)
+	}
+	var err error
+	r.Status, err = strconv.Atoi(status)
+	if err != nil {
+		return r, err
+	}
+	if v, ok := get(m.Bytes); ok {
+		// "-" for no body
+		r.Bytes, _ = strconv.ParseFloat(v, 64)
+	}
+	if v, ok := get(m.ReqTime); ok {
+		if t, err := strconv.ParseFloat(v, 64); err == nil {
+			r.ReqTime = t * m.ReqTimeScale
+		}
+	}
+	r.Path, _ = get(m.Path)
+	return r, nil
+}
+
+// 192.0.2.1 - - [10/Oct/2026:13:55:36 +0900] "GET /index.html HTTP/1.1" 200 2326 "http://example.com/" "Mozilla/5.0" 0.012
+//
+// A number after the combined format, such as $request_time of nginx, is taken as the response time.
+var combinedPattern = regexp.MustCompile(`^\S+ \S+ \S+ \[[^\]]+\] "\S+ (\S+)[^"]*" (\d{3}) (\d+|-)(?: "(?:[^"\\]|\\.)*" "(?:[^"\\]|\\.)*")?\s*(\S*)`)
+
+type combinedParser struct {
+	mapping fieldMapping
+}
+
+func (p combinedParser) parse(line string) (record, error) {
+	m := combinedPattern.FindStringSubmatch(line)
+	if m == nil {
+		return record{}, fmt.Errorf("not in the combined format: %s", line)
+	}
+	r := record{Path: m[1], ReqTime: -1}
+	r.Status, _ = strconv.Atoi(m[2])
+	r.Bytes, _ = strconv.ParseFloat(m[3], 64)
+	if t, err := strconv.ParseFloat(m[4], 64); err == nil {
+		r.ReqTime = t * p.mapping.ReqTimeScale
+	}
+	return r, nil
+}
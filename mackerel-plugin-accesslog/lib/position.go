@@ -0,0 +1,117 @@
+package mpaccesslog
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"syscall"
+)
+
+// position of the log which has been read, saved in the tempfile
+type position struct {
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+}
+
+func loadPosition(path string) (*position, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var pos position
+	if err := json.Unmarshal(content, &pos); err != nil {
+		return nil, err
+	}
+	return &pos, nil
+}
+
+func savePosition(path string, pos position) error {
+	content, err := json.Marshal(pos)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, content, 0644)
+}
+
+func inode(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
+
+// readLines calls fn for each complete line of the file after offset and returns the offset
+// after the last complete line. A line being written (without a newline) is left for the next run.
+func readLines(f *os.File, offset int64, fn func(string)) (int64, error) {
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+		offset += int64(len(line))
+		line = line[:len(line)-1]
+		if len(line) > 0 && line[len(line)-1] == '\r' {
+			line = line[:len(line)-1]
+		}
+		fn(line)
+	}
+}
+
+// tail reads the lines appended to the log since the position saved in posfile.
+//
+//   - On the first run, the log is not read and the position is set to its end.
+//   - When the log is rotated (its inode is changed), the rest of the old log is read
+//     from "<file>.1" if it exists, and then the new log is read from the beginning.
+//   - When the log is truncated, it is read from the beginning.
+func tail(file, posfile string, fn func(string)) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	cur := position{Inode: inode(fi)}
+
+	last, err := loadPosition(posfile)
+	if err != nil {
+		cur.Offset = fi.Size()
+		return savePosition(posfile, cur)
+	}
+
+	if last.Inode != cur.Inode {
+		readRotated(file+".1", *last, fn)
+	} else if fi.Size() >= last.Offset {
+		cur.Offset = last.Offset
+	}
+
+	cur.Offset, err = readLines(f, cur.Offset, fn)
+	if err != nil {
+		return err
+	}
+	return savePosition(posfile, cur)
+}
+
+func readRotated(file string, last position, fn func(string)) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || inode(fi) != last.Inode || fi.Size() < last.Offset {
+		return
+	}
+	readLines(f, last.Offset, fn)
+}
@@ -1,6 +1,8 @@
 package mpapache2
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -16,13 +18,21 @@ import (
 
 // Apache2Plugin for fetching metrics
 type Apache2Plugin struct {
+	Scheme      string
 	Host        string
 	Port        uint16
 	Path        string
+	URI         string
 	Header      []string
+	User        string
+	Password    string
+	CAFile      string
+	SkipVerify  bool
 	Tempfile    string
 	Prefix      string
 	LabelPrefix string
+	// MaxRequestWorkers is the number of scoreboard slots (ServerLimit x ThreadLimit) if 0
+	MaxRequestWorkers int
 }
 
 // MetricKeyPrefix interface for PluginWithPrefix
@@ -47,6 +57,13 @@ func (c Apache2Plugin) GraphDefinition() map[string]mp.Graphs {
 				{Name: "idle_workers", Label: "Idle Workers", Diff: false, Stacked: true},
 			},
 		},
+		"workers_usage": {
+			Label: (labelPrefix + " Busy Workers Usage"),
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				{Name: "busy_percentage", Label: "Busy Workers", Diff: false},
+			},
+		},
 		"bytes": {
 			Label: (labelPrefix + " Bytes"),
 			Unit:  "bytes",
@@ -61,6 +78,34 @@ func (c Apache2Plugin) GraphDefinition() map[string]mp.Graphs {
 				{Name: "cpu_load", Label: "CPU Load", Diff: false},
 			},
 		},
+		// CPU seconds are cumulative, so the difference per minute is converted to percentage of one core
+		"cpu_usage": {
+			Label: (labelPrefix + " CPU Usage"),
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				{Name: "cpu_user", Label: "User", Diff: true, Stacked: true, Scale: 100.0 / 60},
+				{Name: "cpu_system", Label: "System", Diff: true, Stacked: true, Scale: 100.0 / 60},
+				{Name: "cpu_children_user", Label: "Children User", Diff: true, Stacked: true, Scale: 100.0 / 60},
+				{Name: "cpu_children_system", Label: "Children System", Diff: true, Stacked: true, Scale: 100.0 / 60},
+			},
+		},
+		"connections": {
+			Label: (labelPrefix + " Connections"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "conns_total", Label: "Total", Diff: false},
+				{Name: "conns_async_writing", Label: "Async Writing", Diff: false},
+				{Name: "conns_async_keep_alive", Label: "Async Keep-Alive", Diff: false},
+				{Name: "conns_async_closing", Label: "Async Closing", Diff: false},
+			},
+		},
+		"uptime": {
+			Label: (labelPrefix + " Uptime"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "uptime", Label: "Uptime", Diff: false},
+			},
+		},
 		"req": {
 			Label: (labelPrefix + " Requests"),
 			Unit:  "integer",
@@ -94,10 +139,17 @@ func doMain(c *cli.Context) error {
 
 	var apache2 Apache2Plugin
 
+	apache2.Scheme = c.String("scheme")
 	apache2.Host = c.String("http_host")
 	apache2.Port = uint16(c.Int("http_port"))
 	apache2.Path = c.String("status_page")
+	apache2.URI = c.String("uri")
 	apache2.Header = c.StringSlice("header")
+	apache2.User = c.String("user")
+	apache2.Password = c.String("password")
+	apache2.CAFile = c.String("ca-file")
+	apache2.SkipVerify = c.Bool("insecure-skip-verify")
+	apache2.MaxRequestWorkers = c.Int("max-request-workers")
 	apache2.Prefix = c.String("metric-key-prefix")
 	apache2.LabelPrefix = c.String("metric-label-prefix")
 
@@ -110,7 +162,11 @@ func doMain(c *cli.Context) error {
 
 // FetchMetrics fetch the metrics
 func (c Apache2Plugin) FetchMetrics() (map[string]interface{}, error) {
-	data, err := getApache2Metrics(c.Host, c.Port, c.Path, c.Header)
+	client, err := c.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	data, err := fetchApache2Status(client, c.statusURI(), c.Header, c.User, c.Password)
 	if err != nil {
 		return nil, err
 	}
@@ -124,10 +180,65 @@ func (c Apache2Plugin) FetchMetrics() (map[string]interface{}, error) {
 	if errScore != nil {
 		return nil, errScore
 	}
+	setBusyPercentage(&stat, c.MaxRequestWorkers)
 
 	return stat, nil
 }
 
+func (c Apache2Plugin) statusURI() string {
+	if c.URI != "" {
+		return c.URI
+	}
+	scheme := c.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	return scheme + "://" + c.Host + ":" + strconv.FormatUint(uint64(c.Port), 10) + c.Path
+}
+
+func (c Apache2Plugin) httpClient() (*http.Client, error) {
+	if c.CAFile == "" && !c.SkipVerify {
+		return http.DefaultClient, nil
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: c.SkipVerify}
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates are found in %s", c.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}}, nil
+}
+
+// setBusyPercentage calculates the percentage of busy workers to MaxRequestWorkers.
+// The number of scoreboard slots, which is ServerLimit x ThreadLimit, is used if maxWorkers is 0.
+func setBusyPercentage(p *map[string]interface{}, maxWorkers int) {
+	busy, ok := (*p)["busy_workers"].(float64)
+	if !ok {
+		return
+	}
+	max := float64(maxWorkers)
+	if max <= 0 {
+		for k, v := range *p {
+			if strings.HasPrefix(k, "score-") {
+				max += v.(float64)
+			}
+		}
+	}
+	if max <= 0 {
+		return
+	}
+	(*p)["busy_percentage"] = busy * 100 / max
+}
+
 // parsing scoreboard from server-status?auto
 func parseApache2Scoreboard(str string, p *map[string]interface{}) error {
 	for _, line := range strings.Split(str, "\n") {
@@ -156,11 +267,20 @@ func parseApache2Scoreboard(str string, p *map[string]interface{}) error {
 // parsing metrics from server-status?auto
 func parseApache2Status(str string, p *map[string]interface{}) error {
 	Params := map[string]string{
-		"Total Accesses": "requests",
-		"Total kBytes":   "bytes_sent",
-		"CPULoad":        "cpu_load",
-		"BusyWorkers":    "busy_workers",
-		"IdleWorkers":    "idle_workers"}
+		"Total Accesses":      "requests",
+		"Total kBytes":        "bytes_sent",
+		"CPULoad":             "cpu_load",
+		"CPUUser":             "cpu_user",
+		"CPUSystem":           "cpu_system",
+		"CPUChildrenUser":     "cpu_children_user",
+		"CPUChildrenSystem":   "cpu_children_system",
+		"Uptime":              "uptime",
+		"BusyWorkers":         "busy_workers",
+		"IdleWorkers":         "idle_workers",
+		"ConnsTotal":          "conns_total",
+		"ConnsAsyncWriting":   "conns_async_writing",
+		"ConnsAsyncKeepAlive": "conns_async_keep_alive",
+		"ConnsAsyncClosing":   "conns_async_closing"}
 
 	for _, line := range strings.Split(str, "\n") {
 		record := strings.Split(line, ":")
@@ -182,13 +302,15 @@ func parseApache2Status(str string, p *map[string]interface{}) error {
 	return nil
 }
 
-// Getting apache2 status from server-status module data.
-func getApache2Metrics(host string, port uint16, path string, header []string) (string, error) {
-	uri := "http://" + host + ":" + strconv.FormatUint(uint64(port), 10) + path
+// fetchApache2Status gets server-status with headers and basic authentication if user is set.
+func fetchApache2Status(client *http.Client, uri string, header []string, user, password string) (string, error) {
 	req, err := http.NewRequest("GET", uri, nil)
 	if err != nil {
 		return "", err
 	}
+	if user != "" {
+		req.SetBasicAuth(user, password)
+	}
 	for _, h := range header {
 		kv := strings.SplitN(h, ":", 2)
 		var k, v string
@@ -202,7 +324,7 @@ func getApache2Metrics(host string, port uint16, path string, header []string) (
 			req.Header.Set(k, v)
 		}
 	}
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -4,8 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"regexp"
-	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -70,16 +69,9 @@ Scoreboard: W_.__...........................`
 				fmt.Fprintln(w, stub)
 			}))
 	defer ts.Close()
-	re, _ := regexp.Compile("([a-z]+)://([A-Za-z0-9.]+):([0-9]+)(.*)")
-	found := re.FindStringSubmatch(ts.URL)
-	assert.EqualValues(t, len(found), 5, fmt.Sprintf("Test stub uri format is changed. %s", ts.URL))
+	header := []string{fmt.Sprintf("Host: %s", strings.TrimPrefix(ts.URL, "http://")), "X-Text-Header: test"}
 
-	host := found[2]
-	port, _ := strconv.Atoi(found[3])
-	path := found[4]
-	header := []string{fmt.Sprintf("Host: %s", found[2]), "X-Text-Header: test"}
-
-	ret, err := getApache2Metrics(host, uint16(port), path, header)
+	ret, err := fetchApache2Status(http.DefaultClient, ts.URL, header, "", "")
 	assert.Nil(t, err)
 	assert.NotNil(t, ret)
 	assert.NotEmpty(t, ret)
@@ -90,3 +82,81 @@ Scoreboard: W_.__...........................`
 	assert.Contains(t, ret, "IdleWorkers")
 	assert.Contains(t, ret, "Scoreboard")
 }
+
+func TestParseApache2StatusEvent(t *testing.T) {
+	stub := `Total Accesses: 358
+Total kBytes: 20
+CPUUser: 1.5
+CPUSystem: .75
+CPUChildrenUser: 0
+CPUChildrenSystem: 0
+CPULoad: .00117358
+Uptime: 102251
+BusyWorkers: 3
+IdleWorkers: 9
+ConnsTotal: 5
+ConnsAsyncWriting: 1
+ConnsAsyncKeepAlive: 2
+ConnsAsyncClosing: 0
+Scoreboard: W_W_W_______........
+`
+	stat := make(map[string]interface{})
+
+	err := parseApache2Status(stub, &stat)
+	assert.Nil(t, err)
+	assert.EqualValues(t, stat["cpu_user"], 1.5)
+	assert.EqualValues(t, stat["cpu_system"], 0.75)
+	assert.EqualValues(t, stat["cpu_children_user"], 0)
+	assert.EqualValues(t, stat["uptime"], 102251)
+	assert.EqualValues(t, stat["conns_total"], 5)
+	assert.EqualValues(t, stat["conns_async_writing"], 1)
+	assert.EqualValues(t, stat["conns_async_keep_alive"], 2)
+	assert.EqualValues(t, stat["conns_async_closing"], 0)
+
+	err = parseApache2Scoreboard(stub, &stat)
+	assert.Nil(t, err)
+	setBusyPercentage(&stat, 0)
+	assert.EqualValues(t, stat["busy_percentage"], 15)
+	setBusyPercentage(&stat, 150)
+	assert.EqualValues(t, stat["busy_percentage"], 2)
+}
+
+func TestFetchMetricsTLS(t *testing.T) {
+	stub := `Total Accesses: 668
+BusyWorkers: 1
+IdleWorkers: 3
+Scoreboard: W___`
+
+	ts := httptest.NewTLSServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				user, password, ok := r.BasicAuth()
+				if !ok || user != "mackerel" || password != "secret" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				fmt.Fprintln(w, stub)
+			}))
+	defer ts.Close()
+
+	p := Apache2Plugin{URI: ts.URL + "/server-status?auto", User: "mackerel", Password: "secret"}
+	_, err := p.FetchMetrics()
+	assert.NotNil(t, err, "certificate of the test server should not be trusted")
+
+	p.SkipVerify = true
+	stat, err := p.FetchMetrics()
+	assert.Nil(t, err)
+	assert.EqualValues(t, stat["requests"], 668)
+	assert.EqualValues(t, stat["busy_percentage"], 25)
+
+	p.Password = "wrong"
+	_, err = p.FetchMetrics()
+	assert.NotNil(t, err)
+}
+
+func TestStatusURI(t *testing.T) {
+	p := Apache2Plugin{Host: "127.0.0.1", Port: 443, Path: "/server-status?auto", Scheme: "https"}
+	assert.Equal(t, "https://127.0.0.1:443/server-status?auto", p.statusURI())
+	p.URI = "https://example.com/status?auto"
+	assert.Equal(t, "https://example.com/status?auto", p.statusURI())
+}
@@ -5,15 +5,28 @@ import (
 )
 
 var flags = []cli.Flag{
+	cliScheme,
 	cliHTTPHost,
 	cliHTTPPort,
 	cliHeader,
 	cliStatusPage,
+	cliURI,
+	cliUser,
+	cliPassword,
+	cliCAFile,
+	cliSkipVerify,
+	cliMaxRequestWorkers,
 	cliTempFile,
 	cliMetricKerPrefix,
 	cliLabelPrefix,
 }
 
+var cliScheme = cli.StringFlag{
+	Name:  "scheme",
+	Value: "http",
+	Usage: "Set scheme (http or https).",
+}
+
 var cliHTTPHost = cli.StringFlag{
 	Name:   "http_host, o",
 	Value:  "127.0.0.1",
@@ -42,6 +55,38 @@ var cliStatusPage = cli.StringFlag{
 	EnvVar: "ENVVAR_STATUS_PAGE",
 }
 
+var cliURI = cli.StringFlag{
+	Name:  "uri, u",
+	Usage: "Set URI of the status page. (e.g. \"https://127.0.0.1/server-status?auto\") Overrides scheme, http_host, http_port and status_page.",
+}
+
+var cliUser = cli.StringFlag{
+	Name:  "user",
+	Usage: "Set user name for basic authentication.",
+}
+
+var cliPassword = cli.StringFlag{
+	Name:   "password",
+	Usage:  "Set password for basic authentication.",
+	EnvVar: "APACHE2_PASSWORD",
+}
+
+var cliCAFile = cli.StringFlag{
+	Name:  "ca-file",
+	Usage: "Set CA certificate file to verify the server.",
+}
+
+var cliSkipVerify = cli.BoolFlag{
+	Name:  "insecure-skip-verify",
+	Usage: "Skip verification of the server certificate.",
+}
+
+var cliMaxRequestWorkers = cli.IntFlag{
+	Name:  "max-request-workers",
+	Value: 0,
+	Usage: "Set MaxRequestWorkers to calculate busy workers usage. The number of scoreboard slots (ServerLimit x ThreadLimit) is used if 0.",
+}
+
 var cliTempFile = cli.StringFlag{
 	Name:   "tempfile, t",
 	Usage:  "Set temporary file path.",
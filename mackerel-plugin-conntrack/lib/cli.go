@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"os"
 
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
 )
@@ -15,7 +16,9 @@ const (
 )
 
 // ConntrackPlugin mackerel plugin for *_conntrack.
-type ConntrackPlugin struct{}
+type ConntrackPlugin struct {
+	Detail bool
+}
 
 // GraphDefinition interface for mackerelplugin.
 func (c ConntrackPlugin) GraphDefinition() map[string]mp.Graphs {
@@ -28,6 +31,39 @@ func (c ConntrackPlugin) GraphDefinition() map[string]mp.Graphs {
 				{Name: "*", Label: "%1", Diff: false, Stacked: true, Type: "uint64"},
 			},
 		},
+		"conntrack.usage": {
+			Label: "Conntrack Usage",
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				{Name: "percentage", Label: "Usage", Diff: false},
+			},
+		},
+	}
+
+	if c.Detail {
+		graphdef["conntrack.protocol"] = mp.Graphs{
+			Label: "Conntrack Entries by Protocol",
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "*", Label: "%1", Diff: false, Stacked: true, Type: "uint64"},
+			},
+		}
+		graphdef["conntrack.tcp_state"] = mp.Graphs{
+			Label: "Conntrack TCP Entries by State",
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "*", Label: "%1", Diff: false, Stacked: true, Type: "uint64"},
+			},
+		}
+		var metrics []mp.Metrics
+		for _, name := range ConntrackStatFields {
+			metrics = append(metrics, mp.Metrics{Name: name, Label: name, Diff: true, Type: "uint64"})
+		}
+		graphdef["conntrack.stat"] = mp.Graphs{
+			Label:   "Conntrack Events",
+			Unit:    "integer",
+			Metrics: metrics,
+		}
 	}
 
 	return graphdef
@@ -48,10 +84,42 @@ func (c ConntrackPlugin) FetchMetrics() (map[string]interface{}, error) {
 	stat := make(map[string]interface{})
 	stat["conntrack.count.used"] = conntrackCount
 	stat["conntrack.count.free"] = (conntrackMax - conntrackCount)
+	if conntrackMax > 0 {
+		stat["percentage"] = float64(conntrackCount) * 100 / float64(conntrackMax)
+	}
+
+	if c.Detail {
+		c.fetchDetail(stat)
+	}
 
 	return stat, nil
 }
 
+// fetchDetail adds the breakdown of the table and per-CPU statistics.
+// They are optional, so failures are reported but do not drop the basic metrics.
+func (c ConntrackPlugin) fetchDetail(stat map[string]interface{}) {
+	protocols, tcpStates, err := TableEntries(ConntrackTablePaths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read conntrack table: %s\n", err)
+	} else {
+		for proto, n := range protocols {
+			stat["conntrack.protocol."+proto] = n
+		}
+		for state, n := range tcpStates {
+			stat["conntrack.tcp_state."+state] = n
+		}
+	}
+
+	stats, err := StatValues(ConntrackStatPaths, ConntrackStatFields)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read conntrack statistics: %s\n", err)
+	} else {
+		for name, n := range stats {
+			stat[name] = n
+		}
+	}
+}
+
 // CLI is the object for command line interface.
 type CLI struct {
 	outStream, errStream io.Writer
@@ -62,6 +130,7 @@ func (c *CLI) Run(args []string) int {
 	// Flags
 	var (
 		tempfile string
+		detail   bool
 		version  bool
 	)
 
@@ -69,6 +138,7 @@ func (c *CLI) Run(args []string) int {
 	flags := flag.NewFlagSet(Name, flag.ContinueOnError)
 	flags.BoolVar(&version, "version", false, "Print version information and quit.")
 	flags.StringVar(&tempfile, "tempfile", "/tmp/mackerel-plugin-conntrack", "Temp file name")
+	flags.BoolVar(&detail, "detail", false, "Fetch entries by protocol and TCP state, and per-CPU statistics.")
 
 	// Parse commandline flag
 	if err := flags.Parse(args[1:]); err != nil {
@@ -82,7 +152,7 @@ func (c *CLI) Run(args []string) int {
 	}
 
 	// Create MackerelPlugin for Conntrack
-	var cp ConntrackPlugin
+	cp := ConntrackPlugin{Detail: detail}
 	helper := mp.NewMackerelPlugin(cp)
 	helper.Tempfile = tempfile
 
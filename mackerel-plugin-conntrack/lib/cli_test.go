@@ -11,8 +11,14 @@ func TestGraphDefinition(t *testing.T) {
 	var conntrack ConntrackPlugin
 
 	graphdef := conntrack.GraphDefinition()
-	if len(graphdef) != 1 {
-		t.Errorf("GetTempfilename: %d should be 1", len(graphdef))
+	if len(graphdef) != 2 {
+		t.Errorf("GetTempfilename: %d should be 2", len(graphdef))
+	}
+
+	conntrack.Detail = true
+	graphdef = conntrack.GraphDefinition()
+	if len(graphdef) != 5 {
+		t.Errorf("GetTempfilename: %d should be 5", len(graphdef))
 	}
 }
 
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 )
 
 // ConntrackCountPaths is paths to conntrack_count files.
@@ -21,6 +22,26 @@ var ConntrackMaxPaths = []string{
 	"/proc/sys/net/ipv4/netfilter/ip_conntrack_max",
 }
 
+// ConntrackTablePaths is paths to conntrack table files.
+var ConntrackTablePaths = []string{
+	"/proc/net/nf_conntrack",
+	"/proc/net/ip_conntrack",
+}
+
+// ConntrackStatPaths is paths to per-CPU conntrack statistics files.
+var ConntrackStatPaths = []string{
+	"/proc/net/stat/nf_conntrack",
+	"/proc/net/stat/ip_conntrack",
+}
+
+// ConntrackStatFields is the per-CPU statistics reported as rates.
+var ConntrackStatFields = []string{
+	"insert_failed",
+	"drop",
+	"early_drop",
+	"search_restart",
+}
+
 // Exists returns whether file exists or not.
 func Exists(f string) bool {
 	_, err := os.Stat(f)
@@ -67,3 +88,100 @@ func CurrentValue(paths []string) (n uint64, err error) {
 	n, err = strconv.ParseUint(cnt, 10, 64)
 	return n, nil
 }
+
+// TableEntries counts conntrack entries by L4 protocol and by TCP state.
+//
+//	$ cat /proc/net/nf_conntrack
+//	ipv4     2 tcp      6 431999 ESTABLISHED src=10.0.0.5 dst=10.0.0.10 sport=52344 dport=5432 ...
+//	ipv4     2 udp      17 27 src=10.0.0.5 dst=10.0.0.2 sport=41234 dport=53 ...
+//
+// /proc/net/ip_conntrack on old kernels lacks the leading L3 columns.
+func TableEntries(paths []string) (protocols map[string]uint64, tcpStates map[string]uint64, err error) {
+	path, err := FindFile(paths)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	protocols = make(map[string]uint64)
+	tcpStates = make(map[string]uint64)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 && (fields[0] == "ipv4" || fields[0] == "ipv6") {
+			fields = fields[2:]
+		}
+		// l4 name, l4 number, timeout, (state)
+		if len(fields) < 3 {
+			continue
+		}
+		proto := strings.ToLower(fields[0])
+		protocols[proto]++
+		if proto == "tcp" && len(fields) > 3 && !strings.Contains(fields[3], "=") {
+			tcpStates[strings.ToLower(fields[3])]++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return protocols, tcpStates, nil
+}
+
+// StatValues sums per-CPU conntrack statistics of the given fields.
+//
+//	$ cat /proc/net/stat/nf_conntrack
+//	entries  searched found new invalid ignore delete delete_list insert insert_failed drop early_drop ...
+//	00000009  00000000 00000000 00000000 00000012 00002e1a 00000000 00000000 00000000 00000003 00000001 ...
+//
+// Columns are looked up by the header because they differ between kernel versions.
+func StatValues(paths []string, names []string) (map[string]uint64, error) {
+	path, err := FindFile(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("Empty file %s", path)
+	}
+	index := make(map[string]int)
+	for i, name := range strings.Fields(scanner.Text()) {
+		index[name] = i
+	}
+
+	stats := make(map[string]uint64)
+	for scanner.Scan() {
+		values := strings.Fields(scanner.Text())
+		for _, name := range names {
+			i, ok := index[name]
+			if !ok || i >= len(values) {
+				continue
+			}
+			v, err := strconv.ParseUint(values[i], 16, 64)
+			if err != nil {
+				return nil, err
+			}
+			stats[name] += v
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
@@ -2,6 +2,7 @@ package mpconntrack
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 )
 
@@ -25,3 +26,32 @@ func TestCurrentValue(t *testing.T) {
 		t.Errorf("expect %q to be equal %q", err, expect)
 	}
 }
+
+func TestTableEntries(t *testing.T) {
+	protocols, tcpStates, err := TableEntries([]string{"./sample/nf_conntrack"})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expectProtocols := map[string]uint64{"tcp": 5, "udp": 2, "icmp": 1, "icmpv6": 1}
+	if !reflect.DeepEqual(protocols, expectProtocols) {
+		t.Errorf("expect %v to be equal %v", protocols, expectProtocols)
+	}
+
+	expectStates := map[string]uint64{"established": 3, "time_wait": 1, "syn_sent": 1}
+	if !reflect.DeepEqual(tcpStates, expectStates) {
+		t.Errorf("expect %v to be equal %v", tcpStates, expectStates)
+	}
+}
+
+func TestStatValues(t *testing.T) {
+	stats, err := StatValues([]string{"./sample/stat/nf_conntrack"}, ConntrackStatFields)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	expect := map[string]uint64{"insert_failed": 5, "drop": 1, "early_drop": 1, "search_restart": 16}
+	if !reflect.DeepEqual(stats, expect) {
+		t.Errorf("expect %v to be equal %v", stats, expect)
+	}
+}
@@ -0,0 +1,216 @@
+package mpgostats
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+)
+
+/*
+expvar publishes cmdline, memstats (runtime.MemStats) and the variables of the application at /debug/vars.
+
+{
+  "cmdline": ["./server"],
+  "goroutines": 12,
+  "memstats": {"Alloc": 213360, "TotalAlloc": 213360, "Sys": 3377400, ..., "PauseNs": [...], "NumGC": 3, ...},
+  "requests": 1027,
+  "responses": {"200": 1000, "404": 27}
+}
+*/
+
+// goroutinesVar is the conventional name of the variable of runtime.NumGoroutine,
+// which expvar does not publish by default
+const goroutinesVar = "goroutines"
+
+var invalidVarChars = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+func (m GostatsPlugin) expvarGraphDefinition() map[string]mp.Graphs {
+	labelPrefix := strings.Title(m.Prefix)
+	return map[string]mp.Graphs{
+		(m.Prefix + ".runtime"): {
+			Label: (labelPrefix + " Runtime"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "goroutine_num", Label: "Gorotine Num"},
+			},
+		},
+		(m.Prefix + ".memory"): {
+			Label: (labelPrefix + " Memory"),
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "memory_alloc", Label: "Alloc"},
+				{Name: "memory_sys", Label: "Sys"},
+				{Name: "memory_stack", Label: "Stack In Use"},
+			},
+		},
+		(m.Prefix + ".operation"): {
+			Label: (labelPrefix + " Operation"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "memory_lookups", Label: "Pointer Lookups", Diff: true},
+				{Name: "memory_mallocs", Label: "Mallocs", Diff: true},
+				{Name: "memory_frees", Label: "Frees", Diff: true},
+			},
+		},
+		(m.Prefix + ".heap"): {
+			Label: (labelPrefix + " Heap"),
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "heap_alloc", Label: "Alloc"},
+				{Name: "heap_sys", Label: "Sys"},
+				{Name: "heap_idle", Label: "Idle"},
+				{Name: "heap_inuse", Label: "In Use"},
+				{Name: "heap_released", Label: "Released"},
+			},
+		},
+		(m.Prefix + ".gc"): {
+			Label: (labelPrefix + " GC"),
+			Unit:  "float",
+			Metrics: []mp.Metrics{
+				{Name: "gc_num", Label: "GC Num", Diff: true},
+			},
+		},
+		(m.Prefix + ".gc_pause"): {
+			Label: (labelPrefix + " GC Pause"),
+			Unit:  "milliseconds",
+			Metrics: []mp.Metrics{
+				{Name: "gc_pause_p50", Label: "50th percentile"},
+				{Name: "gc_pause_p90", Label: "90th percentile"},
+				{Name: "gc_pause_p99", Label: "99th percentile"},
+				{Name: "gc_pause_max", Label: "Max"},
+			},
+		},
+		(m.Prefix + ".expvar.#"): {
+			Label: (labelPrefix + " Vars"),
+			Unit:  "float",
+			Metrics: []mp.Metrics{
+				{Name: "*", Label: "%1"},
+			},
+		},
+		(m.Prefix + ".expvar_diff.#"): {
+			Label: (labelPrefix + " Vars Per Minute"),
+			Unit:  "float",
+			Metrics: []mp.Metrics{
+				{Name: "*", Label: "%1", Diff: true},
+			},
+		},
+	}
+}
+
+// parseExpvar converts memstats and the numeric variables, or the objects of numbers, at the top level.
+// A variable is posted as <prefix>.expvar.<name>.value (or <prefix>.expvar.<name>.<key> for an object),
+// or under <prefix>.expvar_diff if the name matches DiffVars.
+func (m GostatsPlugin) parseExpvar(body io.Reader) (map[string]interface{}, error) {
+	var vars map[string]json.RawMessage
+	if err := json.NewDecoder(body).Decode(&vars); err != nil {
+		return nil, err
+	}
+
+	stat := make(map[string]interface{})
+	if raw, ok := vars["memstats"]; ok {
+		var ms runtime.MemStats
+		if err := json.Unmarshal(raw, &ms); err != nil {
+			return nil, err
+		}
+		setMemStats(stat, &ms)
+	}
+
+	for name, raw := range vars {
+		if name == "memstats" || name == "cmdline" {
+			continue
+		}
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.UseNumber()
+		var v interface{}
+		if err := decoder.Decode(&v); err != nil {
+			continue
+		}
+		if name == goroutinesVar {
+			if f, ok := toFloat(v); ok {
+				stat["goroutine_num"] = f
+			}
+			continue
+		}
+
+		graph := m.Prefix + ".expvar."
+		if m.DiffVars != nil && m.DiffVars.MatchString(name) {
+			graph = m.Prefix + ".expvar_diff."
+		}
+		graph += invalidVarChars.ReplaceAllString(name, "_")
+		if f, ok := toFloat(v); ok {
+			stat[graph+".value"] = f
+			continue
+		}
+		if obj, ok := v.(map[string]interface{}); ok {
+			for key, v := range obj {
+				if f, ok := toFloat(v); ok {
+					stat[graph+"."+invalidVarChars.ReplaceAllString(key, "_")] = f
+				}
+			}
+		}
+	}
+	return stat, nil
+}
+
+func setMemStats(stat map[string]interface{}, ms *runtime.MemStats) {
+	stat["memory_alloc"] = ms.Alloc
+	stat["memory_sys"] = ms.Sys
+	stat["memory_stack"] = ms.StackInuse
+	stat["memory_lookups"] = ms.Lookups
+	stat["memory_mallocs"] = ms.Mallocs
+	stat["memory_frees"] = ms.Frees
+	stat["heap_alloc"] = ms.HeapAlloc
+	stat["heap_sys"] = ms.HeapSys
+	stat["heap_idle"] = ms.HeapIdle
+	stat["heap_inuse"] = ms.HeapInuse
+	stat["heap_released"] = ms.HeapReleased
+	stat["gc_num"] = uint64(ms.NumGC)
+
+	pauses := recentPauses(ms)
+	if len(pauses) == 0 {
+		return
+	}
+	stat["gc_pause_p50"] = percentile(pauses, 0.5) / 1e6
+	stat["gc_pause_p90"] = percentile(pauses, 0.9) / 1e6
+	stat["gc_pause_p99"] = percentile(pauses, 0.99) / 1e6
+	stat["gc_pause_max"] = pauses[len(pauses)-1] / 1e6
+}
+
+// recentPauses returns the sorted pauses (ns) of the recent GCs kept in the circular buffer PauseNs
+func recentPauses(ms *runtime.MemStats) []float64 {
+	n := int(ms.NumGC)
+	if n > len(ms.PauseNs) {
+		n = len(ms.PauseNs)
+	}
+	pauses := make([]float64, 0, n)
+	for i := 0; i < n; i++ {
+		pauses = append(pauses, float64(ms.PauseNs[(int(ms.NumGC)-1-i)%len(ms.PauseNs)]))
+	}
+	sort.Float64s(pauses)
+	return pauses
+}
+
+// percentile returns the nearest-rank percentile of the sorted values
+func percentile(sorted []float64, p float64) float64 {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
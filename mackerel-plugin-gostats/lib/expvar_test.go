@@ -0,0 +1,98 @@
+package mpgostats
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"runtime"
+	"testing"
+)
+
+func TestParseExpvar(t *testing.T) {
+	ms := runtime.MemStats{
+		Alloc:        213360,
+		Sys:          3377400,
+		StackInuse:   393216,
+		Mallocs:      1137,
+		HeapAlloc:    213360,
+		HeapIdle:     65536,
+		HeapInuse:    589824,
+		HeapReleased: 32768,
+		NumGC:        258,
+	}
+	// the pauses of the recent 256 GCs are 1ms..256ms, and the oldest ones are overwritten
+	for i := 1; i <= 258; i++ {
+		ms.PauseNs[(i+255)%256] = uint64(i-2) * 1e6
+	}
+	memstats, err := json.Marshal(ms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]interface{}{
+		"cmdline":      []string{"./server"},
+		"memstats":     json.RawMessage(memstats),
+		"goroutines":   12,
+		"requests":     1027,
+		"cache.hits":   0.5,
+		"responses":    map[string]interface{}{"200": 1000, "404": 27, "last": "x"},
+		"version":      "1.0",
+		"requests_err": 3,
+	}
+	body, err := json.Marshal(vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := GostatsPlugin{Prefix: "gostats", Format: "expvar", DiffVars: regexp.MustCompile(`^requests`)}
+	stat, err := m.parseExpvar(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]float64{
+		"goroutine_num":                          12,
+		"memory_alloc":                           213360,
+		"memory_sys":                             3377400,
+		"memory_stack":                           393216,
+		"memory_mallocs":                         1137,
+		"heap_alloc":                             213360,
+		"heap_idle":                              65536,
+		"heap_inuse":                             589824,
+		"heap_released":                          32768,
+		"gc_num":                                 258,
+		"gc_pause_p50":                           128,
+		"gc_pause_p90":                           231,
+		"gc_pause_p99":                           254,
+		"gc_pause_max":                           256,
+		"gostats.expvar_diff.requests.value":     1027,
+		"gostats.expvar_diff.requests_err.value": 3,
+		"gostats.expvar.cache_hits.value":        0.5,
+		"gostats.expvar.responses.200":           1000,
+		"gostats.expvar.responses.404":           27,
+	}
+	for key, v := range expected {
+		actual, ok := stat[key]
+		if !ok {
+			t.Errorf("%s should be fetched", key)
+			continue
+		}
+		if f := toFloat64(actual); f != v {
+			t.Errorf("%s should be %v, but %v", key, v, f)
+		}
+	}
+	for _, key := range []string{"gostats.expvar.version.value", "gostats.expvar.responses.last", "gostats.expvar.goroutines.value"} {
+		if _, ok := stat[key]; ok {
+			t.Errorf("%s should not be fetched", key)
+		}
+	}
+}
+
+func toFloat64(v interface{}) float64 {
+	switch v := v.(type) {
+	case uint64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return -1
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"regexp"
 	"strings"
 
 	"github.com/fukata/golang-stats-api-handler"
@@ -14,8 +16,10 @@ import (
 
 // GostatsPlugin mackerel plugin for go server
 type GostatsPlugin struct {
-	URI    string
-	Prefix string
+	URI      string
+	Prefix   string
+	Format   string
+	DiffVars *regexp.Regexp
 }
 
 /*
@@ -52,6 +56,9 @@ type GostatsPlugin struct {
 
 // GraphDefinition interface for mackerelplugin
 func (m GostatsPlugin) GraphDefinition() map[string]mp.Graphs {
+	if m.Format == "expvar" {
+		return m.expvarGraphDefinition()
+	}
 	labelPrefix := strings.Title(m.Prefix)
 	return map[string]mp.Graphs{
 		(m.Prefix + ".runtime"): {
@@ -109,7 +116,13 @@ func (m GostatsPlugin) FetchMetrics() (map[string]interface{}, error) {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", m.URI, resp.Status)
+	}
 
+	if m.Format == "expvar" {
+		return m.parseExpvar(resp.Body)
+	}
 	return m.parseStats(resp.Body)
 }
 
@@ -147,18 +160,42 @@ func Do() {
 	optScheme := flag.String("scheme", "http", "Scheme")
 	optHost := flag.String("host", "localhost", "Hostname")
 	optPort := flag.String("port", "8080", "Port")
-	optPath := flag.String("path", "/api/stats", "Path")
+	optPath := flag.String("path", "", "Path (default \"/api/stats\", or \"/debug/vars\" for expvar)")
+	optFormat := flag.String("format", "stats-api", "Stats format: stats-api (golang-stats-api-handler) or expvar")
+	optDiffVars := flag.String("diff-vars", "", "Regexp of the names of the expvar variables posted as the difference per minute")
 	optPrefix := flag.String("metric-key-prefix", "gostats", "Metric key prefix")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
 	flag.Parse()
 
 	gosrv := GostatsPlugin{
 		Prefix: *optPrefix,
+		Format: *optFormat,
+	}
+	switch gosrv.Format {
+	case "stats-api", "expvar":
+	default:
+		fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-gostats: unknown format: %s\n", gosrv.Format)
+		os.Exit(1)
+	}
+	if *optDiffVars != "" {
+		re, err := regexp.Compile(*optDiffVars)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-gostats: %s\n", err)
+			os.Exit(1)
+		}
+		gosrv.DiffVars = re
+	}
+	path := *optPath
+	if path == "" {
+		path = "/api/stats"
+		if gosrv.Format == "expvar" {
+			path = "/debug/vars"
+		}
 	}
 	if *optURI != "" {
 		gosrv.URI = *optURI
 	} else {
-		gosrv.URI = fmt.Sprintf("%s://%s:%s%s", *optScheme, *optHost, *optPort, *optPath)
+		gosrv.URI = fmt.Sprintf("%s://%s:%s%s", *optScheme, *optHost, *optPort, path)
 	}
 
 	helper := mp.NewMackerelPlugin(gosrv)
@@ -8,7 +8,9 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	mp "github.com/mackerelio/go-mackerel-plugin"
@@ -44,10 +46,18 @@ type HAProxyPlugin struct {
 	URI      string
 	Username string
 	Password string
+	// Socket is the path of the stats socket, which is used instead of URI if set
+	Socket string
+	// PerServer enables the graphs for each server of the backends
+	PerServer bool
 }
 
 // FetchMetrics interface for mackerelplugin
 func (p HAProxyPlugin) FetchMetrics() (map[string]float64, error) {
+	if p.Socket != "" {
+		return p.fetchSocketMetrics()
+	}
+
 	client := &http.Client{
 		Timeout: time.Duration(5) * time.Second,
 	}
@@ -74,49 +84,253 @@ func (p HAProxyPlugin) FetchMetrics() (map[string]float64, error) {
 	return p.parseStats(resp.Body)
 }
 
+// proxyGraphdef is defined for each frontend, backend and server with "#" of the proxy
+var proxyGraphdef = map[string]mp.Graphs{
+	"sessions": {
+		Label: "Sessions",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "current", Label: "Current"},
+			{Name: "max", Label: "Max"},
+			{Name: "limit", Label: "Limit"},
+		},
+	},
+	"requests": {
+		Label: "Requests",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "requests", Label: "Requests", Diff: true},
+		},
+	},
+	"responses": {
+		Label: "HTTP Responses",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "1xx", Label: "1xx", Diff: true, Stacked: true},
+			{Name: "2xx", Label: "2xx", Diff: true, Stacked: true},
+			{Name: "3xx", Label: "3xx", Diff: true, Stacked: true},
+			{Name: "4xx", Label: "4xx", Diff: true, Stacked: true},
+			{Name: "5xx", Label: "5xx", Diff: true, Stacked: true},
+			{Name: "other", Label: "Other", Diff: true, Stacked: true},
+		},
+	},
+	"bytes": {
+		Label: "Bytes",
+		Unit:  "bytes",
+		Metrics: []mp.Metrics{
+			{Name: "in", Label: "In", Diff: true},
+			{Name: "out", Label: "Out", Diff: true},
+		},
+	},
+	"queue": {
+		Label: "Queue",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "current", Label: "Current"},
+			{Name: "max", Label: "Max"},
+		},
+	},
+	"time": {
+		Label: "Average Time (msec)",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "queue", Label: "Queue"},
+			{Name: "connect", Label: "Connect"},
+			{Name: "response", Label: "Response"},
+			{Name: "total", Label: "Total"},
+		},
+	},
+	"retries": {
+		Label: "Retries",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "retries", Label: "Retries", Diff: true},
+			{Name: "redispatches", Label: "Redispatches", Diff: true},
+		},
+	},
+	"servers": {
+		Label: "Servers",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "up", Label: "Up", Stacked: true},
+			{Name: "down", Label: "Down", Stacked: true},
+			{Name: "maint", Label: "Maintenance", Stacked: true},
+		},
+	},
+	"status": {
+		Label: "Status",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "up", Label: "Up", Stacked: true},
+			{Name: "down", Label: "Down", Stacked: true},
+			{Name: "maint", Label: "Maintenance", Stacked: true},
+		},
+	},
+}
+
+// proxyGraphs are the graphs of proxyGraphdef for each type of the proxy
+var proxyGraphs = map[string][]string{
+	"frontend": {"sessions", "requests", "responses", "bytes"},
+	"backend":  {"sessions", "requests", "responses", "bytes", "queue", "time", "retries", "servers"},
+	"server":   {"sessions", "requests", "responses", "bytes", "queue", "time", "retries", "status"},
+}
+
+var proxyLabels = map[string]string{
+	"frontend": "Frontend",
+	"backend":  "Backend",
+	"server":   "Server",
+}
+
+var serverStates = []string{"up", "down", "maint"}
+
+// column names of the stats CSV for the metrics of proxyGraphdef
+var proxyColumns = []struct {
+	column string
+	graph  string
+	metric string
+}{
+	{"scur", "sessions", "current"},
+	{"smax", "sessions", "max"},
+	{"slim", "sessions", "limit"},
+	{"req_tot", "requests", "requests"},
+	{"hrsp_1xx", "responses", "1xx"},
+	{"hrsp_2xx", "responses", "2xx"},
+	{"hrsp_3xx", "responses", "3xx"},
+	{"hrsp_4xx", "responses", "4xx"},
+	{"hrsp_5xx", "responses", "5xx"},
+	{"hrsp_other", "responses", "other"},
+	{"bin", "bytes", "in"},
+	{"bout", "bytes", "out"},
+	{"qcur", "queue", "current"},
+	{"qmax", "queue", "max"},
+	{"qtime", "time", "queue"},
+	{"ctime", "time", "connect"},
+	{"rtime", "time", "response"},
+	{"ttime", "time", "total"},
+	{"wretr", "retries", "retries"},
+	{"wredis", "retries", "redispatches"},
+}
+
+var nonMetricNameRe = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+func normalizeName(name string) string {
+	return nonMetricNameRe.ReplaceAllString(name, "_")
+}
+
+// serverState classifies the status column of a server into up, down or maint.
+// "NOLB" and "no check" are up, and "DRAIN" is maint.
+func serverState(status string) string {
+	switch {
+	case strings.HasPrefix(status, "UP"), strings.HasPrefix(status, "NOLB"), status == "no check":
+		return "up"
+	case strings.HasPrefix(status, "DOWN"):
+		return "down"
+	case strings.HasPrefix(status, "MAINT"), strings.HasPrefix(status, "DRAIN"):
+		return "maint"
+	}
+	return ""
+}
+
 func (p HAProxyPlugin) parseStats(statsBody io.Reader) (map[string]float64, error) {
 	stat := make(map[string]float64)
 	reader := csv.NewReader(statsBody)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	if len(header) == 0 || !strings.HasPrefix(header[0], "# ") {
+		return nil, errors.New("header of stats csv is not found (specified uri may be wrong)")
+	}
+	header[0] = strings.TrimPrefix(header[0], "# ")
+	index := make(map[string]int)
+	for i, name := range header {
+		index[name] = i
+	}
+	for _, name := range []string{"pxname", "svname", "stot", "bin", "bout", "econ"} {
+		if _, ok := index[name]; !ok {
+			return nil, fmt.Errorf("column %s is not found in stats csv", name)
+		}
+	}
 
 	for {
 		columns, err := reader.Read()
 		if err == io.EOF {
 			break
 		}
-
-		if len(columns) < 60 {
-			return nil, errors.New("length of stats csv is too short (specified uri may be wrong)")
-		}
-
-		if columns[1] != "BACKEND" {
-			continue
-		}
-
-		var data float64
-
-		data, err = strconv.ParseFloat(columns[7], 64)
 		if err != nil {
-			return nil, errors.New("cannot get values")
+			return nil, err
 		}
-		stat["sessions"] += data
-
-		data, err = strconv.ParseFloat(columns[8], 64)
-		if err != nil {
-			return nil, errors.New("cannot get values")
+		value := func(name string) (float64, bool) {
+			i, ok := index[name]
+			if !ok || i >= len(columns) || columns[i] == "" {
+				return 0, false
+			}
+			v, err := strconv.ParseFloat(columns[i], 64)
+			return v, err == nil
 		}
-		stat["bytes_in"] += data
 
-		data, err = strconv.ParseFloat(columns[9], 64)
-		if err != nil {
-			return nil, errors.New("cannot get values")
+		var proxyType, name string
+		switch columns[index["svname"]] {
+		case "FRONTEND":
+			proxyType, name = "frontend", normalizeName(columns[index["pxname"]])
+		case "BACKEND":
+			proxyType, name = "backend", normalizeName(columns[index["pxname"]])
+			for _, c := range []struct{ column, key string }{
+				{"stot", "sessions"},
+				{"bin", "bytes_in"},
+				{"bout", "bytes_out"},
+				{"econ", "connection_errors"},
+			} {
+				v, ok := value(c.column)
+				if !ok {
+					return nil, errors.New("cannot get values")
+				}
+				stat[c.key] += v
+			}
+		default:
+			// servers of the backend, and listeners (type 3) which are not counted
+			if i, ok := index["type"]; ok && i < len(columns) && columns[i] == "3" {
+				continue
+			}
+			backend := normalizeName(columns[index["pxname"]])
+			if i, ok := index["status"]; ok && i < len(columns) {
+				if state := serverState(columns[i]); state != "" {
+					stat[fmt.Sprintf("haproxy.backend.servers.%s.%s", backend, state)]++
+				}
+			}
+			if !p.PerServer {
+				continue
+			}
+			proxyType, name = "server", backend+"_"+normalizeName(columns[index["svname"]])
 		}
-		stat["bytes_out"] += data
 
-		data, err = strconv.ParseFloat(columns[13], 64)
-		if err != nil {
-			return nil, errors.New("cannot get values")
+		for _, c := range proxyColumns {
+			if v, ok := value(c.column); ok {
+				stat[fmt.Sprintf("haproxy.%s.%s.%s.%s", proxyType, c.graph, name, c.metric)] = v
+			}
+		}
+		switch proxyType {
+		case "backend":
+			// the servers are listed before their backend
+			for _, state := range serverStates {
+				key := fmt.Sprintf("haproxy.backend.servers.%s.%s", name, state)
+				if _, ok := stat[key]; !ok {
+					stat[key] = 0
+				}
+			}
+		case "server":
+			if i, ok := index["status"]; ok && i < len(columns) {
+				for _, state := range serverStates {
+					var v float64
+					if serverState(columns[i]) == state {
+						v = 1
+					}
+					stat[fmt.Sprintf("haproxy.server.status.%s.%s", name, state)] = v
+				}
+			}
 		}
-		stat["connection_errors"] += data
 	}
 
 	return stat, nil
@@ -124,7 +338,29 @@ func (p HAProxyPlugin) parseStats(statsBody io.Reader) (map[string]float64, erro
 
 // GraphDefinition interface for mackerelplugin
 func (p HAProxyPlugin) GraphDefinition() map[string]mp.Graphs {
-	return graphdef
+	graphs := make(map[string]mp.Graphs)
+	for k, v := range graphdef {
+		graphs[k] = v
+	}
+	if p.Socket != "" {
+		for k, v := range infoGraphdef {
+			graphs[k] = v
+		}
+	}
+	for proxyType, names := range proxyGraphs {
+		if proxyType == "server" && !p.PerServer {
+			continue
+		}
+		for _, name := range names {
+			g := proxyGraphdef[name]
+			graphs[fmt.Sprintf("haproxy.%s.%s.#", proxyType, name)] = mp.Graphs{
+				Label:   fmt.Sprintf("HAProxy %s %s", proxyLabels[proxyType], g.Label),
+				Unit:    g.Unit,
+				Metrics: g.Metrics,
+			}
+		}
+	}
+	return graphs
 }
 
 // Do the plugin
@@ -137,6 +373,8 @@ func Do() {
 	optUsername := flag.String("username", "", "Username for Basic Auth")
 	optPassword := flag.String("password", "", "Password for Basic Auth")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	optSocket := flag.String("socket", "", "Path to the stats socket (used instead of uri)")
+	optPerServer := flag.Bool("per-server", false, "Post the metrics of each server of the backends")
 	flag.Parse()
 
 	var haproxy HAProxyPlugin
@@ -153,6 +391,8 @@ func Do() {
 	if *optPassword != "" {
 		haproxy.Password = *optPassword
 	}
+	haproxy.Socket = *optSocket
+	haproxy.PerServer = *optPerServer
 
 	helper := mp.NewMackerelPlugin(haproxy)
 	if *optTempfile != "" {
@@ -1,8 +1,13 @@
 package mphaproxy
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -12,8 +17,14 @@ func TestGraphDefinition(t *testing.T) {
 	var haproxy HAProxyPlugin
 
 	graphdef := haproxy.GraphDefinition()
-	if len(graphdef) != 3 {
-		t.Errorf("GetTempfilename: %d should be 3", len(graphdef))
+	if len(graphdef) != 15 {
+		t.Errorf("GetTempfilename: %d should be 15", len(graphdef))
+	}
+
+	haproxy.PerServer = true
+	graphdef = haproxy.GraphDefinition()
+	if len(graphdef) != 23 {
+		t.Errorf("GetTempfilename: %d should be 23", len(graphdef))
 	}
 }
 
@@ -35,3 +46,166 @@ hastats,BACKEND,0,0,0,1,7,17,7061,15994,0,0,,17,0,0,0,UP,0,0,0,,0,1543,0,,1,1,0,
 	assert.EqualValues(t, stat["bytes_out"], 15994)
 	assert.EqualValues(t, stat["connection_errors"], 17)
 }
+
+func TestParseProxies(t *testing.T) {
+	haproxy := HAProxyPlugin{PerServer: true}
+	stub := `# pxname,svname,qcur,qmax,scur,smax,slim,stot,bin,bout,dreq,dresp,ereq,econ,eresp,wretr,wredis,status,weight,act,bck,chkfail,chkdown,lastchg,downtime,qlimit,pid,iid,sid,throttle,lbtot,tracked,type,rate,rate_lim,rate_max,check_status,check_code,check_duration,hrsp_1xx,hrsp_2xx,hrsp_3xx,hrsp_4xx,hrsp_5xx,hrsp_other,hanafail,req_rate,req_rate_max,req_tot,cli_abrt,srv_abrt,comp_in,comp_out,comp_byp,comp_rsp,lastsess,last_chk,last_agt,qtime,ctime,rtime,ttime,
+www,FRONTEND,,,3,10,2000,120,5000,90000,0,0,1,,,,,OPEN,,,,,,,,,1,2,0,,,,0,1,0,5,,,,0,110,5,3,2,0,,1,5,120,,,0,0,0,0,,,,,,,,
+app,web1,0,2,2,6,,70,3000,50000,,0,,0,0,1,0,UP,1,1,0,0,0,100,0,,1,3,1,,70,,2,1,,3,L7OK,200,1,0,65,3,1,1,0,0,,,,0,0,,,,,1,,,1,2,10,30,
+app,web2,0,1,1,4,,50,2000,40000,,0,,1,0,2,1,DOWN 1/2,1,1,0,1,1,5,0,,1,3,2,,50,,2,0,,2,L4CON,,0,0,45,2,2,1,0,0,,,,0,0,,,,,3,,,0,3,12,40,
+app,web3,0,0,0,0,,0,0,0,,0,,0,0,0,0,MAINT,1,1,0,0,0,5,0,,1,3,3,,0,,2,0,,0,,,,0,0,0,0,0,0,0,,,,0,0,,,,,-1,,,0,0,0,0,
+app,BACKEND,1,3,3,10,200,120,5000,90000,0,0,,1,0,3,1,UP,2,2,0,,0,100,0,,1,3,0,,120,,1,1,,5,,,,0,110,5,3,2,0,,,,120,0,0,0,0,0,0,1,,,1,2,11,35,
+`
+
+	stat, err := haproxy.parseStats(bytes.NewBufferString(stub))
+	assert.Nil(t, err)
+	assert.EqualValues(t, stat["sessions"], 120)
+	assert.EqualValues(t, stat["connection_errors"], 1)
+
+	assert.EqualValues(t, stat["haproxy.frontend.sessions.www.current"], 3)
+	assert.EqualValues(t, stat["haproxy.frontend.sessions.www.limit"], 2000)
+	assert.EqualValues(t, stat["haproxy.frontend.requests.www.requests"], 120)
+	assert.EqualValues(t, stat["haproxy.frontend.responses.www.2xx"], 110)
+	assert.EqualValues(t, stat["haproxy.frontend.responses.www.5xx"], 2)
+	_, ok := stat["haproxy.frontend.queue.www.current"]
+	assert.False(t, ok, "frontends have no queue")
+
+	assert.EqualValues(t, stat["haproxy.backend.queue.app.current"], 1)
+	assert.EqualValues(t, stat["haproxy.backend.queue.app.max"], 3)
+	assert.EqualValues(t, stat["haproxy.backend.time.app.response"], 11)
+	assert.EqualValues(t, stat["haproxy.backend.time.app.total"], 35)
+	assert.EqualValues(t, stat["haproxy.backend.retries.app.retries"], 3)
+	assert.EqualValues(t, stat["haproxy.backend.retries.app.redispatches"], 1)
+	assert.EqualValues(t, stat["haproxy.backend.servers.app.up"], 1)
+	assert.EqualValues(t, stat["haproxy.backend.servers.app.down"], 1)
+	assert.EqualValues(t, stat["haproxy.backend.servers.app.maint"], 1)
+
+	assert.EqualValues(t, stat["haproxy.server.sessions.app_web1.current"], 2)
+	assert.EqualValues(t, stat["haproxy.server.bytes.app_web2.out"], 40000)
+	assert.EqualValues(t, stat["haproxy.server.status.app_web1.up"], 1)
+	assert.EqualValues(t, stat["haproxy.server.status.app_web2.down"], 1)
+	assert.EqualValues(t, stat["haproxy.server.status.app_web2.up"], 0)
+
+	haproxy.PerServer = false
+	stat, err = haproxy.parseStats(bytes.NewBufferString(stub))
+	assert.Nil(t, err)
+	_, ok = stat["haproxy.server.sessions.app_web1.current"]
+	assert.False(t, ok)
+	assert.EqualValues(t, stat["haproxy.backend.servers.app.down"], 1)
+}
+
+func TestParseInvalid(t *testing.T) {
+	var haproxy HAProxyPlugin
+	_, err := haproxy.parseStats(bytes.NewBufferString("<html><body>Not Found</body></html>\n"))
+	assert.NotNil(t, err)
+}
+
+var infoStub = `Name: HAProxy
+Version: 1.8.8
+Release_date: 2018/04/19
+Nbproc: 1
+Process_num: 1
+Pid: 1234
+Uptime: 0d 1h02m03s
+Uptime_sec: 3723
+Memmax_MB: 0
+Maxsock: 4034
+Maxconn: 2000
+Hard_maxconn: 2000
+CurrConns: 12
+CumConns: 3456
+CumReq: 7890
+MaxSslConns: 0
+CurrSslConns: 3
+CumSslConns: 100
+Maxpipes: 0
+PipesUsed: 2
+PipesFree: 6
+ConnRate: 1
+ConnRateLimit: 0
+MaxConnRate: 10
+SessRate: 1
+SessRateLimit: 0
+MaxSessRate: 10
+SslRate: 4
+SslRateLimit: 0
+MaxSslRate: 8
+SslFrontendKeyRate: 1
+SslFrontendMaxKeyRate: 3
+SslFrontendSessionReuse_pct: 75
+SslBackendKeyRate: 0
+SslBackendMaxKeyRate: 0
+SslCacheLookups: 80
+SslCacheMisses: 20
+CompressBpsIn: 0
+CompressBpsOut: 0
+CompressBpsRateLim: 0
+ZlibMemUsage: 0
+MaxZlibMemUsage: 0
+Tasks: 20
+Run_queue: 3
+Idle_pct: 97
+node: lb1
+`
+
+func TestParseInfo(t *testing.T) {
+	stat, err := parseInfo(bytes.NewBufferString(infoStub))
+	assert.Nil(t, err)
+	assert.EqualValues(t, stat["curr_conns"], 12)
+	assert.EqualValues(t, stat["max_conn"], 2000)
+	assert.EqualValues(t, stat["ssl_rate"], 4)
+	assert.EqualValues(t, stat["ssl_frontend_key_rate"], 1)
+	assert.EqualValues(t, stat["ssl_cache_hits"], 60)
+	assert.EqualValues(t, stat["ssl_cache_misses"], 20)
+	assert.EqualValues(t, stat["pipes_used"], 2)
+	assert.EqualValues(t, stat["pipes_free"], 6)
+	assert.EqualValues(t, stat["idle_pct"], 97)
+	assert.EqualValues(t, stat["run_queue"], 3)
+}
+
+func TestFetchMetricsOverSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mackerel-plugin-haproxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "haproxy.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	statStub := `# pxname,svname,qcur,qmax,scur,smax,slim,stot,bin,bout,dreq,dresp,ereq,econ,eresp,wretr,wredis,status,
+app,BACKEND,0,0,1,2,200,17,7061,15994,0,0,,3,0,0,0,UP,
+`
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			command, _ := bufio.NewReader(conn).ReadString('\n')
+			switch command {
+			case "show stat\n":
+				fmt.Fprint(conn, statStub)
+			case "show info\n":
+				fmt.Fprint(conn, infoStub)
+			}
+			conn.Close()
+		}
+	}()
+
+	haproxy := HAProxyPlugin{Socket: socket}
+	stat, err := haproxy.FetchMetrics()
+	assert.Nil(t, err)
+	assert.EqualValues(t, stat["sessions"], 17)
+	assert.EqualValues(t, stat["connection_errors"], 3)
+	assert.EqualValues(t, stat["haproxy.backend.sessions.app.current"], 1)
+	assert.EqualValues(t, stat["curr_conns"], 12)
+
+	graphdef := haproxy.GraphDefinition()
+	if len(graphdef) != 21 {
+		t.Errorf("GetTempfilename: %d should be 21", len(graphdef))
+	}
+}
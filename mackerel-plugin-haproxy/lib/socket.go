@@ -0,0 +1,150 @@
+package mphaproxy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+)
+
+// infoGraphdef is the process-level metrics of "show info", available only over the socket
+var infoGraphdef = map[string]mp.Graphs{
+	"haproxy.info.connections": {
+		Label: "HAProxy Process Connections",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "curr_conns", Label: "Current"},
+			{Name: "max_conn", Label: "Max"},
+		},
+	},
+	"haproxy.info.ssl_rate": {
+		Label: "HAProxy SSL Rate",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "ssl_rate", Label: "SSL Sessions"},
+			{Name: "ssl_frontend_key_rate", Label: "SSL Key Computations"},
+		},
+	},
+	"haproxy.info.ssl_cache": {
+		Label: "HAProxy SSL Session Cache",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "ssl_cache_hits", Label: "Hits", Diff: true, Stacked: true},
+			{Name: "ssl_cache_misses", Label: "Misses", Diff: true, Stacked: true},
+		},
+	},
+	"haproxy.info.pipes": {
+		Label: "HAProxy Pipes",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "pipes_used", Label: "Used", Stacked: true},
+			{Name: "pipes_free", Label: "Free", Stacked: true},
+		},
+	},
+	"haproxy.info.idle": {
+		Label: "HAProxy Idle",
+		Unit:  "percentage",
+		Metrics: []mp.Metrics{
+			{Name: "idle_pct", Label: "Idle"},
+		},
+	},
+	"haproxy.info.run_queue": {
+		Label: "HAProxy Run Queue",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "run_queue", Label: "Run Queue"},
+		},
+	},
+}
+
+// fields of "show info" for the metrics of infoGraphdef
+var infoFields = map[string]string{
+	"CurrConns":          "curr_conns",
+	"Maxconn":            "max_conn",
+	"SslRate":            "ssl_rate",
+	"SslFrontendKeyRate": "ssl_frontend_key_rate",
+	"SslCacheMisses":     "ssl_cache_misses",
+	"PipesUsed":          "pipes_used",
+	"PipesFree":          "pipes_free",
+	"Idle_pct":           "idle_pct",
+	"Run_queue":          "run_queue",
+}
+
+// runSocketCommand sends a command to the stats socket and reads the response until haproxy closes the connection.
+func runSocketCommand(socket, command string) ([]byte, error) {
+	conn, err := net.DialTimeout("unix", socket, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Write([]byte(command + "\n")); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(conn)
+}
+
+func (p HAProxyPlugin) fetchSocketMetrics() (map[string]float64, error) {
+	out, err := runSocketCommand(p.Socket, "show stat")
+	if err != nil {
+		return nil, err
+	}
+	stat, err := p.parseStats(bytes.NewReader(out))
+	if err != nil {
+		return nil, err
+	}
+
+	out, err = runSocketCommand(p.Socket, "show info")
+	if err != nil {
+		return nil, err
+	}
+	info, err := parseInfo(bytes.NewReader(out))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range info {
+		stat[k] = v
+	}
+	return stat, nil
+}
+
+// parseInfo parses "show info", which consists of lines like "CurrConns: 1".
+// SSL cache hits are the lookups minus the misses.
+func parseInfo(body io.Reader) (map[string]float64, error) {
+	stat := make(map[string]float64)
+	var lookups float64
+	var hasLookups bool
+
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if err != nil {
+			continue
+		}
+		if kv[0] == "SslCacheLookups" {
+			lookups, hasLookups = v, true
+			continue
+		}
+		if key, ok := infoFields[kv[0]]; ok {
+			stat[key] = v
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if misses, ok := stat["ssl_cache_misses"]; ok && hasLookups {
+		stat["ssl_cache_hits"] = lookups - misses
+	}
+	return stat, nil
+}
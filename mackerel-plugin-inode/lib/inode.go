@@ -1,12 +1,15 @@
 package mpinode
 
 import (
+	"flag"
+	"fmt"
 	"os"
 	"os/exec"
 	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
 	"github.com/mackerelio/mackerel-agent/logging"
@@ -15,7 +18,14 @@ import (
 var logger = logging.GetLogger("metrics.plugin.inode")
 
 // InodePlugin plugin
-type InodePlugin struct{}
+type InodePlugin struct {
+	KeyBy         string
+	IncludeFsType *regexp.Regexp
+	ExcludeFsType *regexp.Regexp
+	IncludeMount  *regexp.Regexp
+	ExcludeMount  *regexp.Regexp
+	Timeout       time.Duration
+}
 
 var dfHeaderPattern = regexp.MustCompile(
 	`^Filesystem\s+`,
@@ -29,10 +39,60 @@ var devicePattern = regexp.MustCompile(
 	`^/dev/(.*)$`,
 )
 
+// https://github.com/docker/docker/blob/v1.5.0/daemon/graphdriver/devmapper/deviceset.go#L981
+var dockerDevicePattern = regexp.MustCompile(
+	`^/dev/mapper/docker-`,
+)
+
 var deviceUnacceptablePattern = regexp.MustCompile(
 	`[^A-Za-z0-9_-]`,
 )
 
+// pseudo filesystems which have no meaningful inode usage
+const defaultExcludeFsType = `^(autofs|binfmt_misc|bpf|cgroup2?|configfs|debugfs|devpts|efivarfs|fusectl|hugetlbfs|mqueue|nsfs|proc|pstore|rpc_pipefs|securityfs|selinuxfs|sysfs|tracefs)$`
+
+// FetchMetrics interface for mackerelplugin
+func (p InodePlugin) FetchMetrics() (map[string]interface{}, error) {
+	if runtime.GOOS != "linux" {
+		return p.fetchMetricsByDf()
+	}
+	mounts, err := readMountinfo()
+	if err != nil {
+		logger.Warningf("Failed to read %s: '%s'", pathMountinfo, err)
+		return nil, err
+	}
+	statfs := func(path string) (*inodeStat, error) {
+		return statfsInode(path, p.Timeout)
+	}
+	return p.collectMounts(mounts, statfs), nil
+}
+
+// collectMounts sets the metrics of the accepted mounts by statfs
+func (p InodePlugin) collectMounts(mounts []mount, statfs func(string) (*inodeStat, error)) map[string]interface{} {
+	result := make(map[string]interface{})
+	for _, m := range mounts {
+		if !p.acceptMount(m) {
+			continue
+		}
+		key := p.metricKey(m)
+		// the same device may be mounted more than once (bind mounts)
+		if _, ok := result["inode.count."+key+".total"]; ok {
+			continue
+		}
+		stat, err := statfs(m.MountPoint)
+		if err != nil {
+			logger.Warningf("Failed to statfs %s: '%s'", m.MountPoint, err)
+			continue
+		}
+		// filesystems without inode accounting (e.g. btrfs, vfat) report no inodes
+		if stat.Total == 0 {
+			continue
+		}
+		setInodeMetrics(result, key, stat.Total-stat.Free, stat.Free)
+	}
+	return result
+}
+
 //  $ df -iP
 // Filesystem      Inodes  IUsed   IFree IUse% Mounted on
 // /dev/xvda1     1310720 131197 1179523   11% /
@@ -40,13 +100,9 @@ var deviceUnacceptablePattern = regexp.MustCompile(
 // Filesystem 512-blocks      Used Available Capacity  iused    ifree %iused  Mounted on
 // /dev/disk1  974737408 176727800 797497608    19% 22154973 99687201   18%   /
 
-// FetchMetrics interface for mackerelplugin
-func (p InodePlugin) FetchMetrics() (map[string]interface{}, error) {
-	dfOpt := "-i"
-	if runtime.GOOS == "linux" {
-		dfOpt = "-iP"
-	}
-	cmd := exec.Command("df", dfOpt)
+// fetchMetricsByDf is used on platforms without /proc/self/mountinfo
+func (p InodePlugin) fetchMetricsByDf() (map[string]interface{}, error) {
+	cmd := exec.Command("df", "-i")
 	cmd.Env = append(os.Environ(), "LANG=C")
 	out, err := cmd.Output()
 	if err != nil {
@@ -58,37 +114,74 @@ func (p InodePlugin) FetchMetrics() (map[string]interface{}, error) {
 		if dfHeaderPattern.MatchString(line) {
 			continue
 		} else if matches := dfColumnsPattern.FindStringSubmatch(line); matches != nil {
-			name := matches[1]
-			// https://github.com/docker/docker/blob/v1.5.0/daemon/graphdriver/devmapper/deviceset.go#L981
-			if regexp.MustCompile(`^/dev/mapper/docker-`).FindStringSubmatch(name) != nil {
+			m := mount{Device: matches[1], MountPoint: matches[5]}
+			if !p.acceptMount(m) {
 				continue
 			}
-			if nameMatches := devicePattern.FindStringSubmatch(name); nameMatches != nil {
-				device := deviceUnacceptablePattern.ReplaceAllString(nameMatches[1], "_")
-				iused, err := strconv.ParseInt(matches[2], 0, 64)
-				if err != nil {
-					logger.Warningf("Failed to parse value: [%s]", matches[2])
-					continue
-				}
-				ifree, err := strconv.ParseInt(matches[3], 0, 64)
-				if err != nil {
-					logger.Warningf("Failed to parse value: [%s]", matches[3])
-					continue
-				}
-				result["inode.count."+device+".used"] = uint64(iused)
-				result["inode.count."+device+".free"] = uint64(ifree)
-				result["inode.count."+device+".total"] = uint64(iused + ifree)
-				usedPercentage := 100.0 // 100% if both iused and ifree are 0
-				if iused+ifree > 0 {
-					usedPercentage = float64(iused) * 100 / float64(iused+ifree)
-				}
-				result["inode.percentage."+device+".used"] = usedPercentage
+			key := p.metricKey(m)
+			iused, err := strconv.ParseUint(matches[2], 0, 64)
+			if err != nil {
+				logger.Warningf("Failed to parse value: [%s]", matches[2])
+				continue
 			}
+			ifree, err := strconv.ParseUint(matches[3], 0, 64)
+			if err != nil {
+				logger.Warningf("Failed to parse value: [%s]", matches[3])
+				continue
+			}
+			if iused+ifree == 0 {
+				continue
+			}
+			setInodeMetrics(result, key, iused, ifree)
 		}
 	}
 	return result, nil
 }
 
+// acceptMount applies the include/exclude filters. Filesystem type filters are
+// skipped when the type is unknown (df fallback).
+func (p InodePlugin) acceptMount(m mount) bool {
+	if dockerDevicePattern.MatchString(m.Device) {
+		return false
+	}
+	if m.FsType != "" {
+		if p.IncludeFsType != nil && !p.IncludeFsType.MatchString(m.FsType) {
+			return false
+		}
+		if p.ExcludeFsType != nil && p.ExcludeFsType.MatchString(m.FsType) {
+			return false
+		}
+	}
+	if p.IncludeMount != nil && !p.IncludeMount.MatchString(m.MountPoint) {
+		return false
+	}
+	if p.ExcludeMount != nil && p.ExcludeMount.MatchString(m.MountPoint) {
+		return false
+	}
+	return true
+}
+
+// metricKey returns the name used in metric keys. In the device mode, the mounts not backed by
+// a /dev/* device, such as tmpfs and overlay, are named by their mount points.
+func (p InodePlugin) metricKey(m mount) string {
+	if p.KeyBy != "mountpoint" {
+		if nameMatches := devicePattern.FindStringSubmatch(m.Device); nameMatches != nil {
+			return deviceUnacceptablePattern.ReplaceAllString(nameMatches[1], "_")
+		}
+	}
+	if m.MountPoint == "/" {
+		return "root"
+	}
+	return deviceUnacceptablePattern.ReplaceAllString(strings.Trim(m.MountPoint, "/"), "_")
+}
+
+func setInodeMetrics(result map[string]interface{}, key string, iused, ifree uint64) {
+	result["inode.count."+key+".used"] = iused
+	result["inode.count."+key+".free"] = ifree
+	result["inode.count."+key+".total"] = iused + ifree
+	result["inode.percentage."+key+".used"] = float64(iused) * 100 / float64(iused+ifree)
+}
+
 // GraphDefinition interface for mackerelplugin
 func (p InodePlugin) GraphDefinition() map[string]mp.Graphs {
 	return map[string]mp.Graphs{
@@ -111,9 +204,41 @@ func (p InodePlugin) GraphDefinition() map[string]mp.Graphs {
 	}
 }
 
+func compileOptionalRegexp(name, expr string) *regexp.Regexp {
+	if expr == "" {
+		return nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-inode: invalid %s: %s\n", name, err)
+		os.Exit(1)
+	}
+	return re
+}
+
 // Do the plugin
 func Do() {
-	inode := InodePlugin{}
+	optKeyBy := flag.String("key-by", "device", "Name metrics by \"device\" or \"mountpoint\"")
+	optIncludeFsType := flag.String("include-fs-type", "", "Regexp of filesystem types to include")
+	optExcludeFsType := flag.String("exclude-fs-type", defaultExcludeFsType, "Regexp of filesystem types to exclude")
+	optIncludeMount := flag.String("include-mount", "", "Regexp of mount points to include")
+	optExcludeMount := flag.String("exclude-mount", "", "Regexp of mount points to exclude")
+	optTimeout := flag.Duration("timeout", 3*time.Second, "Timeout of statfs for each mount point")
+	flag.Parse()
+
+	if *optKeyBy != "device" && *optKeyBy != "mountpoint" {
+		fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-inode: invalid key-by: %s\n", *optKeyBy)
+		os.Exit(1)
+	}
+
+	inode := InodePlugin{
+		KeyBy:         *optKeyBy,
+		IncludeFsType: compileOptionalRegexp("include-fs-type", *optIncludeFsType),
+		ExcludeFsType: compileOptionalRegexp("exclude-fs-type", *optExcludeFsType),
+		IncludeMount:  compileOptionalRegexp("include-mount", *optIncludeMount),
+		ExcludeMount:  compileOptionalRegexp("exclude-mount", *optExcludeMount),
+		Timeout:       *optTimeout,
+	}
 	helper := mp.NewMackerelPlugin(inode)
 	helper.Run()
 }
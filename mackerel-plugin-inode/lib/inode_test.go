@@ -0,0 +1,102 @@
+package mpinode
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var mountinfoSample = `22 1 202:1 / / rw,relatime shared:1 - ext4 /dev/xvda1 rw,data=ordered
+23 22 0:21 / /proc rw,nosuid,nodev,noexec,relatime shared:12 - proc proc rw
+24 22 0:22 / /sys rw,nosuid,nodev,noexec,relatime shared:7 - sysfs sysfs rw
+25 22 0:5 / /dev rw,nosuid,relatime shared:2 - devtmpfs udev rw,size=4077508k,nr_inodes=1019377,mode=755
+26 25 0:23 / /dev/shm rw,nosuid,nodev shared:3 - tmpfs tmpfs rw
+30 22 202:16 / /mnt/data\040disk rw,relatime shared:20 - xfs /dev/mapper/vg-data rw,attr2,inode64,noquota
+31 22 202:16 /backup /srv/backup rw,relatime shared:20 - xfs /dev/mapper/vg-data rw,attr2,inode64,noquota
+95 22 0:44 / /var/lib/docker/overlay2/0123/merged rw,relatime - overlay overlay rw,lowerdir=/var/lib/docker/overlay2/l/ABC
+96 22 253:3 / /var/lib/docker/devicemapper/mnt/abc rw,relatime - xfs /dev/mapper/docker-202:1-1234-abc rw
+`
+
+func TestParseMountinfo(t *testing.T) {
+	mounts, err := parseMountinfo(strings.NewReader(mountinfoSample))
+	assert.Nil(t, err)
+	assert.Len(t, mounts, 9)
+	assert.Equal(t, mount{Device: "/dev/xvda1", MountPoint: "/", FsType: "ext4"}, mounts[0])
+	assert.Equal(t, mount{Device: "tmpfs", MountPoint: "/dev/shm", FsType: "tmpfs"}, mounts[4])
+	assert.Equal(t, "/mnt/data disk", mounts[5].MountPoint)
+	assert.Equal(t, "overlay", mounts[7].FsType)
+}
+
+func TestAcceptMount(t *testing.T) {
+	p := InodePlugin{ExcludeFsType: regexp.MustCompile(defaultExcludeFsType)}
+	mounts, _ := parseMountinfo(strings.NewReader(mountinfoSample))
+
+	var accepted []string
+	for _, m := range mounts {
+		if p.acceptMount(m) {
+			accepted = append(accepted, m.MountPoint)
+		}
+	}
+	assert.Equal(t, []string{"/", "/dev", "/dev/shm", "/mnt/data disk", "/srv/backup", "/var/lib/docker/overlay2/0123/merged"}, accepted)
+
+	p = InodePlugin{
+		IncludeFsType: regexp.MustCompile(`^(tmpfs|overlay)$`),
+		ExcludeMount:  regexp.MustCompile(`^/var/lib/docker/`),
+	}
+	assert.True(t, p.acceptMount(mount{Device: "tmpfs", MountPoint: "/dev/shm", FsType: "tmpfs"}))
+	assert.False(t, p.acceptMount(mount{Device: "/dev/xvda1", MountPoint: "/", FsType: "ext4"}))
+	assert.False(t, p.acceptMount(mount{Device: "overlay", MountPoint: "/var/lib/docker/overlay2/0123/merged", FsType: "overlay"}))
+	// filesystem type is unknown when fetched by df
+	assert.True(t, p.acceptMount(mount{Device: "/dev/disk1", MountPoint: "/"}))
+}
+
+func TestMetricKey(t *testing.T) {
+	byDevice := InodePlugin{KeyBy: "device"}
+	byMountPoint := InodePlugin{KeyBy: "mountpoint"}
+
+	root := mount{Device: "/dev/xvda1", MountPoint: "/", FsType: "ext4"}
+	assert.Equal(t, "xvda1", byDevice.metricKey(root))
+	assert.Equal(t, "root", byMountPoint.metricKey(root))
+
+	data := mount{Device: "/dev/mapper/vg-data", MountPoint: "/mnt/data disk", FsType: "xfs"}
+	assert.Equal(t, "mapper_vg-data", byDevice.metricKey(data))
+	assert.Equal(t, "mnt_data_disk", byMountPoint.metricKey(data))
+
+	shm := mount{Device: "tmpfs", MountPoint: "/dev/shm", FsType: "tmpfs"}
+	assert.Equal(t, "dev_shm", byDevice.metricKey(shm))
+	assert.Equal(t, "dev_shm", byMountPoint.metricKey(shm))
+}
+
+func TestSetInodeMetrics(t *testing.T) {
+	result := make(map[string]interface{})
+	setInodeMetrics(result, "xvda1", 131197, 1179523)
+
+	assert.EqualValues(t, 1310720, result["inode.count.xvda1.total"])
+	assert.InDelta(t, 10.009, result["inode.percentage.xvda1.used"], 0.001)
+}
+
+func TestCollectMounts(t *testing.T) {
+	// the default options
+	p := InodePlugin{KeyBy: "device", ExcludeFsType: regexp.MustCompile(defaultExcludeFsType)}
+	mounts, _ := parseMountinfo(strings.NewReader(mountinfoSample + `40 22 0:50 / /mnt/usb rw,relatime - vfat /dev/sdb1 rw
+41 22 0:51 / /data rw,relatime - btrfs /dev/sdc1 rw
+`))
+	statfs := func(path string) (*inodeStat, error) {
+		switch path {
+		case "/mnt/usb", "/data":
+			return &inodeStat{Total: 0, Free: 0}, nil
+		}
+		return &inodeStat{Total: 1000, Free: 900}, nil
+	}
+	result := p.collectMounts(mounts, statfs)
+
+	for _, key := range []string{"xvda1", "dev", "mapper_vg-data", "dev_shm", "var_lib_docker_overlay2_0123_merged"} {
+		assert.EqualValues(t, 100, result["inode.count."+key+".used"], key)
+	}
+	// no inode accounting
+	assert.NotContains(t, result, "inode.count.sdb1.total")
+	assert.NotContains(t, result, "inode.percentage.sdc1.used")
+	assert.Len(t, result, 5*4)
+}
@@ -0,0 +1,111 @@
+package mpinode
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const pathMountinfo = "/proc/self/mountinfo"
+
+type mount struct {
+	Device     string
+	MountPoint string
+	FsType     string
+}
+
+type inodeStat struct {
+	Total uint64
+	Free  uint64
+}
+
+// $ cat /proc/self/mountinfo
+// 22 1 202:1 / / rw,relatime shared:1 - ext4 /dev/xvda1 rw,data=ordered
+// 23 22 0:21 / /proc rw,nosuid,nodev,noexec,relatime shared:12 - proc proc rw
+// 95 22 0:44 / /var/lib/docker/overlay2/0123/merged rw,relatime - overlay overlay rw,lowerdir=...
+//
+// (1)mount ID (2)parent ID (3)major:minor (4)root (5)mount point (6)mount options
+// (7)optional fields... (8)separator "-" (9)filesystem type (10)mount source (11)super options
+func parseMountinfo(r io.Reader) ([]mount, error) {
+	var mounts []mount
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 0 || len(fields) < sep+3 {
+			logger.Warningf("Failed to parse mountinfo line: [%s]", scanner.Text())
+			continue
+		}
+		mounts = append(mounts, mount{
+			Device:     unescapeMountinfo(fields[sep+2]),
+			MountPoint: unescapeMountinfo(fields[4]),
+			FsType:     fields[sep+1],
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mounts, nil
+}
+
+// unescapeMountinfo decodes octal escapes such as `\040` (space) in mountinfo fields.
+func unescapeMountinfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var buf []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				buf = append(buf, byte(n))
+				i += 3
+				continue
+			}
+		}
+		buf = append(buf, s[i])
+	}
+	return string(buf)
+}
+
+func readMountinfo() ([]mount, error) {
+	f, err := os.Open(pathMountinfo)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseMountinfo(f)
+}
+
+// statfsInode calls statfs(2) in a goroutine so that a stale NFS mount cannot block the plugin.
+func statfsInode(path string, timeout time.Duration) (*inodeStat, error) {
+	type result struct {
+		stat *inodeStat
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		var buf syscall.Statfs_t
+		if err := syscall.Statfs(path, &buf); err != nil {
+			ch <- result{nil, err}
+			return
+		}
+		ch <- result{&inodeStat{Total: uint64(buf.Files), Free: uint64(buf.Ffree)}, nil}
+	}()
+	select {
+	case r := <-ch:
+		return r.stat, r.err
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("statfs timed out after %s", timeout)
+	}
+}
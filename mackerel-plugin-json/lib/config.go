@@ -0,0 +1,129 @@
+package mpjson
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the configuration of an endpoint, written in TOML, or YAML if the file name ends with .yml or .yaml
+type Config struct {
+	URL                string            `toml:"url" yaml:"url"`
+	Headers            map[string]string `toml:"headers" yaml:"headers"`
+	User               string            `toml:"user" yaml:"user"`
+	Password           string            `toml:"password" yaml:"password"`
+	InsecureSkipVerify bool              `toml:"insecure_skip_verify" yaml:"insecure_skip_verify"`
+	Timeout            int               `toml:"timeout" yaml:"timeout"`
+	Prefix             string            `toml:"prefix" yaml:"prefix"`
+	Metrics            []MetricConfig    `toml:"metrics" yaml:"metrics"`
+}
+
+// MetricConfig maps the values at Path in the JSON to the metric Name of Graph.
+// If Path has wildcards ("*"), the metric is posted for each matched key to the graph "<Graph>.#".
+type MetricConfig struct {
+	Path       string `toml:"path" yaml:"path"`
+	Name       string `toml:"name" yaml:"name"`
+	Label      string `toml:"label" yaml:"label"`
+	Graph      string `toml:"graph" yaml:"graph"`
+	GraphLabel string `toml:"graph_label" yaml:"graph_label"`
+	Unit       string `toml:"unit" yaml:"unit"`
+	Diff       bool   `toml:"diff" yaml:"diff"`
+	Stacked    bool   `toml:"stacked" yaml:"stacked"`
+}
+
+var (
+	validName  = regexp.MustCompile(`^[-a-zA-Z0-9_]+$`)
+	validGraph = regexp.MustCompile(`^[-a-zA-Z0-9_]+(\.[-a-zA-Z0-9_]+)*$`)
+	validUnits = map[string]bool{
+		"float":        true,
+		"integer":      true,
+		"percentage":   true,
+		"seconds":      true,
+		"milliseconds": true,
+		"bytes":        true,
+		"bytes/sec":    true,
+		"bits/sec":     true,
+		"iops":         true,
+	}
+)
+
+// LoadConfig reads and validates the configuration file
+func LoadConfig(file string) (*Config, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var c Config
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(content, &c)
+	default:
+		_, err = toml.Decode(string(content), &c)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", file, err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", file, err)
+	}
+	return &c, nil
+}
+
+func (c *Config) validate() error {
+	if c.URL == "" {
+		return errors.New("url is required")
+	}
+	if len(c.Metrics) == 0 {
+		return errors.New("at least one metric is required")
+	}
+
+	wildcard := make(map[string]bool)
+	units := make(map[string]string)
+	names := make(map[string]string)
+	for i := range c.Metrics {
+		m := &c.Metrics[i]
+		if m.Path == "" {
+			return fmt.Errorf("path of metrics[%d] is required", i)
+		}
+		if !validName.MatchString(m.Name) {
+			return fmt.Errorf("name of %s should consist of [-a-zA-Z0-9_]", m.Path)
+		}
+		if !validGraph.MatchString(m.Graph) {
+			return fmt.Errorf("graph of %s should consist of [-a-zA-Z0-9_] separated by dots", m.Path)
+		}
+		if m.Unit == "" {
+			m.Unit = "float"
+		}
+		if !validUnits[m.Unit] {
+			return fmt.Errorf("unknown unit of %s: %s", m.Path, m.Unit)
+		}
+
+		w := hasWildcard(parsePath(m.Path))
+		if prev, ok := wildcard[m.Graph]; ok && prev != w {
+			return fmt.Errorf("graph %s mixes paths with and without wildcards", m.Graph)
+		}
+		wildcard[m.Graph] = w
+		if prev, ok := units[m.Graph]; ok && prev != m.Unit {
+			return fmt.Errorf("graph %s has different units: %s and %s", m.Graph, prev, m.Unit)
+		}
+		units[m.Graph] = m.Unit
+
+		// the metrics of the graphs without wildcards are keyed by their names
+		key := m.Name
+		if w {
+			key = m.Graph + "." + m.Name
+		}
+		if prev, ok := names[key]; ok {
+			return fmt.Errorf("name %s of %s is already used by %s", m.Name, m.Path, prev)
+		}
+		names[key] = m.Path
+	}
+	return nil
+}
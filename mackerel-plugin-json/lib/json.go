@@ -0,0 +1,172 @@
+package mpjson
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+	"github.com/mackerelio/mackerel-agent/logging"
+)
+
+var logger = logging.GetLogger("metrics.plugin.json")
+
+// JSONPlugin mackerel plugin for JSON endpoints
+type JSONPlugin struct {
+	Prefix string
+	Config *Config
+}
+
+// MetricKeyPrefix interface for PluginWithPrefix
+func (p JSONPlugin) MetricKeyPrefix() string {
+	if p.Prefix == "" {
+		p.Prefix = "json"
+	}
+	return p.Prefix
+}
+
+var invalidKeyChars = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+// FetchMetrics interface for mackerelplugin
+func (p JSONPlugin) FetchMetrics() (map[string]interface{}, error) {
+	doc, err := p.fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	stat := make(map[string]interface{})
+	for _, m := range p.Config.Metrics {
+		path := parsePath(m.Path)
+		values := extract(doc, path)
+		if len(values) == 0 {
+			logger.Warningf("%s is not found", m.Path)
+			continue
+		}
+		wildcard := hasWildcard(path)
+		for k, v := range values {
+			f, ok := toFloat(v)
+			if !ok {
+				logger.Warningf("%s is not a number: %v", m.Path, v)
+				continue
+			}
+			if wildcard {
+				stat[m.Graph+"."+invalidKeyChars.ReplaceAllString(k, "_")+"."+m.Name] = f
+			} else {
+				stat[m.Name] = f
+			}
+		}
+	}
+	return stat, nil
+}
+
+func (p JSONPlugin) fetch() (interface{}, error) {
+	c := p.Config
+	req, err := http.NewRequest("GET", c.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	// secrets can be given by environment variables, e.g. "Bearer ${API_TOKEN}"
+	for k, v := range c.Headers {
+		v = os.ExpandEnv(v)
+		if strings.EqualFold(k, "Host") {
+			req.Host = v
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+	if c.User != "" {
+		req.SetBasicAuth(c.User, os.ExpandEnv(c.Password))
+	}
+
+	timeout := 10 * time.Second
+	if c.Timeout > 0 {
+		timeout = time.Duration(c.Timeout) * time.Second
+	}
+	client := &http.Client{Timeout: timeout}
+	if c.InsecureSkipVerify {
+		client.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", c.URL, resp.Status)
+	}
+
+	var doc interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON of %s: %s", c.URL, err)
+	}
+	return doc, nil
+}
+
+// GraphDefinition interface for mackerelplugin
+func (p JSONPlugin) GraphDefinition() map[string]mp.Graphs {
+	labelPrefix := strings.Title(strings.Replace(p.MetricKeyPrefix(), "-", " ", -1))
+
+	graphdef := make(map[string]mp.Graphs)
+	for _, m := range p.Config.Metrics {
+		name := m.Graph
+		if hasWildcard(parsePath(m.Path)) {
+			name += ".#"
+		}
+		g, ok := graphdef[name]
+		if !ok {
+			g = mp.Graphs{Label: labelPrefix + " " + m.Graph, Unit: m.Unit}
+		}
+		if m.GraphLabel != "" {
+			g.Label = m.GraphLabel
+		}
+		label := m.Label
+		if label == "" {
+			label = m.Name
+		}
+		g.Metrics = append(g.Metrics, mp.Metrics{Name: m.Name, Label: label, Diff: m.Diff, Stacked: m.Stacked})
+		graphdef[name] = g
+	}
+	return graphdef
+}
+
+// Do the plugin
+func Do() {
+	optConf := flag.String("conf", "", "Configuration file (TOML, or YAML with .yml or .yaml)")
+	optPrefix := flag.String("metric-key-prefix", "", "Metric key prefix (default: prefix of the configuration, or \"json\")")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	flag.Parse()
+
+	if *optConf == "" {
+		fmt.Fprintln(os.Stderr, "failed to exec mackerel-plugin-json: -conf is required")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	config, err := LoadConfig(*optConf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-json: %s\n", err)
+		os.Exit(1)
+	}
+
+	p := JSONPlugin{Prefix: config.Prefix, Config: config}
+	if *optPrefix != "" {
+		p.Prefix = *optPrefix
+	}
+
+	helper := mp.NewMackerelPlugin(p)
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = fmt.Sprintf("/tmp/mackerel-plugin-%s", p.MetricKeyPrefix())
+	}
+	helper.Run()
+}
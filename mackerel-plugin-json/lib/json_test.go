@@ -0,0 +1,123 @@
+package mpjson
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tc, err := LoadConfig("fixtures/config.toml")
+	assert.Nil(t, err)
+	yc, err := LoadConfig("fixtures/config.yml")
+	assert.Nil(t, err)
+
+	assert.Equal(t, "http://localhost:9200/_nodes/stats", tc.URL)
+	assert.Equal(t, "mackerel-agent", tc.Headers["X-Request-From"])
+	assert.Len(t, tc.Metrics, 4)
+	assert.True(t, tc.Metrics[2].Diff)
+	assert.Equal(t, tc.Metrics, yc.Metrics)
+	assert.Equal(t, tc.Headers, yc.Headers)
+}
+
+func TestValidate(t *testing.T) {
+	metric := func(path, graph, name, unit string) MetricConfig {
+		return MetricConfig{Path: path, Graph: graph, Name: name, Unit: unit}
+	}
+	for _, c := range []Config{
+		{Metrics: []MetricConfig{metric("a", "g", "a", "")}},
+		{URL: "http://localhost/"},
+		{URL: "http://localhost/", Metrics: []MetricConfig{metric("a", "g", "a.b", "")}},
+		{URL: "http://localhost/", Metrics: []MetricConfig{metric("a", "g", "a", "bytes/min")}},
+		{URL: "http://localhost/", Metrics: []MetricConfig{metric("a.*.b", "g", "b", ""), metric("c", "g", "c", "")}},
+		{URL: "http://localhost/", Metrics: []MetricConfig{metric("a", "g", "a", "bytes"), metric("b", "g", "b", "integer")}},
+		{URL: "http://localhost/", Metrics: []MetricConfig{metric("a", "g", "x", ""), metric("b", "h", "x", "")}},
+	} {
+		assert.NotNil(t, c.validate(), "%+v should be invalid", c)
+	}
+
+	c := Config{URL: "http://localhost/", Metrics: []MetricConfig{metric("a.*.b", "g", "x", ""), metric("c.*.d", "h", "x", "")}}
+	assert.Nil(t, c.validate())
+	assert.Equal(t, "float", c.Metrics[0].Unit)
+}
+
+func TestExtract(t *testing.T) {
+	content, err := ioutil.ReadFile("fixtures/nodes_stats.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc interface{}
+	if err := json.Unmarshal(content, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	values := extract(doc, parsePath("nodes.*.jvm.mem.heap_used_in_bytes"))
+	assert.Equal(t, map[string]interface{}{"node-1": 536870912.0, "node.2": 268435456.0, "node-3": nil}, values)
+
+	values = extract(doc, parsePath("$.cluster.pools.*.active"))
+	assert.Equal(t, map[string]interface{}{"0": 2.0, "1": "3"}, values)
+
+	values = extract(doc, parsePath("cluster.pools.2.active"))
+	assert.Len(t, values, 0)
+}
+
+func TestFetchMetrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "mackerel" || password != "secret" || r.Header.Get("X-Request-From") != "mackerel-agent" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		http.ServeFile(w, r, filepath.Join("fixtures", "nodes_stats.json"))
+	}))
+	defer ts.Close()
+
+	config, err := LoadConfig("fixtures/config.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.URL = ts.URL
+	os.Setenv("ES_PASSWORD", "secret")
+	defer os.Unsetenv("ES_PASSWORD")
+
+	p := JSONPlugin{Prefix: config.Prefix, Config: config}
+	stat, err := p.FetchMetrics()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"heap.node-1.used": 536870912.0,
+		"heap.node_2.used": 268435456.0,
+		"heap.node-1.max":  1073741824.0,
+		"heap.node_2.max":  1073741824.0,
+		"index_total":      12345.0,
+		"search_active":    3.0,
+	}, stat)
+
+	os.Setenv("ES_PASSWORD", "wrong")
+	_, err = p.FetchMetrics()
+	assert.NotNil(t, err)
+}
+
+func TestGraphDefinition(t *testing.T) {
+	config, err := LoadConfig("fixtures/config.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := JSONPlugin{Prefix: config.Prefix, Config: config}
+
+	graphdef := p.GraphDefinition()
+	assert.Len(t, graphdef, 3)
+	heap := graphdef["heap.#"]
+	assert.Equal(t, "Elasticsearch Heap", heap.Label)
+	assert.Equal(t, "bytes", heap.Unit)
+	assert.Len(t, heap.Metrics, 2)
+	assert.Equal(t, "Used", heap.Metrics[0].Label)
+	assert.Equal(t, "max", heap.Metrics[1].Label)
+	assert.Equal(t, "Es indexing", graphdef["indexing"].Label)
+	assert.True(t, graphdef["indexing"].Metrics[0].Diff)
+}
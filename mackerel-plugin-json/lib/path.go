@@ -0,0 +1,81 @@
+package mpjson
+
+import (
+	"strconv"
+	"strings"
+)
+
+// parsePath splits a path expression such as "nodes.*.jvm.mem.heap_used" into the keys.
+// "*" matches any key of an object or any index of an array, and a leading "$." is ignored.
+func parsePath(path string) []string {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
+
+func hasWildcard(keys []string) bool {
+	for _, k := range keys {
+		if k == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// extract returns the values at the path in v, keyed by the keys matched by the wildcards joined with "_"
+func extract(v interface{}, path []string) map[string]interface{} {
+	values := make(map[string]interface{})
+	walk(v, path, nil, values)
+	return values
+}
+
+func walk(v interface{}, path []string, matched []string, values map[string]interface{}) {
+	if len(path) == 0 {
+		values[strings.Join(matched, "_")] = v
+		return
+	}
+	key, rest := path[0], path[1:]
+
+	switch node := v.(type) {
+	case map[string]interface{}:
+		if key != "*" {
+			if child, ok := node[key]; ok {
+				walk(child, rest, matched, values)
+			}
+			return
+		}
+		for k, child := range node {
+			walk(child, rest, append(matched[:len(matched):len(matched)], k), values)
+		}
+	case []interface{}:
+		if key != "*" {
+			i, err := strconv.Atoi(key)
+			if err == nil && i >= 0 && i < len(node) {
+				walk(node[i], rest, matched, values)
+			}
+			return
+		}
+		for i, child := range node {
+			walk(child, rest, append(matched[:len(matched):len(matched)], strconv.Itoa(i)), values)
+		}
+	}
+}
+
+// toFloat converts a JSON number, a numeric string or a boolean to float64
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	case bool:
+		if n {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
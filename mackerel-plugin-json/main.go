@@ -0,0 +1,7 @@
+package main
+
+import "github.com/mackerelio/mackerel-agent-plugins/mackerel-plugin-json/lib"
+
+func main() {
+	mpjson.Do()
+}
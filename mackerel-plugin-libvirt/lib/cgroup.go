@@ -0,0 +1,373 @@
+package mplibvirt
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	cgroupRoot      = "/sys/fs/cgroup"
+	libvirtStateDir = "/run/libvirt/qemu"
+	sysClassNet     = "/sys/class/net"
+	sysDevBlock     = "/sys/dev/block"
+)
+
+// systemd scope of a qemu domain, e.g. machine-qemu\x2d1\x2dweb01.scope
+var scopePattern = regexp.MustCompile(`\Amachine-qemu-(\d+)-(.+)\.scope\z`)
+
+// status XML of a running domain written by libvirtd
+//
+//	<domstatus state='running' reason='booted' pid='2311'>
+//	  <domain type='kvm' id='1'>
+//	    <name>web01.example.com</name>
+//	    <memory unit='KiB'>4194304</memory>
+//	    <currentMemory unit='KiB'>2097152</currentMemory>
+//	    <vcpu placement='static' current='2'>4</vcpu>
+//	    ...
+type domainXML struct {
+	Domain struct {
+		ID            string      `xml:"id,attr"`
+		Name          string      `xml:"name"`
+		Memory        memoryXML   `xml:"memory"`
+		CurrentMemory memoryXML   `xml:"currentMemory"`
+		VCPU          vcpuXML     `xml:"vcpu"`
+		Interfaces    []targetXML `xml:"devices>interface"`
+	} `xml:"domain"`
+}
+
+type memoryXML struct {
+	Unit  string `xml:"unit,attr"`
+	Value uint64 `xml:",chardata"`
+}
+
+// kib returns the memory size in KiB
+func (m memoryXML) kib() uint64 {
+	switch m.Unit {
+	case "b", "bytes":
+		return m.Value / 1024
+	case "M", "MiB":
+		return m.Value * 1024
+	case "G", "GiB":
+		return m.Value * 1024 * 1024
+	}
+	return m.Value
+}
+
+type vcpuXML struct {
+	Current uint64 `xml:"current,attr"`
+	Value   uint64 `xml:",chardata"`
+}
+
+type targetXML struct {
+	Target struct {
+		Dev string `xml:"dev,attr"`
+	} `xml:"target"`
+}
+
+// readCgroupDomains reads statistics of domains from the cgroups of qemu processes
+// (cgroup v2 and v1 are supported) and the status XML of libvirtd, without any connection to libvirtd.
+func readCgroupDomains() ([]domainStat, error) {
+	xmls, err := readDomainXMLs(libvirtStateDir)
+	if err != nil {
+		logger.Warningf("Failed to read the status of domains: %s", err)
+	}
+
+	unified := true
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		unified = false
+	}
+	cpuDir := filepath.Join(cgroupRoot, "machine.slice")
+	blkioDir := cpuDir
+	if !unified {
+		cpuDir = filepath.Join(cgroupRoot, "cpuacct", "machine.slice")
+		blkioDir = filepath.Join(cgroupRoot, "blkio", "machine.slice")
+	}
+
+	scopes, err := filepath.Glob(filepath.Join(cpuDir, "machine-qemu*.scope"))
+	if err != nil {
+		return nil, err
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("no qemu domains are found in %s", cpuDir)
+	}
+
+	var domains []domainStat
+	for _, scope := range scopes {
+		m := scopePattern.FindStringSubmatch(unescapeUnitName(filepath.Base(scope)))
+		if m == nil {
+			continue
+		}
+		d := domainStat{Name: m[2]}
+		if x, ok := xmls[m[1]]; ok {
+			d.Name = x.Domain.Name
+			d.BalloonCurrent = x.Domain.CurrentMemory.kib()
+			d.BalloonMaximum = x.Domain.Memory.kib()
+			d.VCPU = x.Domain.VCPU.Current
+			if d.VCPU == 0 {
+				d.VCPU = x.Domain.VCPU.Value
+			}
+			d.Interfaces = make(map[string]interfaceStat)
+			for _, i := range x.Domain.Interfaces {
+				if i.Target.Dev == "" {
+					continue
+				}
+				s, err := readInterfaceStat(i.Target.Dev)
+				if err != nil {
+					continue
+				}
+				d.Interfaces[i.Target.Dev] = s
+			}
+		}
+
+		if unified {
+			d.CPUTime, err = readCPUStatUsage(filepath.Join(scope, "cpu.stat"))
+		} else {
+			d.CPUTime, err = readUintFile(filepath.Join(scope, "cpuacct.usage"))
+		}
+		if err != nil {
+			logger.Warningf("Failed to read cpu usage of %s: %s", d.Name, err)
+			continue
+		}
+		if d.VCPU == 0 {
+			d.VCPU = countVCPUs(scope)
+		}
+
+		blkio := filepath.Join(blkioDir, filepath.Base(scope))
+		if unified {
+			d.Blocks, err = readIOStat(filepath.Join(blkio, "io.stat"))
+		} else {
+			d.Blocks, err = readBlkioThrottle(blkio)
+		}
+		if err != nil {
+			logger.Warningf("Failed to read block io of %s: %s", d.Name, err)
+		}
+		domains = append(domains, d)
+	}
+	return domains, nil
+}
+
+// readDomainXMLs reads status XMLs and returns them by domain id
+func readDomainXMLs(dir string) (map[string]domainXML, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.xml"))
+	if err != nil {
+		return nil, err
+	}
+	xmls := make(map[string]domainXML)
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var x domainXML
+		if err := xml.Unmarshal(content, &x); err != nil {
+			logger.Warningf("Failed to parse %s: %s", file, err)
+			continue
+		}
+		if x.Domain.ID != "" {
+			xmls[x.Domain.ID] = x
+		}
+	}
+	return xmls, nil
+}
+
+// unescapeUnitName decodes "\x2d" and so on of systemd unit names
+func unescapeUnitName(s string) string {
+	var buf []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) && s[i+1] == 'x' {
+			if n, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				buf = append(buf, byte(n))
+				i += 3
+				continue
+			}
+		}
+		buf = append(buf, s[i])
+	}
+	return string(buf)
+}
+
+func readUintFile(path string) (uint64, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+}
+
+// $ cat cpu.stat
+// usage_usec 1185336741
+// user_usec 1030428314
+// system_usec 154908427
+func readCPUStatUsage(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "usage_usec" {
+			usec, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return usec * 1000, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("usage_usec is not found in %s", path)
+}
+
+// libvirt creates a cgroup for each vCPU thread.
+// filepath.Glob is not used since scope names contain backslashes.
+func countVCPUs(scope string) uint64 {
+	for _, dir := range []string{filepath.Join(scope, "libvirt"), scope} {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		var n uint64
+		for _, f := range files {
+			if f.IsDir() && strings.HasPrefix(f.Name(), "vcpu") {
+				n++
+			}
+		}
+		if n > 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+// $ cat io.stat
+// 253:2 rbytes=1523752960 wbytes=9820381184 rios=40273 wios=612894 dbytes=0 dios=0
+func readIOStat(path string) (map[string]blockStat, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	blocks := make(map[string]blockStat)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		var b blockStat
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			n, err := strconv.ParseUint(kv[1], 10, 64)
+			if err != nil {
+				continue
+			}
+			switch kv[0] {
+			case "rbytes":
+				b.ReadBytes = n
+			case "wbytes":
+				b.WriteBytes = n
+			case "rios":
+				b.ReadReqs = n
+			case "wios":
+				b.WriteReqs = n
+			}
+		}
+		blocks[blockDeviceName(fields[0])] = b
+	}
+	return blocks, scanner.Err()
+}
+
+// $ cat blkio.throttle.io_service_bytes
+// 253:2 Read 1523752960
+// 253:2 Write 9820381184
+// 253:2 Sync 9820381184
+// 253:2 Async 1523752960
+// 253:2 Total 11344134144
+// Total 11344134144
+func readBlkioThrottle(dir string) (map[string]blockStat, error) {
+	blocks := make(map[string]blockStat)
+	for _, file := range []string{"blkio.throttle.io_service_bytes", "blkio.throttle.io_serviced"} {
+		f, err := os.Open(filepath.Join(dir, file))
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) != 3 {
+				continue
+			}
+			n, err := strconv.ParseUint(fields[2], 10, 64)
+			if err != nil {
+				continue
+			}
+			dev := blockDeviceName(fields[0])
+			b := blocks[dev]
+			switch {
+			case fields[1] == "Read" && file == "blkio.throttle.io_service_bytes":
+				b.ReadBytes = n
+			case fields[1] == "Write" && file == "blkio.throttle.io_service_bytes":
+				b.WriteBytes = n
+			case fields[1] == "Read":
+				b.ReadReqs = n
+			case fields[1] == "Write":
+				b.WriteReqs = n
+			default:
+				continue
+			}
+			blocks[dev] = b
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return blocks, nil
+}
+
+// blockDeviceName returns the name of the device such as "dm-2" for "253:2"
+func blockDeviceName(majorMinor string) string {
+	link, err := os.Readlink(filepath.Join(sysDevBlock, majorMinor))
+	if err != nil {
+		return strings.Replace(majorMinor, ":", "_", -1)
+	}
+	return filepath.Base(link)
+}
+
+// readInterfaceStat reads counters of the tap device on the host.
+// rx and tx are swapped to be from the viewpoint of the guest.
+func readInterfaceStat(dev string) (interfaceStat, error) {
+	var s interfaceStat
+	dir := filepath.Join(sysClassNet, dev, "statistics")
+	for _, c := range []struct {
+		file  string
+		value *uint64
+	}{
+		{"tx_bytes", &s.RxBytes},
+		{"tx_packets", &s.RxPackets},
+		{"rx_bytes", &s.TxBytes},
+		{"rx_packets", &s.TxPackets},
+	} {
+		n, err := readUintFile(filepath.Join(dir, c.file))
+		if err != nil {
+			return s, err
+		}
+		*c.value = n
+	}
+	return s, nil
+}
@@ -0,0 +1,202 @@
+package mplibvirt
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+	"github.com/mackerelio/mackerel-agent/logging"
+)
+
+var logger = logging.GetLogger("metrics.plugin.libvirt")
+
+var graphdef = map[string]mp.Graphs{
+	// cpu time in seconds is posted and its difference per minute is converted to percentage
+	"cpu.#": {
+		Label: "Libvirt Domain CPU",
+		Unit:  "percentage",
+		Metrics: []mp.Metrics{
+			{Name: "cpu", Label: "cpu", Diff: true, Scale: 100.0 / 60},
+		},
+	},
+	"vcpu.#": {
+		Label: "Libvirt Domain vCPUs",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "vcpu", Label: "vcpu"},
+		},
+	},
+	"memory.#": {
+		Label: "Libvirt Domain Balloon Memory",
+		Unit:  "bytes",
+		Metrics: []mp.Metrics{
+			{Name: "current", Label: "current"},
+			{Name: "maximum", Label: "maximum"},
+		},
+	},
+	"disk_bytes.#": {
+		Label: "Libvirt Domain Disk Bytes",
+		Unit:  "bytes/sec",
+		Metrics: []mp.Metrics{
+			{Name: "read", Label: "read", Diff: true, Scale: 1.0 / 60},
+			{Name: "write", Label: "write", Diff: true, Scale: 1.0 / 60},
+		},
+	},
+	"disk_requests.#": {
+		Label: "Libvirt Domain Disk Requests",
+		Unit:  "iops",
+		Metrics: []mp.Metrics{
+			{Name: "read", Label: "read", Diff: true, Scale: 1.0 / 60},
+			{Name: "write", Label: "write", Diff: true, Scale: 1.0 / 60},
+		},
+	},
+	"interface_bytes.#": {
+		Label: "Libvirt Domain Interface Bytes",
+		Unit:  "bytes/sec",
+		Metrics: []mp.Metrics{
+			{Name: "rx", Label: "rx", Diff: true, Scale: 1.0 / 60},
+			{Name: "tx", Label: "tx", Diff: true, Scale: 1.0 / 60},
+		},
+	},
+	"interface_packets.#": {
+		Label: "Libvirt Domain Interface Packets",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "rx", Label: "rx", Diff: true, Scale: 1.0 / 60},
+			{Name: "tx", Label: "tx", Diff: true, Scale: 1.0 / 60},
+		},
+	},
+}
+
+type blockStat struct {
+	ReadBytes  uint64
+	ReadReqs   uint64
+	WriteBytes uint64
+	WriteReqs  uint64
+}
+
+// interface counters are from the viewpoint of the guest like `virsh domifstat`
+type interfaceStat struct {
+	RxBytes   uint64
+	RxPackets uint64
+	TxBytes   uint64
+	TxPackets uint64
+}
+
+type domainStat struct {
+	Name string
+	// CPUTime in nanoseconds
+	CPUTime uint64
+	VCPU    uint64
+	// balloon memory in KiB
+	BalloonCurrent uint64
+	BalloonMaximum uint64
+	Blocks         map[string]blockStat
+	Interfaces     map[string]interfaceStat
+}
+
+// LibvirtPlugin mackerel plugin for libvirt/KVM domains
+type LibvirtPlugin struct {
+	Prefix string
+	// Source is "virsh" or "cgroup"
+	Source string
+	URI    string
+}
+
+// MetricKeyPrefix interface for PluginWithPrefix
+func (p LibvirtPlugin) MetricKeyPrefix() string {
+	if p.Prefix == "" {
+		p.Prefix = "libvirt"
+	}
+	return p.Prefix
+}
+
+// GraphDefinition interface for mackerelplugin
+func (p LibvirtPlugin) GraphDefinition() map[string]mp.Graphs {
+	return graphdef
+}
+
+// FetchMetrics interface for mackerelplugin
+func (p LibvirtPlugin) FetchMetrics() (map[string]interface{}, error) {
+	var domains []domainStat
+	var err error
+	switch p.Source {
+	case "cgroup":
+		domains, err = readCgroupDomains()
+	default:
+		domains, err = runVirshDomstats(p.URI)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stat := make(map[string]interface{})
+	for _, d := range domains {
+		setDomainMetrics(stat, d)
+	}
+	return stat, nil
+}
+
+func setDomainMetrics(stat map[string]interface{}, d domainStat) {
+	name := normalizeDomainName(d.Name)
+	stat[fmt.Sprintf("cpu.%s.cpu", name)] = float64(d.CPUTime) / 1e9
+	if d.VCPU > 0 {
+		stat[fmt.Sprintf("vcpu.%s.vcpu", name)] = float64(d.VCPU)
+	}
+	if d.BalloonMaximum > 0 {
+		stat[fmt.Sprintf("memory.%s.current", name)] = float64(d.BalloonCurrent * 1024)
+		stat[fmt.Sprintf("memory.%s.maximum", name)] = float64(d.BalloonMaximum * 1024)
+	}
+	for dev, b := range d.Blocks {
+		key := name + "_" + normalizeDomainName(dev)
+		stat[fmt.Sprintf("disk_bytes.%s.read", key)] = float64(b.ReadBytes)
+		stat[fmt.Sprintf("disk_bytes.%s.write", key)] = float64(b.WriteBytes)
+		stat[fmt.Sprintf("disk_requests.%s.read", key)] = float64(b.ReadReqs)
+		stat[fmt.Sprintf("disk_requests.%s.write", key)] = float64(b.WriteReqs)
+	}
+	for dev, i := range d.Interfaces {
+		key := name + "_" + normalizeDomainName(dev)
+		stat[fmt.Sprintf("interface_bytes.%s.rx", key)] = float64(i.RxBytes)
+		stat[fmt.Sprintf("interface_bytes.%s.tx", key)] = float64(i.TxBytes)
+		stat[fmt.Sprintf("interface_packets.%s.rx", key)] = float64(i.RxPackets)
+		stat[fmt.Sprintf("interface_packets.%s.tx", key)] = float64(i.TxPackets)
+	}
+}
+
+var nonMetricNamePattern = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+// normalizeDomainName replaces "." with "_" like normalizeXenName of mackerel-plugin-xentop,
+// and other characters which cannot be used in metric names as well.
+func normalizeDomainName(raw string) string {
+	return nonMetricNamePattern.ReplaceAllString(strings.Replace(raw, ".", "_", -1), "_")
+}
+
+// Do the plugin
+func Do() {
+	optPrefix := flag.String("metric-key-prefix", "libvirt", "Metric key prefix")
+	optSource := flag.String("source", "virsh", "Source of statistics: virsh or cgroup")
+	optURI := flag.String("connect", "qemu:///system", "Hypervisor connection URI for virsh")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	flag.Parse()
+
+	if *optSource != "virsh" && *optSource != "cgroup" {
+		fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-libvirt: unknown source %q\n", *optSource)
+		os.Exit(1)
+	}
+
+	plugin := LibvirtPlugin{
+		Prefix: *optPrefix,
+		Source: *optSource,
+		URI:    *optURI,
+	}
+	helper := mp.NewMackerelPlugin(plugin)
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = fmt.Sprintf("/tmp/mackerel-plugin-%s", *optPrefix)
+	}
+	helper.Run()
+}
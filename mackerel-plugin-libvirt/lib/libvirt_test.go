@@ -0,0 +1,179 @@
+package mplibvirt
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphDefinition(t *testing.T) {
+	var p LibvirtPlugin
+
+	graphdef := p.GraphDefinition()
+	if len(graphdef) != 7 {
+		t.Errorf("GraphDef's size: %d should be 7", len(graphdef))
+	}
+}
+
+func TestNormalizeDomainName(t *testing.T) {
+	assert.Equal(t, "web01_example_com", normalizeDomainName("web01.example.com"))
+	assert.Equal(t, "db-01_test_", normalizeDomainName("db-01 test!"))
+}
+
+func TestParseDomstats(t *testing.T) {
+	f, err := os.Open("fixtures/domstats.txt")
+	assert.Nil(t, err)
+	defer f.Close()
+
+	domains, err := parseDomstats(f)
+	assert.Nil(t, err)
+	assert.Len(t, domains, 2)
+
+	d := domains[0]
+	assert.Equal(t, "web01.example.com", d.Name)
+	assert.EqualValues(t, 1185336741613, d.CPUTime)
+	assert.EqualValues(t, 2, d.VCPU)
+	assert.EqualValues(t, 2097152, d.BalloonCurrent)
+	assert.EqualValues(t, 4194304, d.BalloonMaximum)
+	assert.Equal(t, blockStat{ReadBytes: 1523752960, ReadReqs: 40273, WriteBytes: 9820381184, WriteReqs: 612894}, d.Blocks["vda"])
+	assert.Equal(t, interfaceStat{RxBytes: 9283746512, RxPackets: 6120331, TxBytes: 183926742, TxPackets: 1203451}, d.Interfaces["vnet0"])
+
+	assert.Equal(t, "db01", domains[1].Name)
+	assert.Len(t, domains[1].Blocks, 0)
+
+	stat := make(map[string]interface{})
+	setDomainMetrics(stat, d)
+	assert.InDelta(t, 1185.336741613, stat["cpu.web01_example_com.cpu"], 0.000001)
+	assert.EqualValues(t, 2, stat["vcpu.web01_example_com.vcpu"])
+	assert.EqualValues(t, 2097152*1024, stat["memory.web01_example_com.current"])
+	assert.EqualValues(t, 1523752960, stat["disk_bytes.web01_example_com_vda.read"])
+	assert.EqualValues(t, 612894, stat["disk_requests.web01_example_com_vda.write"])
+	assert.EqualValues(t, 9283746512, stat["interface_bytes.web01_example_com_vnet0.rx"])
+	assert.EqualValues(t, 1203451, stat["interface_packets.web01_example_com_vnet0.tx"])
+}
+
+const (
+	web01Scope = `machine.slice/machine-qemu\x2d1\x2dweb01.example.com.scope`
+	db01Scope  = `machine.slice/machine-qemu\x2d3\x2ddb01.scope`
+)
+
+// cgroupFiles are created in a temporary directory by setupFixtures, since the escaped unit names
+// and the device numbers cannot be file names on some platforms
+var cgroupFiles = map[string]string{
+	"cgroup1/blkio/" + web01Scope + "/blkio.throttle.io_service_bytes": `253:2 Read 1523752960
+253:2 Write 9820381184
+253:2 Sync 9820381184
+253:2 Async 1523752960
+253:2 Total 11344134144
+Total 11344134144
+`,
+	"cgroup1/blkio/" + web01Scope + "/blkio.throttle.io_serviced": `253:2 Read 40273
+253:2 Write 612894
+253:2 Sync 612894
+253:2 Async 40273
+253:2 Total 653167
+Total 653167
+`,
+	"cgroup1/cpuacct/" + web01Scope + "/cpuacct.usage":       "1185336741613\n",
+	"cgroup1/cpuacct/" + web01Scope + "/vcpu0/cpuacct.usage": "1\n",
+	"cgroup1/cpuacct/" + web01Scope + "/vcpu1/cpuacct.usage": "1\n",
+
+	"cgroup2/cgroup.controllers": "",
+	"cgroup2/" + web01Scope + "/cpu.stat": `usage_usec 1185336741
+user_usec 1030428314
+system_usec 154908427
+`,
+	"cgroup2/" + web01Scope + "/io.stat":                   "253:2 rbytes=1523752960 wbytes=9820381184 rios=40273 wios=612894 dbytes=0 dios=0\n",
+	"cgroup2/" + web01Scope + "/libvirt/emulator/cpu.stat": "usage_usec 1\n",
+	"cgroup2/" + web01Scope + "/libvirt/vcpu0/cpu.stat":    "usage_usec 1\n",
+	"cgroup2/" + web01Scope + "/libvirt/vcpu1/cpu.stat":    "usage_usec 1\n",
+	"cgroup2/" + db01Scope + "/cpu.stat": `usage_usec 502113
+user_usec 400001
+system_usec 102112
+`,
+	"cgroup2/" + db01Scope + "/io.stat":                "8:16 rbytes=4096 wbytes=8192 rios=1 wios=2 dbytes=0 dios=0\n",
+	"cgroup2/" + db01Scope + "/libvirt/vcpu0/cpu.stat": "usage_usec 1\n",
+}
+
+// setupFixtures creates the cgroup trees and /sys/dev/block, and sets the paths to them
+func setupFixtures(t *testing.T, cgroup string) string {
+	root, err := ioutil.TempDir("", "mackerel-plugin-libvirt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, content := range cgroupFiles {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	devBlock := filepath.Join(root, "dev-block")
+	if err := os.MkdirAll(devBlock, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../../devices/virtual/block/dm-2", filepath.Join(devBlock, "253:2")); err != nil {
+		t.Fatal(err)
+	}
+
+	cgroupRoot = filepath.Join(root, cgroup)
+	libvirtStateDir = "fixtures/qemu"
+	sysClassNet = "fixtures/net"
+	sysDevBlock = devBlock
+	return root
+}
+
+func TestReadCgroupDomains(t *testing.T) {
+	root := setupFixtures(t, "cgroup2")
+	defer os.RemoveAll(root)
+
+	domains, err := readCgroupDomains()
+	assert.Nil(t, err)
+	assert.Len(t, domains, 2)
+
+	byName := make(map[string]domainStat)
+	for _, d := range domains {
+		byName[d.Name] = d
+	}
+
+	d := byName["web01.example.com"]
+	assert.EqualValues(t, 1185336741000, d.CPUTime)
+	assert.EqualValues(t, 2, d.VCPU)
+	assert.EqualValues(t, 2097152, d.BalloonCurrent)
+	assert.EqualValues(t, 4194304, d.BalloonMaximum)
+	assert.Equal(t, blockStat{ReadBytes: 1523752960, ReadReqs: 40273, WriteBytes: 9820381184, WriteReqs: 612894}, d.Blocks["dm-2"])
+	// counters of the tap device are swapped
+	assert.Equal(t, interfaceStat{RxBytes: 9283746512, RxPackets: 6120331, TxBytes: 183926742, TxPackets: 1203451}, d.Interfaces["vnet0"])
+
+	// the status XML of db01 is not found
+	d = byName["db01"]
+	assert.EqualValues(t, 502113000, d.CPUTime)
+	assert.EqualValues(t, 1, d.VCPU)
+	assert.EqualValues(t, 0, d.BalloonMaximum)
+	assert.Equal(t, blockStat{ReadBytes: 4096, ReadReqs: 1, WriteBytes: 8192, WriteReqs: 2}, d.Blocks["8_16"])
+}
+
+func TestReadCgroupDomainsV1(t *testing.T) {
+	root := setupFixtures(t, "cgroup1")
+	defer os.RemoveAll(root)
+
+	domains, err := readCgroupDomains()
+	assert.Nil(t, err)
+	assert.Len(t, domains, 1)
+
+	d := domains[0]
+	assert.Equal(t, "web01.example.com", d.Name)
+	assert.EqualValues(t, 1185336741613, d.CPUTime)
+	assert.EqualValues(t, 2, d.VCPU)
+	assert.Equal(t, blockStat{ReadBytes: 1523752960, ReadReqs: 40273, WriteBytes: 9820381184, WriteReqs: 612894}, d.Blocks["dm-2"])
+}
+
+func TestUnescapeUnitName(t *testing.T) {
+	assert.Equal(t, "machine-qemu-1-web01.scope", unescapeUnitName(`machine-qemu\x2d1\x2dweb01.scope`))
+	assert.Equal(t, `a\xzz`, unescapeUnitName(`a\xzz`))
+}
@@ -0,0 +1,130 @@
+package mplibvirt
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// runVirshDomstats fetches statistics of running domains through the libvirt socket by virsh.
+func runVirshDomstats(uri string) ([]domainStat, error) {
+	out, err := exec.Command("virsh", "--connect", uri, "domstats", "--list-active", "--raw",
+		"--cpu-total", "--balloon", "--vcpu", "--interface", "--block").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run virsh domstats: %s", err)
+	}
+	return parseDomstats(bytes.NewReader(out))
+}
+
+// parseDomstats parses the output of virsh domstats.
+//
+//	$ virsh domstats --raw --cpu-total --balloon --vcpu --interface --block
+//	Domain: 'web01.example.com'
+//	  cpu.time=1185336741613
+//	  balloon.current=2097152
+//	  balloon.maximum=2097152
+//	  vcpu.current=2
+//	  net.0.name=vnet0
+//	  net.0.rx.bytes=183926742
+//	  block.0.name=vda
+//	  block.0.rd.reqs=40273
+//	  ...
+func parseDomstats(r io.Reader) ([]domainStat, error) {
+	var domains []domainStat
+	var d *domainStat
+	// names of net.N and block.N
+	var names map[string]string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "Domain: ") {
+			if d != nil {
+				domains = append(domains, *d)
+			}
+			d = &domainStat{
+				Name:       strings.Trim(strings.TrimPrefix(line, "Domain: "), "'"),
+				Blocks:     make(map[string]blockStat),
+				Interfaces: make(map[string]interfaceStat),
+			}
+			names = make(map[string]string)
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if d == nil || len(kv) != 2 {
+			continue
+		}
+		key, value := kv[0], kv[1]
+		if strings.HasSuffix(key, ".name") {
+			names[strings.TrimSuffix(key, ".name")] = value
+			continue
+		}
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		switch key {
+		case "cpu.time":
+			d.CPUTime = n
+		case "vcpu.current":
+			d.VCPU = n
+		case "balloon.current":
+			d.BalloonCurrent = n
+		case "balloon.maximum":
+			d.BalloonMaximum = n
+		}
+
+		// net.0.rx.bytes, block.0.rd.reqs and so on
+		parts := strings.SplitN(key, ".", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		dev, ok := names[parts[0]+"."+parts[1]]
+		if !ok {
+			continue
+		}
+		switch parts[0] {
+		case "block":
+			b := d.Blocks[dev]
+			switch parts[2] {
+			case "rd.bytes":
+				b.ReadBytes = n
+			case "rd.reqs":
+				b.ReadReqs = n
+			case "wr.bytes":
+				b.WriteBytes = n
+			case "wr.reqs":
+				b.WriteReqs = n
+			default:
+				continue
+			}
+			d.Blocks[dev] = b
+		case "net":
+			i := d.Interfaces[dev]
+			switch parts[2] {
+			case "rx.bytes":
+				i.RxBytes = n
+			case "rx.pkts":
+				i.RxPackets = n
+			case "tx.bytes":
+				i.TxBytes = n
+			case "tx.pkts":
+				i.TxPackets = n
+			default:
+				continue
+			}
+			d.Interfaces[dev] = i
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if d != nil {
+		domains = append(domains, *d)
+	}
+	return domains, nil
+}
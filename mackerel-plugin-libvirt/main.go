@@ -0,0 +1,7 @@
+package main
+
+import "github.com/mackerelio/mackerel-agent-plugins/mackerel-plugin-libvirt/lib"
+
+func main() {
+	mplibvirt.Do()
+}
@@ -8,6 +8,7 @@ import (
 
 	"errors"
 	"net/http"
+	"os"
 	"regexp"
 	"strconv"
 	"strings"
@@ -58,6 +59,8 @@ func (s *stringSlice) String() string {
 type NginxPlugin struct {
 	URI    string
 	Header stringSlice
+	// Format is "stub_status", "vts" (nginx-module-vts JSON) or "plus" (upstreams of nginx Plus API)
+	Format string
 }
 
 // % wget -qO- http://localhost:8080/nginx_status
@@ -91,6 +94,12 @@ func (n NginxPlugin) FetchMetrics() (map[string]interface{}, error) {
 	}
 	defer resp.Body.Close()
 
+	switch n.Format {
+	case "vts":
+		return n.parseVTS(resp.Body)
+	case "plus":
+		return n.parsePlus(resp.Body)
+	}
 	return n.parseStats(resp.Body)
 }
 
@@ -165,6 +174,12 @@ func (n NginxPlugin) parseStats(body io.Reader) (map[string]interface{}, error)
 
 // GraphDefinition interface for mackerelplugin
 func (n NginxPlugin) GraphDefinition() map[string]mp.Graphs {
+	switch n.Format {
+	case "vts":
+		return mergeGraphdef(graphdef, vtsGraphdef, upstreamGraphdef)
+	case "plus":
+		return mergeGraphdef(upstreamGraphdef, plusGraphdef)
+	}
 	return graphdef
 }
 
@@ -176,10 +191,16 @@ func Do() {
 	optPort := flag.String("port", "8080", "Port")
 	optPath := flag.String("path", "/nginx_status", "Path")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	optFormat := flag.String("format", "stub_status", "Format of the status: stub_status, vts or plus")
 	optHeader := &stringSlice{}
 	flag.Var(optHeader, "header", "Set http header (e.g. \"Host: servername\")")
 	flag.Parse()
 
+	if *optFormat != "stub_status" && *optFormat != "vts" && *optFormat != "plus" {
+		fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-nginx: unknown format %q\n", *optFormat)
+		os.Exit(1)
+	}
+
 	var nginx NginxPlugin
 	if *optURI != "" {
 		nginx.URI = *optURI
@@ -187,6 +208,7 @@ func Do() {
 		nginx.URI = fmt.Sprintf("%s://%s:%s%s", *optScheme, *optHost, *optPort, *optPath)
 	}
 	nginx.Header = *optHeader
+	nginx.Format = *optFormat
 
 	helper := mp.NewMackerelPlugin(nginx)
 	if *optTempfile != "" {
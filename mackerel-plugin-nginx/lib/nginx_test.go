@@ -36,3 +36,91 @@ Reading: 66 Writing: 16 Waiting: 41
 	assert.EqualValues(t, reflect.TypeOf(stat["accepts"]).String(), "float64")
 	assert.EqualValues(t, stat["accepts"], 1693613501)
 }
+
+func TestGraphDefinitionByFormat(t *testing.T) {
+	nginx := NginxPlugin{Format: "vts"}
+	assert.Len(t, nginx.GraphDefinition(), 11)
+
+	nginx.Format = "plus"
+	assert.Len(t, nginx.GraphDefinition(), 7)
+}
+
+func TestParseVTS(t *testing.T) {
+	var nginx NginxPlugin
+	vts := `{
+  "hostName": "web01",
+  "nginxVersion": "1.18.0",
+  "connections": {"active": 12, "reading": 0, "writing": 3, "waiting": 9, "accepted": 5021, "handled": 5021, "requests": 83012},
+  "serverZones": {
+    "example.com": {
+      "requestCounter": 83000, "inBytes": 1234567, "outBytes": 98765432,
+      "responses": {"1xx": 0, "2xx": 80000, "3xx": 1500, "4xx": 1400, "5xx": 100, "miss": 0},
+      "requestMsec": 25
+    },
+    "*": {
+      "requestCounter": 83012, "inBytes": 1234600, "outBytes": 98765500,
+      "responses": {"1xx": 0, "2xx": 80012, "3xx": 1500, "4xx": 1400, "5xx": 100},
+      "requestMsec": 25
+    }
+  },
+  "upstreamZones": {
+    "backend": [
+      {"server": "10.0.0.1:8080", "requestCounter": 40000, "inBytes": 48000000, "outBytes": 600000,
+       "responses": {"1xx": 0, "2xx": 39000, "3xx": 700, "4xx": 240, "5xx": 60}, "responseMsec": 21, "down": false},
+      {"server": "10.0.0.2:8080", "requestCounter": 41000, "inBytes": 49000000, "outBytes": 610000,
+       "responses": {"1xx": 0, "2xx": 40000, "3xx": 700, "4xx": 260, "5xx": 40}, "responseMsec": 23, "down": false}
+    ]
+  }
+}`
+
+	stat, err := nginx.parseVTS(bytes.NewBufferString(vts))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 12, stat["connections"])
+	assert.EqualValues(t, 83012, stat["requests"])
+	assert.EqualValues(t, 9, stat["waiting"])
+
+	assert.EqualValues(t, 83000, stat["nginx.server_zone_requests.example_com.requests"])
+	assert.EqualValues(t, 100, stat["nginx.server_zone_responses.example_com.5xx"])
+	assert.EqualValues(t, 98765500, stat["nginx.server_zone_bytes.all.out"])
+	assert.EqualValues(t, 25, stat["nginx.server_zone_response_time.all.response_time"])
+
+	assert.EqualValues(t, 100, stat["nginx.upstream_responses.backend.5xx"])
+	assert.EqualValues(t, 79000, stat["nginx.upstream_responses.backend.2xx"])
+	assert.EqualValues(t, 41000, stat["nginx.upstream_peer_requests.backend_10_0_0_2_8080.requests"])
+	assert.EqualValues(t, 48000000, stat["nginx.upstream_peer_bytes.backend_10_0_0_1_8080.received"])
+	assert.EqualValues(t, 23, stat["nginx.upstream_peer_response_time.backend_10_0_0_2_8080.response_time"])
+}
+
+func TestParsePlus(t *testing.T) {
+	var nginx NginxPlugin
+	plus := `{
+  "backend": {
+    "peers": [
+      {"id": 0, "server": "10.0.0.1:80", "name": "10.0.0.1:80", "backup": false, "weight": 1, "state": "up",
+       "active": 3, "requests": 5210, "header_time": 12, "response_time": 18,
+       "responses": {"1xx": 0, "2xx": 5100, "3xx": 50, "4xx": 40, "5xx": 20, "total": 5210},
+       "sent": 930102, "received": 20391283, "fails": 1, "unavail": 0,
+       "health_checks": {"checks": 600, "fails": 2, "unhealthy": 1, "last_passed": true}, "downtime": 1200},
+      {"id": 1, "server": "10.0.0.2:80", "name": "10.0.0.2:80", "backup": false, "weight": 1, "state": "unhealthy",
+       "active": 0, "requests": 4210,
+       "responses": {"1xx": 0, "2xx": 4000, "3xx": 50, "4xx": 40, "5xx": 120, "total": 4210},
+       "sent": 830102, "received": 10391283, "fails": 30, "unavail": 2,
+       "health_checks": {"checks": 600, "fails": 45, "unhealthy": 3, "last_passed": false}, "downtime": 60000}
+    ],
+    "keepalive": 0,
+    "zombies": 0,
+    "zone": "backend"
+  }
+}`
+
+	stat, err := nginx.parsePlus(bytes.NewBufferString(plus))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, stat["nginx.upstream_peers.backend.up"])
+	assert.EqualValues(t, 1, stat["nginx.upstream_peers.backend.unhealthy"])
+	assert.EqualValues(t, 0, stat["nginx.upstream_peers.backend.down"])
+	assert.EqualValues(t, 140, stat["nginx.upstream_responses.backend.5xx"])
+	assert.EqualValues(t, 3, stat["nginx.upstream_peer_active.backend_10_0_0_1_80.active"])
+	assert.EqualValues(t, 45, stat["nginx.upstream_peer_health_checks.backend_10_0_0_2_80.fails"])
+	assert.EqualValues(t, 18, stat["nginx.upstream_peer_response_time.backend_10_0_0_1_80.response_time"])
+	assert.EqualValues(t, 830102, stat["nginx.upstream_peer_bytes.backend_10_0_0_2_80.sent"])
+}
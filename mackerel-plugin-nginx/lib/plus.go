@@ -0,0 +1,96 @@
+package mpnginx
+
+import (
+	"encoding/json"
+	"io"
+
+	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+)
+
+var plusGraphdef = map[string]mp.Graphs{
+	"nginx.upstream_peers.#": {
+		Label: "Nginx upstream peer states",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "up", Label: "Up", Stacked: true},
+			{Name: "draining", Label: "Draining", Stacked: true},
+			{Name: "down", Label: "Down", Stacked: true},
+			{Name: "unavail", Label: "Unavailable", Stacked: true},
+			{Name: "checking", Label: "Checking", Stacked: true},
+			{Name: "unhealthy", Label: "Unhealthy", Stacked: true},
+		},
+	},
+	"nginx.upstream_peer_active.#": {
+		Label: "Nginx upstream peer active connections",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "active", Label: "Active"},
+		},
+	},
+	"nginx.upstream_peer_health_checks.#": {
+		Label: "Nginx upstream peer health check failures",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "fails", Label: "Fails", Diff: true},
+			{Name: "unhealthy", Label: "Unhealthy", Diff: true},
+		},
+	},
+}
+
+var peerStates = []string{"up", "draining", "down", "unavail", "checking", "unhealthy"}
+
+// plusUpstream is an upstream of the nginx Plus API (/api/N/http/upstreams)
+type plusUpstream struct {
+	Peers []plusPeer `json:"peers"`
+}
+
+type plusPeer struct {
+	Server       string            `json:"server"`
+	State        string            `json:"state"`
+	Active       uint64            `json:"active"`
+	Requests     uint64            `json:"requests"`
+	Responses    map[string]uint64 `json:"responses"`
+	Sent         uint64            `json:"sent"`
+	Received     uint64            `json:"received"`
+	ResponseTime uint64            `json:"response_time"`
+	HealthChecks struct {
+		Fails     uint64 `json:"fails"`
+		Unhealthy uint64 `json:"unhealthy"`
+	} `json:"health_checks"`
+}
+
+// parsePlus parses the upstreams of the nginx Plus API.
+func (n NginxPlugin) parsePlus(body io.Reader) (map[string]interface{}, error) {
+	var upstreams map[string]plusUpstream
+	if err := json.NewDecoder(body).Decode(&upstreams); err != nil {
+		return nil, err
+	}
+
+	stat := make(map[string]interface{})
+	for name, u := range upstreams {
+		upstream := normalizeName(name)
+		states := make(map[string]uint64)
+		responses := make(map[string]uint64)
+		for _, p := range u.Peers {
+			peer := peerKey(name, p.Server)
+			states[p.State]++
+			stat["nginx.upstream_peer_active."+peer+".active"] = float64(p.Active)
+			stat["nginx.upstream_peer_requests."+peer+".requests"] = float64(p.Requests)
+			stat["nginx.upstream_peer_bytes."+peer+".received"] = float64(p.Received)
+			stat["nginx.upstream_peer_bytes."+peer+".sent"] = float64(p.Sent)
+			stat["nginx.upstream_peer_response_time."+peer+".response_time"] = float64(p.ResponseTime)
+			stat["nginx.upstream_peer_health_checks."+peer+".fails"] = float64(p.HealthChecks.Fails)
+			stat["nginx.upstream_peer_health_checks."+peer+".unhealthy"] = float64(p.HealthChecks.Unhealthy)
+			for _, c := range responseClasses {
+				responses[c] += p.Responses[c]
+			}
+		}
+		for _, s := range peerStates {
+			stat["nginx.upstream_peers."+upstream+"."+s] = float64(states[s])
+		}
+		for _, c := range responseClasses {
+			stat["nginx.upstream_responses."+upstream+"."+c] = float64(responses[c])
+		}
+	}
+	return stat, nil
+}
@@ -0,0 +1,72 @@
+package mpnginx
+
+import (
+	"regexp"
+
+	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+)
+
+// graphs of upstreams common to nginx-module-vts and nginx Plus
+var upstreamGraphdef = map[string]mp.Graphs{
+	// responses are summed up over the peers of each upstream
+	"nginx.upstream_responses.#": {
+		Label: "Nginx upstream responses",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "1xx", Label: "1xx", Diff: true, Stacked: true},
+			{Name: "2xx", Label: "2xx", Diff: true, Stacked: true},
+			{Name: "3xx", Label: "3xx", Diff: true, Stacked: true},
+			{Name: "4xx", Label: "4xx", Diff: true, Stacked: true},
+			{Name: "5xx", Label: "5xx", Diff: true, Stacked: true},
+		},
+	},
+	"nginx.upstream_peer_requests.#": {
+		Label: "Nginx upstream peer requests",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "requests", Label: "Requests", Diff: true},
+		},
+	},
+	"nginx.upstream_peer_bytes.#": {
+		Label: "Nginx upstream peer bytes",
+		Unit:  "bytes",
+		Metrics: []mp.Metrics{
+			{Name: "received", Label: "Received", Diff: true},
+			{Name: "sent", Label: "Sent", Diff: true},
+		},
+	},
+	"nginx.upstream_peer_response_time.#": {
+		Label: "Nginx upstream peer response time (msec)",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "response_time", Label: "Response time"},
+		},
+	},
+}
+
+var responseClasses = []string{"1xx", "2xx", "3xx", "4xx", "5xx"}
+
+var normalizeNameRe = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+// normalizeName makes server zone, upstream and peer names usable in metric names
+func normalizeName(name string) string {
+	if name == "*" {
+		return "all"
+	}
+	return normalizeNameRe.ReplaceAllString(name, "_")
+}
+
+// peerKey returns the metric name of a peer, e.g. "backend_10_0_0_1_8080"
+func peerKey(upstream, server string) string {
+	return normalizeName(upstream) + "_" + normalizeName(server)
+}
+
+func mergeGraphdef(graphdefs ...map[string]mp.Graphs) map[string]mp.Graphs {
+	merged := make(map[string]mp.Graphs)
+	for _, g := range graphdefs {
+		for k, v := range g {
+			merged[k] = v
+		}
+	}
+	return merged
+}
@@ -0,0 +1,125 @@
+package mpnginx
+
+import (
+	"encoding/json"
+	"io"
+
+	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+)
+
+var vtsGraphdef = map[string]mp.Graphs{
+	"nginx.server_zone_requests.#": {
+		Label: "Nginx server zone requests",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "requests", Label: "Requests", Diff: true},
+		},
+	},
+	"nginx.server_zone_responses.#": {
+		Label: "Nginx server zone responses",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "1xx", Label: "1xx", Diff: true, Stacked: true},
+			{Name: "2xx", Label: "2xx", Diff: true, Stacked: true},
+			{Name: "3xx", Label: "3xx", Diff: true, Stacked: true},
+			{Name: "4xx", Label: "4xx", Diff: true, Stacked: true},
+			{Name: "5xx", Label: "5xx", Diff: true, Stacked: true},
+		},
+	},
+	"nginx.server_zone_bytes.#": {
+		Label: "Nginx server zone bytes",
+		Unit:  "bytes",
+		Metrics: []mp.Metrics{
+			{Name: "in", Label: "In", Diff: true},
+			{Name: "out", Label: "Out", Diff: true},
+		},
+	},
+	"nginx.server_zone_response_time.#": {
+		Label: "Nginx server zone response time (msec)",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "response_time", Label: "Response time"},
+		},
+	},
+}
+
+// vtsStatus is the JSON of nginx-module-vts (/status/format/json)
+type vtsStatus struct {
+	Connections struct {
+		Active   uint64 `json:"active"`
+		Reading  uint64 `json:"reading"`
+		Writing  uint64 `json:"writing"`
+		Waiting  uint64 `json:"waiting"`
+		Accepted uint64 `json:"accepted"`
+		Handled  uint64 `json:"handled"`
+		Requests uint64 `json:"requests"`
+	} `json:"connections"`
+	ServerZones   map[string]vtsZone   `json:"serverZones"`
+	UpstreamZones map[string][]vtsPeer `json:"upstreamZones"`
+}
+
+type vtsZone struct {
+	RequestCounter uint64            `json:"requestCounter"`
+	InBytes        uint64            `json:"inBytes"`
+	OutBytes       uint64            `json:"outBytes"`
+	Responses      map[string]uint64 `json:"responses"`
+	RequestMsec    uint64            `json:"requestMsec"`
+}
+
+type vtsPeer struct {
+	Server         string            `json:"server"`
+	RequestCounter uint64            `json:"requestCounter"`
+	InBytes        uint64            `json:"inBytes"`
+	OutBytes       uint64            `json:"outBytes"`
+	Responses      map[string]uint64 `json:"responses"`
+	ResponseMsec   uint64            `json:"responseMsec"`
+}
+
+// parseVTS parses the JSON of nginx-module-vts.
+// Connections are posted with the same keys as stub_status.
+func (n NginxPlugin) parseVTS(body io.Reader) (map[string]interface{}, error) {
+	var s vtsStatus
+	if err := json.NewDecoder(body).Decode(&s); err != nil {
+		return nil, err
+	}
+
+	stat := map[string]interface{}{
+		"connections": float64(s.Connections.Active),
+		"accepts":     float64(s.Connections.Accepted),
+		"handled":     float64(s.Connections.Handled),
+		"requests":    float64(s.Connections.Requests),
+		"reading":     float64(s.Connections.Reading),
+		"writing":     float64(s.Connections.Writing),
+		"waiting":     float64(s.Connections.Waiting),
+	}
+
+	for name, z := range s.ServerZones {
+		zone := normalizeName(name)
+		stat["nginx.server_zone_requests."+zone+".requests"] = float64(z.RequestCounter)
+		stat["nginx.server_zone_bytes."+zone+".in"] = float64(z.InBytes)
+		stat["nginx.server_zone_bytes."+zone+".out"] = float64(z.OutBytes)
+		stat["nginx.server_zone_response_time."+zone+".response_time"] = float64(z.RequestMsec)
+		for _, c := range responseClasses {
+			stat["nginx.server_zone_responses."+zone+"."+c] = float64(z.Responses[c])
+		}
+	}
+
+	for name, peers := range s.UpstreamZones {
+		upstream := normalizeName(name)
+		responses := make(map[string]uint64)
+		for _, p := range peers {
+			peer := peerKey(name, p.Server)
+			stat["nginx.upstream_peer_requests."+peer+".requests"] = float64(p.RequestCounter)
+			stat["nginx.upstream_peer_bytes."+peer+".received"] = float64(p.InBytes)
+			stat["nginx.upstream_peer_bytes."+peer+".sent"] = float64(p.OutBytes)
+			stat["nginx.upstream_peer_response_time."+peer+".response_time"] = float64(p.ResponseMsec)
+			for _, c := range responseClasses {
+				responses[c] += p.Responses[c]
+			}
+		}
+		for _, c := range responseClasses {
+			stat["nginx.upstream_responses."+upstream+"."+c] = float64(responses[c])
+		}
+	}
+	return stat, nil
+}
@@ -3,20 +3,75 @@ package mpnvidiasmi
 import (
 	"flag"
 	"fmt"
-	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+	"github.com/mackerelio/mackerel-agent/logging"
 )
 
-var queryOptions = []string{
-	"utilization.gpu",
-	"utilization.memory",
-	"temperature.gpu",
-	"fan.speed",
-	"memory.total",
-	"memory.used",
-	"memory.free",
+var logger = logging.GetLogger("metrics.plugin.nvidia-smi")
+
+// gpuField maps a field of `nvidia-smi --query-gpu` to a metric key format.
+// "%s" in KeyFormat is replaced with the GPU key (gpu0, gpu1, ... or UUID).
+type gpuField struct {
+	Query     string
+	KeyFormat string
+}
+
+// graph returns the graph name and the metric name of the field in GraphDefinition.
+//
+//	"gpu.util.%s"          -> "gpu.util", "#"
+//	"memory.usage.%s.used" -> "memory.usage.#", "used"
+func (f gpuField) graph() (string, string) {
+	if strings.HasSuffix(f.KeyFormat, ".%s") {
+		return strings.TrimSuffix(f.KeyFormat, ".%s"), "#"
+	}
+	parts := strings.SplitN(f.KeyFormat, ".%s.", 2)
+	return parts[0] + ".#", parts[1]
+}
+
+// index and uuid are always queried first to identify GPUs
+var gpuIdentityOptions = []string{
+	"index",
+	"uuid",
+}
+
+var gpuFields = []gpuField{
+	{"utilization.gpu", "gpu.util.%s"},
+	{"utilization.memory", "memory.util.%s"},
+	{"temperature.gpu", "temperature.%s"},
+	{"fan.speed", "fanspeed.%s"},
+	{"memory.total", "memory.usage.%s.total"},
+	{"memory.used", "memory.usage.%s.used"},
+	{"memory.free", "memory.usage.%s.free"},
+	{"power.draw", "power.%s.draw"},
+	{"power.limit", "power.%s.limit"},
+	{"clocks.sm", "clocks.%s.sm"},
+	{"clocks.mem", "clocks.%s.mem"},
+	{"utilization.encoder", "codec.%s.encoder"},
+	{"utilization.decoder", "codec.%s.decoder"},
+	{"ecc.errors.corrected.volatile.total", "ecc.%s.corrected"},
+	{"ecc.errors.uncorrected.volatile.total", "ecc.%s.uncorrected"},
+	{"clocks_throttle_reasons.gpu_idle", "throttle.%s.gpu_idle"},
+	{"clocks_throttle_reasons.applications_clocks_setting", "throttle.%s.applications_clocks_setting"},
+	{"clocks_throttle_reasons.sw_power_cap", "throttle.%s.sw_power_cap"},
+	{"clocks_throttle_reasons.hw_slowdown", "throttle.%s.hw_slowdown"},
+	{"clocks_throttle_reasons.hw_thermal_slowdown", "throttle.%s.hw_thermal_slowdown"},
+	{"clocks_throttle_reasons.hw_power_brake_slowdown", "throttle.%s.hw_power_brake_slowdown"},
+	{"clocks_throttle_reasons.sw_thermal_slowdown", "throttle.%s.sw_thermal_slowdown"},
+	{"clocks_throttle_reasons.sync_boost", "throttle.%s.sync_boost"},
+}
+
+var computeAppsOptions = []string{
+	"gpu_uuid",
+	"pid",
+	"process_name",
+	"used_memory",
 }
 
 var formatOptions = []string{
@@ -25,32 +80,84 @@ var formatOptions = []string{
 	"csv",
 }
 
-var nvidiaSmiOptions = []string{
-	fmt.Sprintf("--format=%s", strings.Join(formatOptions, ",")),
-	fmt.Sprintf("--query-gpu=%s", strings.Join(queryOptions, ",")),
-}
+var nonMetricNamePattern = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
 
-var metricsKeyFormats = []string{
-	"gpu.util.gpu%d",
-	"memory.util.gpu%d",
-	"temperature.gpu%d",
-	"fanspeed.gpu%d",
-	"memory.usage.gpu%d.total",
-	"memory.usage.gpu%d.used",
-	"memory.usage.gpu%d.free",
+// older drivers reject fields they do not know
+// $ nvidia-smi --format=noheader,nounits,csv --query-gpu=index,clocks_throttle_reasons.sync_boost
+// Field "clocks_throttle_reasons.sync_boost" is not a valid field to query.
+var invalidFieldPattern = regexp.MustCompile(`Field "([^"]+)" is not a valid field to query`)
+
+func nvidiaSmiOptions(fields []gpuField) []string {
+	queries := append([]string{}, gpuIdentityOptions...)
+	for _, f := range fields {
+		queries = append(queries, f.Query)
+	}
+	return []string{
+		fmt.Sprintf("--format=%s", strings.Join(formatOptions, ",")),
+		fmt.Sprintf("--query-gpu=%s", strings.Join(queries, ",")),
+	}
 }
 
-func (n NVidiaSMIPlugin) getMetricKey(index int, gpuIndex int) string {
-	return fmt.Sprintf(metricsKeyFormats[index], gpuIndex)
+// parseFields selects fields by comma separated query names such as "power.draw,clocks.sm".
+func parseFields(s string) ([]gpuField, error) {
+	if s == "" {
+		return gpuFields, nil
+	}
+	var fields []gpuField
+	for _, q := range strings.Split(s, ",") {
+		q = strings.TrimSpace(q)
+		found := false
+		for _, f := range gpuFields {
+			if f.Query == q {
+				fields = append(fields, f)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown field: %s", q)
+		}
+	}
+	return fields, nil
 }
 
 // NVidiaSMIPlugin mackerel plugin for nvidia-smi
 type NVidiaSMIPlugin struct {
 	Prefix string
+	// Fields to query by --query-gpu. All known fields are queried if empty.
+	Fields []gpuField
+	// KeyByUUID names GPUs by UUID instead of index so that graphs survive reordering
+	KeyByUUID bool
+	PCIe      bool
+
+	// the result of --query-gpu in FetchMetrics, which GraphDefinition reuses in the same run
+	queried *gpuQuery
+}
+
+// gpuQuery is the fields which were queried actually and the stats of them
+type gpuQuery struct {
+	fields []gpuField
+	stats  map[string]interface{}
 }
 
 // GraphDefinition interface for mackerelplugin
-func (n NVidiaSMIPlugin) GraphDefinition() map[string]mp.Graphs {
+// Graphs and metrics are defined only for the fields which the GPUs return data for,
+// so that unsupported fields do not leave empty graphs.
+// nvidia-smi is run only in the meta path, since the helper calls GraphDefinition after FetchMetrics
+// in the values path.
+func (n *NVidiaSMIPlugin) GraphDefinition() map[string]mp.Graphs {
+	if n.queried != nil {
+		return n.graphDefinition(n.queried.fields, n.queried.stats)
+	}
+	fields, stats, _, err := n.queryGPUs()
+	if err != nil {
+		// define graphs of all the fields when nvidia-smi is unavailable
+		return n.graphDefinition(n.fields(), nil)
+	}
+	return n.graphDefinition(fields, stats)
+}
+
+func (n NVidiaSMIPlugin) graphDefinition(fields []gpuField, stats map[string]interface{}) map[string]mp.Graphs {
 	var graphdef = map[string]mp.Graphs{
 		"gpu.util": {
 			Label: "GPU Utilization",
@@ -90,17 +197,142 @@ func (n NVidiaSMIPlugin) GraphDefinition() map[string]mp.Graphs {
 				{Name: "free", Label: "free", Scale: 1024 * 1024, Stacked: true},
 			},
 		},
+		"power.#": {
+			Label: "GPU Power (W)",
+			Unit:  "float",
+			Metrics: []mp.Metrics{
+				{Name: "draw", Label: "draw"},
+				{Name: "limit", Label: "limit"},
+			},
+		},
+		"clocks.#": {
+			Label: "GPU Clocks (MHz)",
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "sm", Label: "SM"},
+				{Name: "mem", Label: "memory"},
+			},
+		},
+		"codec.#": {
+			Label: "GPU Encoder/Decoder Utilization",
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				{Name: "encoder", Label: "encoder"},
+				{Name: "decoder", Label: "decoder"},
+			},
+		},
+		"ecc.#": {
+			Label: "GPU ECC Errors",
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "corrected", Label: "corrected"},
+				{Name: "uncorrected", Label: "uncorrected"},
+			},
+		},
+		"throttle.#": {
+			Label: "GPU Throttle Reasons",
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "gpu_idle", Label: "GPU idle", Stacked: true},
+				{Name: "applications_clocks_setting", Label: "applications clocks setting", Stacked: true},
+				{Name: "sw_power_cap", Label: "SW power cap", Stacked: true},
+				{Name: "hw_slowdown", Label: "HW slowdown", Stacked: true},
+				{Name: "hw_thermal_slowdown", Label: "HW thermal slowdown", Stacked: true},
+				{Name: "hw_power_brake_slowdown", Label: "HW power brake slowdown", Stacked: true},
+				{Name: "sw_thermal_slowdown", Label: "SW thermal slowdown", Stacked: true},
+				{Name: "sync_boost", Label: "sync boost", Stacked: true},
+			},
+		},
+		"compute_apps.memory.#": {
+			Label: "GPU Memory Usage by Process",
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "*", Label: "%1", Scale: 1024 * 1024, Stacked: true},
+			},
+		},
+	}
+	if n.PCIe {
+		graphdef["pcie.#"] = mp.Graphs{
+			Label: "GPU PCIe Throughput",
+			Unit:  "bytes/sec",
+			Metrics: []mp.Metrics{
+				{Name: "rx", Label: "rx", Scale: 1024 * 1024},
+				{Name: "tx", Label: "tx", Scale: 1024 * 1024},
+			},
+		}
+	}
+
+	// metrics of each graph to be defined
+	available := make(map[string]map[string]bool)
+	for _, f := range fields {
+		if stats != nil && !hasData(f, stats) {
+			continue
+		}
+		graph, metric := f.graph()
+		if available[graph] == nil {
+			available[graph] = make(map[string]bool)
+		}
+		available[graph][metric] = true
+	}
+	for name, graph := range graphdef {
+		if name == "compute_apps.memory.#" || name == "pcie.#" {
+			continue
+		}
+		var metrics []mp.Metrics
+		for _, m := range graph.Metrics {
+			if available[name][m.Name] {
+				metrics = append(metrics, m)
+			}
+		}
+		if len(metrics) == 0 {
+			delete(graphdef, name)
+			continue
+		}
+		graph.Metrics = metrics
+		graphdef[name] = graph
 	}
 	return graphdef
 }
 
+func hasData(f gpuField, stats map[string]interface{}) bool {
+	re := regexp.MustCompile(`\A` + strings.Replace(regexp.QuoteMeta(f.KeyFormat), "%s", `[-a-zA-Z0-9_]+`, 1) + `\z`)
+	for k := range stats {
+		if re.MatchString(k) {
+			return true
+		}
+	}
+	return false
+}
+
 // FetchMetrics interface for mackerelplugin
-func (n NVidiaSMIPlugin) FetchMetrics() (map[string]interface{}, error) {
-	ret, err := exec.Command("nvidia-smi", nvidiaSmiOptions...).CombinedOutput()
+func (n *NVidiaSMIPlugin) FetchMetrics() (map[string]interface{}, error) {
+	fields, stats, gpuKeys, err := n.queryGPUs()
+	if err != nil {
+		return nil, err
+	}
+	n.queried = &gpuQuery{fields: fields, stats: stats}
+
+	ret, err := exec.Command("nvidia-smi",
+		fmt.Sprintf("--format=%s", strings.Join(formatOptions, ",")),
+		fmt.Sprintf("--query-compute-apps=%s", strings.Join(computeAppsOptions, ",")),
+	).CombinedOutput()
 	if err != nil {
-		return nil, fmt.Errorf("%s: %s", err, ret)
+		// the metrics of the processes are optional, so the GPU metrics are posted anyway
+		logger.Warningf("Failed to query compute apps: %s: %s", err, ret)
+	} else {
+		n.parseComputeApps(string(ret), gpuKeys, stats)
+	}
+
+	if n.PCIe {
+		ret, err = exec.Command("nvidia-smi", "dmon", "-c", "1", "-s", "t").CombinedOutput()
+		if err != nil {
+			logger.Warningf("Failed to run nvidia-smi dmon: %s: %s", err, ret)
+		} else {
+			n.parseDmon(string(ret), gpuKeys, stats)
+		}
 	}
-	return n.parseStats(string(ret))
+
+	return stats, nil
 }
 
 // MetricKeyPrefix interface for mackerelplugin
@@ -108,38 +340,178 @@ func (n NVidiaSMIPlugin) MetricKeyPrefix() string {
 	return n.Prefix
 }
 
-func (n NVidiaSMIPlugin) parseStats(ret string) (map[string]interface{}, error) {
+func (n NVidiaSMIPlugin) fields() []gpuField {
+	if len(n.Fields) == 0 {
+		return gpuFields
+	}
+	return n.Fields
+}
+
+// queryGPUs runs `nvidia-smi --query-gpu` and returns the fields which were queried actually.
+// Fields which nvidia-smi rejects as invalid are dropped and queried again.
+func (n NVidiaSMIPlugin) queryGPUs() ([]gpuField, map[string]interface{}, map[string]string, error) {
+	fields := n.fields()
+	for {
+		ret, err := exec.Command("nvidia-smi", nvidiaSmiOptions(fields)...).CombinedOutput()
+		if err != nil {
+			if m := invalidFieldPattern.FindSubmatch(ret); m != nil {
+				if dropped := removeField(fields, string(m[1])); len(dropped) < len(fields) {
+					fields = dropped
+					continue
+				}
+			}
+			return nil, nil, nil, fmt.Errorf("%s: %s", err, ret)
+		}
+		stats, gpuKeys, err := n.parseStats(string(ret), fields)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return fields, stats, gpuKeys, nil
+	}
+}
+
+func removeField(fields []gpuField, query string) []gpuField {
+	var ret []gpuField
+	for _, f := range fields {
+		if f.Query != query {
+			ret = append(ret, f)
+		}
+	}
+	return ret
+}
+
+func (n NVidiaSMIPlugin) gpuKey(index, uuid string) string {
+	if n.KeyByUUID {
+		return nonMetricNamePattern.ReplaceAllString(uuid, "_")
+	}
+	return "gpu" + index
+}
+
+// parseStats parses the output of --query-gpu. It also returns the GPU keys
+// for each index and UUID to be used by parseComputeApps and parseDmon.
+// Values of unsupported fields ("[Not Supported]", "N/A" and so on) are skipped.
+func (n NVidiaSMIPlugin) parseStats(ret string, fields []gpuField) (map[string]interface{}, map[string]string, error) {
 	stats := make(map[string]interface{})
-	for id, line := range strings.Split(ret, "\n") {
-		err := n.parseLine(id, line, &stats)
+	gpuKeys := make(map[string]string)
+	for _, line := range strings.Split(ret, "\n") {
+		err := n.parseLine(line, fields, &stats, gpuKeys)
 		if err != nil {
-			return nil, fmt.Errorf("%s: %s", err, ret)
+			return nil, nil, fmt.Errorf("%s: %s", err, ret)
 		}
 	}
-	return stats, nil
+	return stats, gpuKeys, nil
 }
 
-func (n NVidiaSMIPlugin) parseLine(id int, line string, stats *map[string]interface{}) error {
+func (n NVidiaSMIPlugin) parseLine(line string, fields []gpuField, stats *map[string]interface{}, gpuKeys map[string]string) error {
 	if strings.TrimSpace(line) == "" {
 		return nil
 	}
 
-	for i, value := range strings.Split(line, ",") {
-		value, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
-		if err != nil {
+	values := strings.Split(line, ",")
+	if len(values) < len(gpuIdentityOptions) {
+		return fmt.Errorf("unexpected line: %s", line)
+	}
+	index := strings.TrimSpace(values[0])
+	uuid := strings.TrimSpace(values[1])
+	key := n.gpuKey(index, uuid)
+	gpuKeys[index] = key
+	gpuKeys[uuid] = key
+
+	for i, value := range values[len(gpuIdentityOptions):] {
+		if i >= len(fields) {
+			break
+		}
+		value, ok := parseValue(value)
+		if !ok {
 			continue
 		}
-		(*stats)[n.getMetricKey(i, id)] = value
+		(*stats)[fmt.Sprintf(fields[i].KeyFormat, key)] = value
 	}
 	return nil
 }
 
+// parseValue parses numbers and "Active"/"Not Active" of throttle reasons.
+func parseValue(value string) (float64, bool) {
+	value = strings.TrimSpace(value)
+	switch value {
+	case "Active":
+		return 1, true
+	case "Not Active":
+		return 0, true
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
+// $ nvidia-smi --format=noheader,nounits,csv --query-compute-apps=gpu_uuid,pid,process_name,used_memory
+// GPU-5f3b7c2e-1d4a-4e5b-9c6d-7e8f9a0b1c2d, 12345, /usr/bin/python3, 4051
+func (n NVidiaSMIPlugin) parseComputeApps(ret string, gpuKeys map[string]string, stats map[string]interface{}) {
+	for _, line := range strings.Split(ret, "\n") {
+		values := strings.Split(line, ",")
+		if len(values) != len(computeAppsOptions) {
+			continue
+		}
+		key, ok := gpuKeys[strings.TrimSpace(values[0])]
+		if !ok {
+			continue
+		}
+		name := nonMetricNamePattern.ReplaceAllString(filepath.Base(strings.TrimSpace(values[2])), "_")
+		used, ok := parseValue(values[3])
+		if !ok {
+			continue
+		}
+		// processes with the same name are summed up
+		metricKey := fmt.Sprintf("compute_apps.memory.%s.%s", key, name)
+		if v, ok := stats[metricKey].(float64); ok {
+			used += v
+		}
+		stats[metricKey] = used
+	}
+}
+
+// $ nvidia-smi dmon -c 1 -s t
+// # gpu   rxpci   txpci
+// # Idx    MB/s    MB/s
+// 0      12      34
+func (n NVidiaSMIPlugin) parseDmon(ret string, gpuKeys map[string]string, stats map[string]interface{}) {
+	for _, line := range strings.Split(ret, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		key, ok := gpuKeys[fields[0]]
+		if !ok {
+			continue
+		}
+		if rx, ok := parseValue(fields[1]); ok {
+			stats[fmt.Sprintf("pcie.%s.rx", key)] = rx
+		}
+		if tx, ok := parseValue(fields[2]); ok {
+			stats[fmt.Sprintf("pcie.%s.tx", key)] = tx
+		}
+	}
+}
+
 // Do the plugin
 func Do() {
 	optPrefix := flag.String("metric-key-prefix", "nvidia.gpu", "Metric key prefix")
+	optKeyByUUID := flag.Bool("key-by-uuid", false, "Name GPUs by UUID instead of index")
+	optPCIe := flag.Bool("pcie", false, "Fetch PCIe throughput by `nvidia-smi dmon` (takes a second)")
+	optFields := flag.String("fields", "", "Comma separated fields of --query-gpu to fetch (default: all)")
 	flag.Parse()
+	fields, err := parseFields(*optFields)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-nvidia-smi: %s\n", err)
+		os.Exit(1)
+	}
 	var plugin NVidiaSMIPlugin
 	plugin.Prefix = *optPrefix
-	helper := mp.NewMackerelPlugin(plugin)
+	plugin.Fields = fields
+	plugin.KeyByUUID = *optKeyByUUID
+	plugin.PCIe = *optPCIe
+	helper := mp.NewMackerelPlugin(&plugin)
 	helper.Run()
 }
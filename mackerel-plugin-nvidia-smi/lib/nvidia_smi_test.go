@@ -9,21 +9,27 @@ import (
 func TestGraphDefinition(t *testing.T) {
 	var plugin NVidiaSMIPlugin
 
-	graphdef := plugin.GraphDefinition()
-	if len(graphdef) != 5 {
-		t.Errorf("GraphDef's size: %d should be 5", len(graphdef))
+	graphdef := plugin.graphDefinition(gpuFields, nil)
+	if len(graphdef) != 11 {
+		t.Errorf("GraphDef's size: %d should be 11", len(graphdef))
+	}
+
+	plugin.PCIe = true
+	graphdef = plugin.graphDefinition(gpuFields, nil)
+	if len(graphdef) != 12 {
+		t.Errorf("GraphDef's size: %d should be 12", len(graphdef))
 	}
 }
 
 func TestParse(t *testing.T) {
 	var plugin NVidiaSMIPlugin
 	plugin.Prefix = "nvidia.gpu"
-	data := `10, 20, 30, 40, 1024, 64, 962
-11, 21, 31, 41, 1024, 65, 961
-12, 22, [Not Supported], [Not Supported], 1024, 66, 960
+	data := `0, GPU-aaaa, 10, 20, 30, 40, 1024, 64, 962
+1, GPU-bbbb, 11, 21, 31, 41, 1024, 65, 961
+2, GPU-cccc, 12, 22, [Not Supported], [Not Supported], 1024, 66, 960
 `
 
-	stats, err := plugin.parseStats(data)
+	stats, gpuKeys, err := plugin.parseStats(data, gpuFields)
 
 	assert.Nil(t, err)
 
@@ -50,4 +56,104 @@ func TestParse(t *testing.T) {
 	assert.EqualValues(t, 1024, stats["memory.usage.gpu2.total"])
 	assert.EqualValues(t, 66, stats["memory.usage.gpu2.used"])
 	assert.EqualValues(t, 960, stats["memory.usage.gpu2.free"])
+
+	assert.Equal(t, "gpu1", gpuKeys["1"])
+	assert.Equal(t, "gpu1", gpuKeys["GPU-bbbb"])
+}
+
+func TestParseExtended(t *testing.T) {
+	plugin := NVidiaSMIPlugin{Prefix: "nvidia.gpu", KeyByUUID: true, PCIe: true}
+	data := `0, GPU-5f3b7c2e-1d4a, 35, 5, 45, 30, 16160, 4096, 12064, 62.53, 250.00, 1380, 5000, 0, 12, 0, 3, Not Active, Not Active, Active, Not Active, Not Active, Not Active, Not Active, Not Active
+1, GPU-8a9b0c1d-2e3f, 0, 0, 40, 28, 16160, 0, 16160, N/A, N/A, 300, 405, 0, 0, [Not Supported], [Not Supported], Active, Not Active, Not Active, Not Active, Not Active, Not Active, Not Active, Not Active
+`
+	stats, gpuKeys, err := plugin.parseStats(data, gpuFields)
+	assert.Nil(t, err)
+
+	assert.EqualValues(t, 35, stats["gpu.util.GPU-5f3b7c2e-1d4a"])
+	assert.EqualValues(t, 62.53, stats["power.GPU-5f3b7c2e-1d4a.draw"])
+	assert.EqualValues(t, 250, stats["power.GPU-5f3b7c2e-1d4a.limit"])
+	assert.EqualValues(t, 1380, stats["clocks.GPU-5f3b7c2e-1d4a.sm"])
+	assert.EqualValues(t, 5000, stats["clocks.GPU-5f3b7c2e-1d4a.mem"])
+	assert.EqualValues(t, 12, stats["codec.GPU-5f3b7c2e-1d4a.decoder"])
+	assert.EqualValues(t, 3, stats["ecc.GPU-5f3b7c2e-1d4a.uncorrected"])
+	assert.EqualValues(t, 1, stats["throttle.GPU-5f3b7c2e-1d4a.sw_power_cap"])
+	assert.EqualValues(t, 0, stats["throttle.GPU-5f3b7c2e-1d4a.gpu_idle"])
+
+	assert.Nil(t, stats["power.GPU-8a9b0c1d-2e3f.draw"])
+	assert.Nil(t, stats["ecc.GPU-8a9b0c1d-2e3f.corrected"])
+	assert.EqualValues(t, 1, stats["throttle.GPU-8a9b0c1d-2e3f.gpu_idle"])
+
+	apps := `GPU-5f3b7c2e-1d4a, 12345, /usr/bin/python3, 4000
+GPU-5f3b7c2e-1d4a, 12346, /usr/bin/python3, 96
+GPU-5f3b7c2e-1d4a, 12400, /opt/app/bin/trainer.bin, 512
+GPU-ffffffff-ffff, 12500, /usr/bin/python3, 10
+`
+	plugin.parseComputeApps(apps, gpuKeys, stats)
+	assert.EqualValues(t, 4096, stats["compute_apps.memory.GPU-5f3b7c2e-1d4a.python3"])
+	assert.EqualValues(t, 512, stats["compute_apps.memory.GPU-5f3b7c2e-1d4a.trainer_bin"])
+	assert.Nil(t, stats["compute_apps.memory.GPU-ffffffff-ffff.python3"])
+
+	dmon := `# gpu   rxpci   txpci
+# Idx    MB/s    MB/s
+    0      12      34
+    1       -       -
+`
+	plugin.parseDmon(dmon, gpuKeys, stats)
+	assert.EqualValues(t, 12, stats["pcie.GPU-5f3b7c2e-1d4a.rx"])
+	assert.EqualValues(t, 34, stats["pcie.GPU-5f3b7c2e-1d4a.tx"])
+	assert.Nil(t, stats["pcie.GPU-8a9b0c1d-2e3f.rx"])
+}
+
+func TestGraphDefinitionOnlyForData(t *testing.T) {
+	var plugin NVidiaSMIPlugin
+	fields, err := parseFields("temperature.gpu,fan.speed,memory.total,memory.used,power.draw,ecc.errors.corrected.volatile.total")
+	assert.Nil(t, err)
+	// consumer cards do not support ECC and some do not report fan speed
+	data := `0, GPU-aaaa, 45, [Not Supported], 8192, 512, 35.10, [N/A]
+1, GPU-bbbb, 47, N/A, 8192, 0, 20.00, [N/A]
+`
+	stats, _, err := plugin.parseStats(data, fields)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 35.1, stats["power.gpu0.draw"])
+	assert.Nil(t, stats["fanspeed.gpu1"])
+
+	graphdef := plugin.graphDefinition(fields, stats)
+	assert.Contains(t, graphdef, "temperature")
+	assert.NotContains(t, graphdef, "fanspeed")
+	assert.NotContains(t, graphdef, "ecc.#")
+	assert.NotContains(t, graphdef, "gpu.util")
+	assert.NotContains(t, graphdef, "clocks.#")
+	assert.Contains(t, graphdef, "compute_apps.memory.#")
+	assert.Len(t, graphdef["memory.usage.#"].Metrics, 2)
+	assert.Len(t, graphdef["power.#"].Metrics, 1)
+	assert.Equal(t, "draw", graphdef["power.#"].Metrics[0].Name)
+}
+
+func TestGraphDefinitionReusesQuery(t *testing.T) {
+	var plugin NVidiaSMIPlugin
+	fields, err := parseFields("temperature.gpu")
+	assert.Nil(t, err)
+	stats, _, err := plugin.parseStats("0, GPU-aaaa, 45\n", fields)
+	assert.Nil(t, err)
+
+	// the result of FetchMetrics is used without running nvidia-smi
+	plugin.queried = &gpuQuery{fields: fields, stats: stats}
+	graphdef := plugin.GraphDefinition()
+	assert.Contains(t, graphdef, "temperature")
+	assert.NotContains(t, graphdef, "gpu.util")
+}
+
+func TestParseFields(t *testing.T) {
+	fields, err := parseFields("")
+	assert.Nil(t, err)
+	assert.Equal(t, gpuFields, fields)
+
+	fields, err = parseFields("power.draw, clocks.sm")
+	assert.Nil(t, err)
+	assert.Equal(t, []gpuField{{"power.draw", "power.%s.draw"}, {"clocks.sm", "clocks.%s.sm"}}, fields)
+
+	_, err = parseFields("power.draw,unknown.field")
+	assert.NotNil(t, err)
+
+	assert.Len(t, removeField(fields, "clocks.sm"), 1)
 }
@@ -8,6 +8,8 @@ var flags = []cli.Flag{
 	cliHTTPHost,
 	cliHTTPPort,
 	cliStatusPage,
+	cliSocket,
+	cliScript,
 	cliTempFile,
 }
 
@@ -32,6 +34,20 @@ var cliStatusPage = cli.StringFlag{
 	EnvVar: "ENVVAR_STATUS_PAGE",
 }
 
+var cliSocket = cli.StringFlag{
+	Name:   "socket",
+	Value:  "",
+	Usage:  "Set php-fpm FastCGI socket (e.g. unix:/run/php-fpm/www.sock, tcp:127.0.0.1:9000) to execute the status script without httpd.",
+	EnvVar: "ENVVAR_SOCKET",
+}
+
+var cliScript = cli.StringFlag{
+	Name:   "script",
+	Value:  "",
+	Usage:  "Set path to write the status script, which php-fpm executes with --socket (e.g. /var/lib/mackerel-agent/php-apc.php). The directory must be owned by the running user and not writable by others.",
+	EnvVar: "ENVVAR_SCRIPT",
+}
+
 var cliTempFile = cli.StringFlag{
 	Name:   "tempfile, t",
 	Value:  "/tmp/mackerel-plugin-php-apc",
@@ -8,8 +8,10 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/fastcgi"
 	"github.com/urfave/cli"
 )
 
@@ -51,10 +53,14 @@ var graphdef = map[string]mp.Graphs{
 }
 
 // PhpApcPlugin mackerel plugin for php-apc
+// If Socket is set (e.g. "unix:/run/php-fpm/www.sock"), the status script is written to Script
+// and executed by php-fpm over FastCGI instead of requesting the httpd.
 type PhpApcPlugin struct {
 	Host     string
 	Port     uint16
 	Path     string
+	Socket   string
+	Script   string
 	Tempfile string
 }
 
@@ -71,6 +77,12 @@ func doMain(c *cli.Context) error {
 	phpapc.Host = c.String("http_host")
 	phpapc.Port = uint16(c.Int("http_port"))
 	phpapc.Path = c.String("status_page")
+	phpapc.Socket = c.String("socket")
+	phpapc.Script = c.String("script")
+	if phpapc.Socket != "" && phpapc.Script == "" {
+		fmt.Fprintln(os.Stderr, "failed to exec mackerel-plugin-php-apc: --script is required with --socket")
+		os.Exit(1)
+	}
 
 	helper := mp.NewMackerelPlugin(phpapc)
 	helper.Tempfile = c.String("tempfile")
@@ -85,7 +97,15 @@ func doMain(c *cli.Context) error {
 
 // FetchMetrics interface for mackerelplugin
 func (c PhpApcPlugin) FetchMetrics() (map[string]float64, error) {
-	data, err := getPhpApcMetrics(c.Host, c.Port, c.Path)
+	var data string
+	var err error
+	if c.Socket != "" {
+		var body []byte
+		body, err = fastcgi.RunScript(c.Socket, c.Script, statusScript, 10*time.Second)
+		data = string(body)
+	} else {
+		data, err = getPhpApcMetrics(c.Host, c.Port, c.Path)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -2,9 +2,16 @@
 
 header("Content-Type: text/plain");
 
-$cache      = apc_cache_info();
-$cache_user = apc_cache_info('user', 1); 
-$mem        = apc_sma_info();
+if (function_exists('apcu_cache_info')) {
+    // APCu has the user cache only
+    $cache      = array('num_entries' => 0, 'mem_size' => 0, 'num_hits' => 0, 'num_misses' => 0, 'expunges' => 0);
+    $cache_user = apcu_cache_info(true);
+    $mem        = apcu_sma_info(true);
+} else {
+    $cache      = apc_cache_info();
+    $cache_user = apc_cache_info('user', 1);
+    $mem        = apc_sma_info();
+}
 
 $stats = array(
     "memory_segments"       => (int)$mem['num_seg'],
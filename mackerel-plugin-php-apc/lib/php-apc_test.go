@@ -2,8 +2,13 @@ package mpphpapc
 
 import (
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/fcgi"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"testing"
@@ -92,3 +97,56 @@ user_cache_full_count:0`
 	assert.Contains(t, ret, "user_cache_misses")
 	assert.Contains(t, ret, "user_cache_full_count")
 }
+
+func TestStatusScript(t *testing.T) {
+	b, err := ioutil.ReadFile("php-apc.php")
+	assert.Nil(t, err)
+	assert.Equal(t, string(b), statusScript, "statusScript should be the same as php-apc.php")
+}
+
+func TestFetchMetricsFastCGI(t *testing.T) {
+	stub := `memory_segments:1
+segment_size:33554296
+total_memory:33554296
+cached_files_count:0
+cached_files_size:0
+user_cache_vars_count:12
+user_cache_vars_size:51616
+user_cache_hits:300
+user_cache_misses:25
+user_cache_full_count:0
+`
+	dir, err := ioutil.TempDir("", "mackerel-plugin-php-apc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "php-apc.php")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go fcgi.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != script {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, stub)
+	}))
+
+	p := PhpApcPlugin{Socket: "tcp:" + l.Addr().String(), Script: script}
+	stat, err := p.FetchMetrics()
+	assert.Nil(t, err)
+	assert.EqualValues(t, 33554296, stat["total_memory"])
+	assert.EqualValues(t, 300, stat["user_cache_hits"])
+
+	b, err := ioutil.ReadFile(script)
+	assert.Nil(t, err)
+	assert.Equal(t, statusScript, string(b))
+
+	p.Script = filepath.Join(dir, "missing", "php-apc.php")
+	_, err = p.FetchMetrics()
+	assert.NotNil(t, err)
+}
@@ -6,16 +6,29 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"regexp"
+	"strings"
 	"time"
 
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+	"github.com/mackerelio/mackerel-agent-plugins/fastcgi"
+	"github.com/mackerelio/mackerel-agent/logging"
 )
 
+var logger = logging.GetLogger("metrics.plugin.php-fpm")
+
 // PhpFpmPlugin mackerel plugin
 type PhpFpmPlugin struct {
 	URL     string
 	Prefix  string
 	Timeout uint
+	// Targets are the status pages of the pools, or URL is used if empty
+	Targets []statusTarget
+	// PerPool graphs each pool under its pool name
+	PerPool bool
+	// SlowThreshold is the request duration of the processes counted as stuck
+	SlowThreshold time.Duration
 }
 
 // PhpFpmStatus struct for PhpFpmPlugin mackerel plugin
@@ -34,11 +47,22 @@ type PhpFpmStatus struct {
 	MaxActiveProcesses uint64 `json:"max active processes"`
 	MaxChildrenReached uint64 `json:"max children reached"`
 	SlowRequests       uint64 `json:"slow requests"`
+	// Processes are given by the "full" parameter
+	Processes []PhpFpmProcess `json:"processes"`
+}
+
+// PhpFpmProcess is a process of the full status
+type PhpFpmProcess struct {
+	Pid      uint64 `json:"pid"`
+	State    string `json:"state"`
+	Requests uint64 `json:"requests"`
+	// RequestDuration is in microseconds, of the current request, or of the last request if idle
+	RequestDuration uint64 `json:"request duration"`
 }
 
 // GraphDefinition interface for mackerelplugin
 func (p PhpFpmPlugin) GraphDefinition() map[string]mp.Graphs {
-	return map[string]mp.Graphs{
+	graphs := map[string]mp.Graphs{
 		p.Prefix + ".processes": {
 			Label: "PHP-FPM Processes",
 			Unit:  "integer",
@@ -84,65 +108,224 @@ func (p PhpFpmPlugin) GraphDefinition() map[string]mp.Graphs {
 				{Name: "slow_requests", Label: "Slow Requests", Diff: false, Type: "uint64"},
 			},
 		},
+		p.Prefix + ".request_duration": {
+			Label: "PHP-FPM Request Duration (msec)",
+			Unit:  "float",
+			Metrics: []mp.Metrics{
+				{Name: "max_request_duration", Label: "Max Request Duration", Diff: false},
+			},
+		},
+		p.Prefix + ".stuck_processes": {
+			Label: "PHP-FPM Stuck Processes",
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "stuck_processes", Label: "Stuck Processes", Diff: false},
+			},
+		},
+	}
+	if !p.PerPool {
+		return graphs
 	}
+	perPool := make(map[string]mp.Graphs)
+	for name, g := range graphs {
+		perPool[name+".#"] = g
+	}
+	return perPool
 }
 
 // FetchMetrics interface for mackerelplugin
 func (p PhpFpmPlugin) FetchMetrics() (map[string]interface{}, error) {
-	status, err := getStatus(p)
-	if err != nil {
-		return nil, fmt.Errorf("Faild to fetch PHP-FPM metrics: %s", err)
-	}
-
-	return map[string]interface{}{
-		"total_processes":      status.TotalProcesses,
-		"active_processes":     status.ActiveProcesses,
-		"idle_processes":       status.IdleProcesses,
-		"max_active_processes": status.MaxActiveProcesses,
-		"max_children_reached": status.MaxChildrenReached,
-		"listen_queue":         status.ListenQueue,
-		"listen_queue_len":     status.ListenQueueLen,
-		"max_listen_queue":     status.MaxListenQueue,
-		"slow_requests":        status.SlowRequests,
-	}, nil
+	targets := append([]statusTarget(nil), p.Targets...)
+	if len(targets) == 0 {
+		targets = []statusTarget{{URL: p.URL}}
+	}
+	for i, t := range targets {
+		if t.URL != "" {
+			targets[i].URL = withFull(t.URL)
+		}
+	}
+
+	stat := make(map[string]interface{})
+	var lastErr error
+	for _, target := range targets {
+		status, err := p.fetchStatus(target)
+		if err != nil {
+			// a pool which cannot be fetched does not prevent the others
+			logger.Warningf("Failed to fetch PHP-FPM metrics of %s: %s", target, err)
+			lastErr = err
+			continue
+		}
+		prefix := ""
+		if p.PerPool {
+			prefix = poolNameRe.ReplaceAllString(status.Pool, "_")
+		}
+		p.setMetrics(stat, prefix, status)
+	}
+	if len(stat) == 0 && lastErr != nil {
+		return nil, fmt.Errorf("Failed to fetch PHP-FPM metrics: %s", lastErr)
+	}
+	return stat, nil
 }
 
-func getStatus(p PhpFpmPlugin) (*PhpFpmStatus, error) {
-	url := p.URL
-	timeout := time.Duration(time.Duration(p.Timeout) * time.Second)
-	client := http.Client{
-		Timeout: timeout,
+var poolNameRe = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+func (t statusTarget) String() string {
+	if t.URL != "" {
+		return t.URL
 	}
+	return t.Network + ":" + t.Address + t.StatusPath
+}
 
-	res, err := client.Get(url)
-	if err != nil {
-		return nil, err
+// poolMetric is a metric value and the graph name of it
+type poolMetric struct {
+	graph string
+	value interface{}
+}
+
+// setMetrics sets the metrics of a pool, whose keys are prefixed with the graph names and the pool name
+// if pool is not empty.
+func (p PhpFpmPlugin) setMetrics(stat map[string]interface{}, pool string, status *PhpFpmStatus) {
+	metrics := map[string]poolMetric{
+		"total_processes":      {"processes", status.TotalProcesses},
+		"active_processes":     {"processes", status.ActiveProcesses},
+		"idle_processes":       {"processes", status.IdleProcesses},
+		"max_active_processes": {"max_active_processes", status.MaxActiveProcesses},
+		"max_children_reached": {"max_children_reached", status.MaxChildrenReached},
+		"listen_queue":         {"queue", status.ListenQueue},
+		"listen_queue_len":     {"queue", status.ListenQueueLen},
+		"max_listen_queue":     {"max_listen_queue", status.MaxListenQueue},
+		"slow_requests":        {"slow_requests", status.SlowRequests},
+	}
+	if status.Processes != nil {
+		var maxDuration uint64
+		var stuck float64
+		for _, proc := range status.Processes {
+			// the request duration of an idle process is that of the last request
+			if proc.State == "Idle" {
+				continue
+			}
+			if proc.RequestDuration > maxDuration {
+				maxDuration = proc.RequestDuration
+			}
+			if p.SlowThreshold > 0 && time.Duration(proc.RequestDuration)*time.Microsecond > p.SlowThreshold {
+				stuck++
+			}
+		}
+		metrics["max_request_duration"] = poolMetric{"request_duration", float64(maxDuration) / 1000}
+		metrics["stuck_processes"] = poolMetric{"stuck_processes", stuck}
 	}
 
-	body, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
+	for name, m := range metrics {
+		if pool == "" {
+			stat[name] = m.value
+		} else {
+			stat[fmt.Sprintf("%s.%s.%s.%s", p.Prefix, m.graph, pool, name)] = m.value
+		}
 	}
+}
 
-	var status *PhpFpmStatus
-	json.Unmarshal(body, &status)
+func getStatus(p PhpFpmPlugin) (*PhpFpmStatus, error) {
+	return p.fetchStatus(statusTarget{URL: p.URL})
+}
+
+// fetchStatus gets the full status of a pool by HTTP or FastCGI
+func (p PhpFpmPlugin) fetchStatus(target statusTarget) (*PhpFpmStatus, error) {
+	timeout := time.Duration(time.Duration(p.Timeout) * time.Second)
+
+	var body []byte
+	if target.URL != "" {
+		client := http.Client{
+			Timeout: timeout,
+		}
+
+		res, err := client.Get(target.URL)
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("status page returned %s", res.Status)
+		}
+
+		body, err = ioutil.ReadAll(res.Body)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		statusCode, b, err := fastcgi.Get(target.Network, target.Address, target.StatusPath+"?json&full", nil, timeout)
+		if err != nil {
+			return nil, err
+		}
+		if statusCode != http.StatusOK {
+			return nil, fmt.Errorf("status page returned %d", statusCode)
+		}
+		body = b
+	}
 
+	var status *PhpFpmStatus
+	if err := json.Unmarshal(body, &status); err != nil {
+		return nil, err
+	}
+	if status == nil {
+		return nil, fmt.Errorf("status page returned null")
+	}
 	return status, nil
 }
 
+// withFull adds the "full" parameter to get the processes
+func withFull(uri string) string {
+	i := strings.Index(uri, "?")
+	if i >= 0 && strings.Contains(uri[i:], "full") {
+		return uri
+	}
+	if i < 0 {
+		return uri + "?full"
+	}
+	return uri + "&full"
+}
+
 // Do the plugin
 func Do() {
-	optURL := flag.String("url", "http://localhost/status?json", "PHP-FPM status page URL")
+	optURL := &stringSlice{}
+	flag.Var(optURL, "url", "PHP-FPM status page URL (can be specified multiple times, default: http://localhost/status?json)")
+	optSocket := &stringSlice{}
+	flag.Var(optSocket, "socket", "FastCGI socket of a pool to get the status page directly, e.g. unix:/run/php-fpm/www.sock or tcp:127.0.0.1:9000 (can be specified multiple times)")
+	optStatusPath := flag.String("status-path", "/status", "pm.status_path of the pools for -socket")
+	optConfDir := flag.String("conf-dir", "", "Directory of the pool configurations to discover the pools with pm.status_path (e.g. /etc/php-fpm.d)")
+	optSlowThreshold := flag.Duration("slow-threshold", 5*time.Second, "Request duration of the processes counted as stuck")
 	optPrefix := flag.String("metric-key-prefix", "php-fpm", "Metric key prefix")
 	optTimeout := flag.Uint("timeout", 5, "Timeout")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
 	flag.Parse()
 
 	p := PhpFpmPlugin{
-		URL:     *optURL,
-		Prefix:  *optPrefix,
-		Timeout: *optTimeout,
+		Prefix:        *optPrefix,
+		Timeout:       *optTimeout,
+		SlowThreshold: *optSlowThreshold,
+	}
+	for _, u := range *optURL {
+		p.Targets = append(p.Targets, statusTarget{URL: u})
+	}
+	for _, s := range *optSocket {
+		network, address := fastcgi.ParseAddress(s)
+		p.Targets = append(p.Targets, statusTarget{Network: network, Address: address, StatusPath: *optStatusPath})
+	}
+	if *optConfDir != "" {
+		pools, err := discoverPools(*optConfDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-php-fpm: %s\n", err)
+			os.Exit(1)
+		}
+		p.Targets = append(p.Targets, pools...)
+		p.PerPool = true
+	}
+	if len(p.Targets) == 0 {
+		p.URL = "http://localhost/status?json"
+	}
+	if len(p.Targets) > 1 {
+		p.PerPool = true
 	}
+
 	helper := mp.NewMackerelPlugin(p)
 	helper.Tempfile = *optTempfile
 	if helper.Tempfile == "" {
@@ -151,3 +334,14 @@ func Do() {
 
 	helper.Run()
 }
+
+type stringSlice []string
+
+func (s *stringSlice) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func (s *stringSlice) String() string {
+	return fmt.Sprintf("%v", *s)
+}
@@ -1,7 +1,16 @@
 package mpphpfpm
 
 import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/jarcoal/httpmock"
 	"github.com/stretchr/testify/assert"
@@ -48,3 +57,118 @@ func TestGetStatus(t *testing.T) {
 	assert.EqualValues(t, 3, status.MaxListenQueue)
 	assert.EqualValues(t, 1000, status.SlowRequests)
 }
+
+var fullStatus = `{"pool":"www","process manager":"dynamic","start time":1461398921,"start since":1624,"accepted conn":664,
+"listen queue":0,"max listen queue":0,"listen queue len":128,"idle processes":1,"active processes":2,"total processes":3,
+"max active processes":3,"max children reached":0,"slow requests":4,
+"processes":[
+{"pid":101,"state":"Idle","start time":1461398921,"start since":1624,"requests":100,"request duration":30000000,"request method":"GET","request uri":"/index.php","content length":0,"user":"-","script":"/var/www/index.php","last request cpu":0.00,"last request memory":2097152},
+{"pid":102,"state":"Running","start time":1461398921,"start since":1624,"requests":90,"request duration":12000000,"request method":"POST","request uri":"/batch.php","content length":10,"user":"-","script":"/var/www/batch.php","last request cpu":0.00,"last request memory":0},
+{"pid":103,"state":"Running","start time":1461398921,"start since":1624,"requests":80,"request duration":2500,"request method":"GET","request uri":"/status?json&full","content length":0,"user":"-","script":"-","last request cpu":0.00,"last request memory":0}
+]}`
+
+func TestFetchMetricsFull(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://httpmock/status?json&full",
+		httpmock.NewStringResponder(200, fullStatus))
+	httpmock.RegisterResponder("GET", "http://httpmock/api-status?json&full",
+		httpmock.NewStringResponder(200, strings.Replace(fullStatus, `"pool":"www"`, `"pool":"api.v1"`, 1)))
+	httpmock.RegisterResponder("GET", "http://httpmock/broken?json&full",
+		httpmock.NewStringResponder(404, "File not found."))
+
+	p := PhpFpmPlugin{
+		URL:           "http://httpmock/status?json",
+		Prefix:        "php-fpm",
+		Timeout:       5,
+		SlowThreshold: 10 * time.Second,
+	}
+	stat, err := p.FetchMetrics()
+	assert.Nil(t, err)
+	assert.EqualValues(t, 3, stat["total_processes"])
+	assert.EqualValues(t, 12000, stat["max_request_duration"])
+	assert.EqualValues(t, 1, stat["stuck_processes"])
+
+	p.Targets = []statusTarget{
+		{URL: "http://httpmock/status?json"},
+		{URL: "http://httpmock/api-status?json"},
+		{URL: "http://httpmock/broken?json"},
+	}
+	p.PerPool = true
+	stat, err = p.FetchMetrics()
+	assert.Nil(t, err)
+	assert.EqualValues(t, 3, stat["php-fpm.processes.www.total_processes"])
+	assert.EqualValues(t, 4, stat["php-fpm.slow_requests.api_v1.slow_requests"])
+	assert.EqualValues(t, 1, stat["php-fpm.stuck_processes.www.stuck_processes"])
+	_, ok := stat["total_processes"]
+	assert.False(t, ok)
+
+	graphs := p.GraphDefinition()
+	assert.Contains(t, graphs, "php-fpm.processes.#")
+	assert.NotContains(t, graphs, "php-fpm.processes")
+
+	p.Targets = []statusTarget{{URL: "http://httpmock/broken?json"}}
+	_, err = p.FetchMetrics()
+	assert.NotNil(t, err)
+}
+
+func TestFetchStatusFastCGI(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go fcgi.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/fpm-status" || r.URL.RawQuery != "json&full" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, fullStatus)
+	}))
+
+	p := PhpFpmPlugin{Timeout: 5}
+	status, err := p.fetchStatus(statusTarget{Network: "tcp", Address: l.Addr().String(), StatusPath: "/fpm-status"})
+	assert.Nil(t, err)
+	assert.Equal(t, "www", status.Pool)
+	assert.Len(t, status.Processes, 3)
+
+	_, err = p.fetchStatus(statusTarget{Network: "tcp", Address: l.Addr().String(), StatusPath: "/status"})
+	assert.NotNil(t, err)
+}
+
+func TestDiscoverPools(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mackerel-plugin-php-fpm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := `; comment
+[www]
+user = www-data
+listen = /run/php/$pool.sock
+pm = dynamic
+pm.status_path = /status
+
+[api]
+listen = 9001
+pm.status_path = "/fpm-status"
+
+[nostatus]
+listen = 127.0.0.1:9002
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "www.conf"), []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "www.conf.default"), []byte("[default]\nlisten = 9003\npm.status_path = /status\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	targets, err := discoverPools(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, []statusTarget{
+		{Network: "unix", Address: "/run/php/www.sock", StatusPath: "/status"},
+		{Network: "tcp", Address: "127.0.0.1:9001", StatusPath: "/fpm-status"},
+	}, targets)
+}
@@ -0,0 +1,103 @@
+package mpphpfpm
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// statusTarget is a status page of a pool, requested by HTTP if URL is set, or by FastCGI otherwise
+type statusTarget struct {
+	URL        string
+	Network    string
+	Address    string
+	StatusPath string
+}
+
+// listenAddress converts "listen" of a pool into network and address.
+// A port only listens on all addresses, which are connected by 127.0.0.1.
+func listenAddress(listen string) (string, string) {
+	if strings.HasPrefix(listen, "/") {
+		return "unix", listen
+	}
+	if !strings.Contains(listen, ":") {
+		return "tcp", net.JoinHostPort("127.0.0.1", listen)
+	}
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "tcp", listen
+	}
+	if host == "" || host == "0.0.0.0" || host == "*" {
+		host = "127.0.0.1"
+	} else if host == "::" {
+		host = "::1"
+	}
+	return "tcp", net.JoinHostPort(host, port)
+}
+
+// discoverPools reads the pool configurations (*.conf) in dir and returns the targets of the pools
+// which have pm.status_path.
+func discoverPools(dir string) ([]statusTarget, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.conf"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var targets []statusTarget
+	for _, file := range files {
+		pools, err := parsePoolConfig(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, pool := range pools {
+			listen, statusPath := pool["listen"], pool["pm.status_path"]
+			if listen == "" || statusPath == "" {
+				continue
+			}
+			network, address := listenAddress(listen)
+			targets = append(targets, statusTarget{Network: network, Address: address, StatusPath: statusPath})
+		}
+	}
+	return targets, nil
+}
+
+// parsePoolConfig parses the ini file of pools, and returns the settings of each pool in order.
+// $pool in the values is replaced with the name of the pool as php-fpm does.
+func parsePoolConfig(file string) ([]map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var pools []map[string]string
+	var pool map[string]string
+	var name string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name = strings.TrimSpace(line[1 : len(line)-1])
+			pool = nil
+			if name != "global" {
+				pool = map[string]string{}
+				pools = append(pools, pool)
+			}
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if pool == nil || len(kv) != 2 {
+			continue
+		}
+		value := strings.Trim(strings.TrimSpace(kv[1]), `"'`)
+		pool[strings.TrimSpace(kv[0])] = strings.Replace(value, "$pool", name, -1)
+	}
+	return pools, scanner.Err()
+}
@@ -8,8 +8,10 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/fastcgi"
 	"github.com/urfave/cli"
 )
 
@@ -50,13 +52,49 @@ var graphdef = map[string]mp.Graphs{
 			{Name: "blacklist_misses", Label: "Blacklist Misses", Diff: true, Stacked: false},
 		},
 	},
+	"php-opcache.restarts": {
+		Label: "PHP OPCache Restarts",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "oom_restarts", Label: "Out of Memory", Diff: true, Stacked: false},
+			{Name: "hash_restarts", Label: "Hash Table Full", Diff: true, Stacked: false},
+			{Name: "manual_restarts", Label: "Manual", Diff: true, Stacked: false},
+		},
+	},
+	"php-opcache.interned_strings_memory": {
+		Label: "PHP OPCache Interned Strings Memory",
+		Unit:  "bytes",
+		Metrics: []mp.Metrics{
+			{Name: "interned_strings_used_memory", Label: "Used Memory", Diff: false, Stacked: true},
+			{Name: "interned_strings_free_memory", Label: "Free Memory", Diff: false, Stacked: true},
+		},
+	},
+	"php-opcache.interned_strings": {
+		Label: "PHP OPCache Interned Strings",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "interned_strings_number_of_strings", Label: "Strings", Diff: false, Stacked: false},
+		},
+	},
+	"php-opcache.jit_buffer": {
+		Label: "PHP OPCache JIT Buffer",
+		Unit:  "bytes",
+		Metrics: []mp.Metrics{
+			{Name: "jit_buffer_used", Label: "Used", Diff: false, Stacked: true},
+			{Name: "jit_buffer_free", Label: "Free", Diff: false, Stacked: true},
+		},
+	},
 }
 
 // PhpOpcachePlugin mackerel plugin for php-opcache
+// If Socket is set (e.g. "unix:/run/php-fpm/www.sock"), the status script is written to Script
+// and executed by php-fpm over FastCGI instead of requesting the httpd.
 type PhpOpcachePlugin struct {
 	Host     string
 	Port     uint16
 	Path     string
+	Socket   string
+	Script   string
 	Tempfile string
 }
 
@@ -67,7 +105,15 @@ func (c PhpOpcachePlugin) GraphDefinition() map[string]mp.Graphs {
 
 // FetchMetrics interface for mackerelplugin
 func (c PhpOpcachePlugin) FetchMetrics() (map[string]float64, error) {
-	data, err := getPhpOpcacheMetrics(c.Host, c.Port, c.Path)
+	var data string
+	var err error
+	if c.Socket != "" {
+		var body []byte
+		body, err = fastcgi.RunScript(c.Socket, c.Script, statusScript, 10*time.Second)
+		data = string(body)
+	} else {
+		data, err = getPhpOpcacheMetrics(c.Host, c.Port, c.Path)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -124,6 +170,12 @@ func doMain(c *cli.Context) error {
 	phpopcache.Host = c.String("http_host")
 	phpopcache.Port = uint16(c.Int("http_port"))
 	phpopcache.Path = c.String("status_page")
+	phpopcache.Socket = c.String("socket")
+	phpopcache.Script = c.String("script")
+	if phpopcache.Socket != "" && phpopcache.Script == "" {
+		fmt.Fprintln(os.Stderr, "failed to exec mackerel-plugin-php-opcache: --script is required with --socket")
+		os.Exit(1)
+	}
 
 	helper := mp.NewMackerelPlugin(phpopcache)
 	helper.Tempfile = c.String("tempfile")
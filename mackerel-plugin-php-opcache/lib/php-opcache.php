@@ -2,7 +2,7 @@
 
 header("Content-Type: text/plain");
 
-$status = opcache_get_status();
+$status = opcache_get_status(false);
 $config = opcache_get_configuration();
 
 $stats = array(
@@ -26,6 +26,21 @@ $stats = array(
     'opcache_hit_rate'     => $status['opcache_statistics']['opcache_hit_rate'],
 );
 
+// interned_strings_usage (opcache.interned_strings_buffer)
+if (isset($status['interned_strings_usage'])) {
+    $stats['interned_strings_buffer_size']       = $status['interned_strings_usage']['buffer_size'];
+    $stats['interned_strings_used_memory']       = $status['interned_strings_usage']['used_memory'];
+    $stats['interned_strings_free_memory']       = $status['interned_strings_usage']['free_memory'];
+    $stats['interned_strings_number_of_strings'] = $status['interned_strings_usage']['number_of_strings'];
+}
+
+// jit (PHP 8.0+)
+if (isset($status['jit']) && $status['jit']['buffer_size'] > 0) {
+    $stats['jit_buffer_size'] = $status['jit']['buffer_size'];
+    $stats['jit_buffer_free'] = $status['jit']['buffer_free'];
+    $stats['jit_buffer_used'] = $status['jit']['buffer_size'] - $status['jit']['buffer_free'];
+}
+
 foreach( $stats as $name => $value ){
     echo sprintf( "%s:%d\n", $name,  $value );
 }
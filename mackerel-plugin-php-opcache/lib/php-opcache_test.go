@@ -2,8 +2,13 @@ package mpphpopcache
 
 import (
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/fcgi"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"testing"
@@ -100,3 +105,56 @@ opcache_hit_rate:0`
 	assert.Contains(t, ret, "blacklist_miss_ratio")
 	assert.Contains(t, ret, "opcache_hit_rate")
 }
+
+func TestStatusScript(t *testing.T) {
+	b, err := ioutil.ReadFile("php-opcache.php")
+	assert.Nil(t, err)
+	assert.Equal(t, string(b), statusScript, "statusScript should be the same as php-opcache.php")
+}
+
+func TestFetchMetricsFastCGI(t *testing.T) {
+	stub := `used_memory:8462520
+free_memory:125755208
+wasted_memory:0
+oom_restarts:1
+hash_restarts:0
+manual_restarts:2
+interned_strings_buffer_size:8388608
+interned_strings_used_memory:1845536
+interned_strings_free_memory:6543072
+interned_strings_number_of_strings:16574
+jit_buffer_size:67108864
+jit_buffer_free:66060288
+jit_buffer_used:1048576
+`
+	dir, err := ioutil.TempDir("", "mackerel-plugin-php-opcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "php-opcache.php")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go fcgi.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != script {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, stub)
+	}))
+
+	p := PhpOpcachePlugin{Socket: "tcp:" + l.Addr().String(), Script: script}
+	stat, err := p.FetchMetrics()
+	assert.Nil(t, err)
+	assert.EqualValues(t, 2, stat["manual_restarts"])
+	assert.EqualValues(t, 16574, stat["interned_strings_number_of_strings"])
+	assert.EqualValues(t, 1048576, stat["jit_buffer_used"])
+
+	b, err := ioutil.ReadFile(script)
+	assert.Nil(t, err)
+	assert.Equal(t, statusScript, string(b))
+}
@@ -0,0 +1,50 @@
+package mpphpopcache
+
+// statusScript is php-opcache.php, which is written to the script path and executed by php-fpm over FastCGI
+const statusScript = `<?php
+
+header("Content-Type: text/plain");
+
+$status = opcache_get_status(false);
+$config = opcache_get_configuration();
+
+$stats = array(
+    // memory_usage
+    'used_memory'   =>   $status['memory_usage']['used_memory'],
+    'free_memory'   => $status['memory_usage']['free_memory'],
+    'wasted_memory' => $status['memory_usage']['wasted_memory'],
+    'current_wasted_percentage' => $status['memory_usage']['current_wasted_percentage'],
+
+    // opcache_statistics
+    'num_cached_scripts'   => $status['opcache_statistics']['num_cached_scripts'],
+    'num_cached_keys'      => $status['opcache_statistics']['num_cached_keys'],
+    'max_cached_keys'      => $status['opcache_statistics']['max_cached_keys'],
+    'hits'                 => $status['opcache_statistics']['hits'],
+    'oom_restarts'         => $status['opcache_statistics']['oom_restarts'],
+    'hash_restarts'        => $status['opcache_statistics']['hash_restarts'],
+    'manual_restarts'      => $status['opcache_statistics']['manual_restarts'],
+    'misses'               => $status['opcache_statistics']['misses'],
+    'blacklist_misses'     => $status['opcache_statistics']['blacklist_misses'],
+    'blacklist_miss_ratio' => $status['opcache_statistics']['blacklist_miss_ratio'],
+    'opcache_hit_rate'     => $status['opcache_statistics']['opcache_hit_rate'],
+);
+
+// interned_strings_usage (opcache.interned_strings_buffer)
+if (isset($status['interned_strings_usage'])) {
+    $stats['interned_strings_buffer_size']       = $status['interned_strings_usage']['buffer_size'];
+    $stats['interned_strings_used_memory']       = $status['interned_strings_usage']['used_memory'];
+    $stats['interned_strings_free_memory']       = $status['interned_strings_usage']['free_memory'];
+    $stats['interned_strings_number_of_strings'] = $status['interned_strings_usage']['number_of_strings'];
+}
+
+// jit (PHP 8.0+)
+if (isset($status['jit']) && $status['jit']['buffer_size'] > 0) {
+    $stats['jit_buffer_size'] = $status['jit']['buffer_size'];
+    $stats['jit_buffer_free'] = $status['jit']['buffer_free'];
+    $stats['jit_buffer_used'] = $status['jit']['buffer_size'] - $status['jit']['buffer_free'];
+}
+
+foreach( $stats as $name => $value ){
+    echo sprintf( "%s:%d\n", $name,  $value );
+}
+`
@@ -8,17 +8,24 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+	"github.com/mackerelio/mackerel-agent/logging"
 )
 
+var logger = logging.GetLogger("metrics.plugin.plack")
+
 // PlackPlugin mackerel plugin for Plack
 type PlackPlugin struct {
 	URI         string
 	Prefix      string
 	LabelPrefix string
+	// SlowThreshold is the age of the current request over which a busy worker is counted as stuck
+	SlowThreshold time.Duration
 }
 
 // {
@@ -64,7 +71,16 @@ type PlackPlugin struct {
 // field types vary between versions
 
 // PlackRequest request
-type PlackRequest struct{}
+type PlackRequest struct {
+	Pid        interface{} `json:"pid"`
+	Method     string      `json:"method"`
+	Ss         interface{} `json:"ss"` // seconds since the request started (or finished, if idle)
+	RemoteAddr string      `json:"remote_addr"`
+	Host       string      `json:"host"`
+	Protocol   string      `json:"protocol"`
+	Status     string      `json:"status"` // "_" for idle and "A" for active
+	URI        string      `json:"uri"`
+}
 
 // PlackServerStatus sturct for server-status's json
 type PlackServerStatus struct {
@@ -116,9 +132,73 @@ func (p PlackPlugin) parseStats(body io.Reader) (map[string]interface{}, error)
 	if err != nil {
 		return nil, errors.New("cannot get values")
 	}
+
+	p.parseWorkers(s.Stats, stat)
 	return stat, nil
 }
 
+var invalidNameChars = regexp.MustCompile("[^-a-zA-Z0-9_]")
+
+// parseWorkers counts the busy workers by method and status, and calculates the ages of the current requests.
+// The workers whose ss cannot be parsed are skipped.
+func (p PlackPlugin) parseWorkers(workers []PlackRequest, stat map[string]interface{}) {
+	var busy, stuck uint64
+	var maxAge, totalAge float64
+	byMethod := make(map[string]uint64)
+	byStatus := make(map[string]uint64)
+	for _, w := range workers {
+		if w.Status == "_" || w.Status == "" {
+			continue
+		}
+		age, err := toFloat(w.Ss)
+		if err != nil {
+			logger.Warningf("Failed to parse ss of worker %v: %s", w.Pid, err)
+			continue
+		}
+		busy++
+		totalAge += age
+		if age > maxAge {
+			maxAge = age
+		}
+		if p.SlowThreshold > 0 && age >= p.SlowThreshold.Seconds() {
+			stuck++
+		}
+
+		method := strings.ToUpper(w.Method)
+		if method == "" {
+			method = "unknown"
+		}
+		byMethod[invalidNameChars.ReplaceAllString(method, "_")]++
+		byStatus[invalidNameChars.ReplaceAllString(w.Status, "_")]++
+	}
+
+	stat["max_request_age"] = maxAge
+	stat["avg_request_age"] = 0.0
+	if busy > 0 {
+		stat["avg_request_age"] = totalAge / float64(busy)
+	}
+	stat["stuck_workers"] = stuck
+	for method, n := range byMethod {
+		stat[p.Prefix+".busy_workers_by_method."+method+".workers"] = n
+	}
+	for status, n := range byStatus {
+		stat[p.Prefix+".busy_workers_by_status."+status+".workers"] = n
+	}
+}
+
+// toFloat converts a JSON number or a JSON string of a number
+func toFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		return n, nil
+	case string:
+		return strconv.ParseFloat(n, 64)
+	}
+	return 0, fmt.Errorf("unexpected type %T", v)
+}
+
 // GraphDefinition interface for mackerelplugin
 func (p PlackPlugin) GraphDefinition() map[string]mp.Graphs {
 	var graphdef = map[string]mp.Graphs{
@@ -144,6 +224,35 @@ func (p PlackPlugin) GraphDefinition() map[string]mp.Graphs {
 				{Name: "bytes_sent", Label: "Bytes Sent", Diff: true, Type: "uint64"},
 			},
 		},
+		(p.Prefix + ".busy_workers_by_method.#"): {
+			Label: p.LabelPrefix + " Busy Workers by Method",
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "workers", Label: "Workers", Diff: false, Type: "uint64"},
+			},
+		},
+		(p.Prefix + ".busy_workers_by_status.#"): {
+			Label: p.LabelPrefix + " Busy Workers by Status",
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "workers", Label: "Workers", Diff: false, Type: "uint64"},
+			},
+		},
+		(p.Prefix + ".request_age"): {
+			Label: p.LabelPrefix + " Current Request Age",
+			Unit:  "float",
+			Metrics: []mp.Metrics{
+				{Name: "max_request_age", Label: "Max", Diff: false},
+				{Name: "avg_request_age", Label: "Average", Diff: false},
+			},
+		},
+		(p.Prefix + ".stuck_workers"): {
+			Label: p.LabelPrefix + " Stuck Workers",
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "stuck_workers", Label: "Stuck Workers", Diff: false, Type: "uint64"},
+			},
+		},
 	}
 
 	return graphdef
@@ -158,10 +267,11 @@ func Do() {
 	optPath := flag.String("path", "/server-status?json", "Path")
 	optPrefix := flag.String("metric-key-prefix", "plack", "Prefix")
 	optLabelPrefix := flag.String("metric-label-prefix", "", "Label Prefix")
+	optSlowThreshold := flag.Duration("slow-threshold", 10*time.Second, "Age of the current request over which a busy worker is counted as stuck")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
 	flag.Parse()
 
-	plack := PlackPlugin{URI: *optURI, Prefix: *optPrefix, LabelPrefix: *optLabelPrefix}
+	plack := PlackPlugin{URI: *optURI, Prefix: *optPrefix, LabelPrefix: *optLabelPrefix, SlowThreshold: *optSlowThreshold}
 	if plack.URI == "" {
 		plack.URI = fmt.Sprintf("%s://%s:%s%s", *optScheme, *optHost, *optPort, *optPath)
 	}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -13,8 +14,8 @@ func TestGraphDefinition(t *testing.T) {
 	var plack PlackPlugin
 
 	graphdef := plack.GraphDefinition()
-	if len(graphdef) != 3 {
-		t.Errorf("GetTempfilename: %d should be 3", len(graphdef))
+	if len(graphdef) != 7 {
+		t.Errorf("GetTempfilename: %d should be 7", len(graphdef))
 	}
 }
 
@@ -81,3 +82,41 @@ func TestParse(t *testing.T) {
 	fmt.Println(statWithIntUptime)
 	assert.Nil(t, err)
 }
+
+func TestParseWorkers(t *testing.T) {
+	plack := PlackPlugin{Prefix: "plack", SlowThreshold: 30 * time.Second}
+	stub := `{
+  "Uptime": 1474047568,
+  "TotalAccesses": "120",
+  "IdleWorkers": "1",
+  "TotalKbytes": "36",
+  "BusyWorkers": "4",
+  "stats": [
+    {"pid": 101, "method": "GET", "ss": 2, "status": "A", "uri": "/"},
+    {"pid": 102, "method": "POST", "ss": 45, "status": "A", "uri": "/upload"},
+    {"pid": 103, "method": "GET", "ss": "12", "status": "A", "uri": "/search"},
+    {"pid": 104, "method": "GET", "ss": 300, "status": "_", "uri": "/"},
+    {"pid": 105, "method": "M-SEARCH", "ss": 1, "status": "A", "uri": "*"},
+    {"pid": 106, "method": "GET", "ss": "unknown", "status": "A", "uri": "/"}
+  ]
+}`
+
+	// the worker whose ss cannot be parsed is skipped
+	stat, err := plack.parseStats(bytes.NewBufferString(stub))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 4, stat["busy_workers"])
+	assert.EqualValues(t, 2, stat["plack.busy_workers_by_method.GET.workers"])
+	assert.EqualValues(t, 1, stat["plack.busy_workers_by_method.POST.workers"])
+	assert.EqualValues(t, 1, stat["plack.busy_workers_by_method.M-SEARCH.workers"])
+	assert.EqualValues(t, 4, stat["plack.busy_workers_by_status.A.workers"])
+	assert.Nil(t, stat["plack.busy_workers_by_status._.workers"])
+	assert.EqualValues(t, 45, stat["max_request_age"])
+	assert.EqualValues(t, 15, stat["avg_request_age"])
+	assert.EqualValues(t, 1, stat["stuck_workers"])
+
+	stat, err = plack.parseStats(bytes.NewBufferString(`{"TotalKbytes":"36","IdleWorkers":"2","BusyWorkers":"0","TotalAccesses":"670","stats":[],"Uptime":1474047568}`))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, stat["max_request_age"])
+	assert.EqualValues(t, 0, stat["avg_request_age"])
+	assert.EqualValues(t, 0, stat["stuck_workers"])
+}
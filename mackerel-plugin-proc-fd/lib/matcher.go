@@ -0,0 +1,162 @@
+package mpprocfd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Matcher selects processes by one of the following types
+//
+//	exe:     regexp for the path of the executable (/proc/PID/exe)
+//	comm:    regexp for the command name (/proc/PID/comm)
+//	cmdline: regexp for the command line joined with spaces (/proc/PID/cmdline)
+//	pidfile: path of a pid file
+//	unit:    systemd unit name found in /proc/PID/cgroup
+type Matcher struct {
+	Name  string
+	Type  string
+	Value string
+	re    *regexp.Regexp
+}
+
+// NewMatcher creates a Matcher. The name is normalized to be used in metric names.
+func NewMatcher(name, typ, value string) (Matcher, error) {
+	m := Matcher{Name: normalizeForMetricName(name), Type: typ, Value: value}
+	if m.Name == "" {
+		return m, fmt.Errorf("matcher name is required")
+	}
+	switch typ {
+	case "exe", "comm", "cmdline":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return m, err
+		}
+		m.re = re
+	case "pidfile":
+	case "unit":
+		if !strings.Contains(value, ".") {
+			m.Value = value + ".service"
+		}
+	default:
+		return m, fmt.Errorf("unknown matcher type: %s", typ)
+	}
+	return m, nil
+}
+
+// ParseMatcher parses a matcher in the form of "<name>:<type>:<value>"
+// (e.g. "nginx:comm:^nginx$", "app:pidfile:/var/run/app.pid", "web:unit:nginx.service").
+func ParseMatcher(s string) (Matcher, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 {
+		return Matcher{}, fmt.Errorf("invalid matcher %q: should be <name>:<type>:<value>", s)
+	}
+	return NewMatcher(parts[0], parts[1], parts[2])
+}
+
+// FindPids returns pids of processes which match under procRoot (usually /proc).
+// Processes which disappear during the scan are just skipped.
+func (m Matcher) FindPids(procRoot string) ([]string, error) {
+	if m.Type == "pidfile" {
+		content, err := ioutil.ReadFile(m.Value)
+		if err != nil {
+			return nil, err
+		}
+		pid := strings.TrimSpace(string(content))
+		if _, err := strconv.Atoi(pid); err != nil {
+			return nil, fmt.Errorf("invalid pid file %s: %q", m.Value, pid)
+		}
+		if _, err := os.Stat(filepath.Join(procRoot, pid)); err != nil {
+			return nil, nil
+		}
+		return []string{pid}, nil
+	}
+
+	dir, err := os.Open(procRoot)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	// mackerel-agent runs the plugin through a shell whose command line may match
+	self, parent := strconv.Itoa(os.Getpid()), strconv.Itoa(os.Getppid())
+	var pids []string
+	for _, pid := range names {
+		if _, err := strconv.Atoi(pid); err != nil || pid == self || pid == parent {
+			continue
+		}
+		if m.match(filepath.Join(procRoot, pid)) {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+func (m Matcher) match(procDir string) bool {
+	switch m.Type {
+	case "exe":
+		exe, err := os.Readlink(filepath.Join(procDir, "exe"))
+		if err != nil {
+			return false
+		}
+		return m.re.MatchString(strings.TrimSuffix(exe, " (deleted)"))
+	case "comm":
+		comm, err := ioutil.ReadFile(filepath.Join(procDir, "comm"))
+		if err != nil {
+			return false
+		}
+		return m.re.MatchString(strings.TrimSpace(string(comm)))
+	case "cmdline":
+		cmdline, err := ioutil.ReadFile(filepath.Join(procDir, "cmdline"))
+		if err != nil || len(cmdline) == 0 {
+			return false
+		}
+		line := string(bytes.Replace(bytes.TrimRight(cmdline, "\x00"), []byte{0}, []byte{' '}, -1))
+		return m.re.MatchString(line)
+	case "unit":
+		return inUnit(filepath.Join(procDir, "cgroup"), m.Value)
+	}
+	return false
+}
+
+// $ cat /proc/PID/cgroup
+// 1:name=systemd:/system.slice/nginx.service
+// 0::/system.slice/nginx.service
+func inUnit(path, unit string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		for _, p := range strings.Split(fields[2], "/") {
+			if p == unit {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func normalizeForMetricName(process string) string {
+	// Mackerel accepts following characters in custom metric names
+	// [-a-zA-Z0-9_.]
+	// but "." separates the name from others in wildcard graphs
+	re := regexp.MustCompile("[^-a-zA-Z0-9_]")
+	return re.ReplaceAllString(process, "_")
+}
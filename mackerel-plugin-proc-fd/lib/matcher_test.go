@@ -0,0 +1,167 @@
+package mpprocfd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+type testProc struct {
+	pid     string
+	exe     string
+	comm    string
+	cmdline string
+	cgroup  string
+	limits  string
+	fds     int
+}
+
+var testProcs = []testProc{
+	{
+		pid:     "100",
+		exe:     "/usr/sbin/nginx",
+		comm:    "nginx\n",
+		cmdline: "nginx: master process /usr/sbin/nginx\x00",
+		cgroup:  "1:name=systemd:/system.slice/nginx.service\n",
+		limits:  "Limit                     Soft Limit           Hard Limit           Units\nMax open files            1024                 4096                 files\n",
+		fds:     8,
+	},
+	{
+		pid:     "101",
+		exe:     "/usr/sbin/nginx",
+		comm:    "nginx\n",
+		cmdline: "nginx: worker process\x00",
+		cgroup:  "0::/system.slice/nginx.service\n",
+		limits:  "Limit                     Soft Limit           Hard Limit           Units\nMax open files            unlimited            unlimited            files\n",
+		fds:     12,
+	},
+	{
+		pid:     "200",
+		exe:     "/usr/bin/ruby",
+		comm:    "ruby\n",
+		cmdline: "ruby\x00app.rb\x00--port\x008080\x00",
+		cgroup:  "0::/user.slice/user-1000.slice/session-1.scope\n",
+		fds:     3,
+	},
+	{
+		// the shell which runs the plugin
+		pid:     strconv.Itoa(os.Getppid()),
+		comm:    "sh\n",
+		cmdline: "/bin/sh\x00-c\x00mackerel-plugin-proc-fd -process=nginx\x00",
+	},
+	{
+		pid:     "400",
+		comm:    "vim\n",
+		cmdline: "vim\x00mackerel-plugin-memo.txt\x00",
+	},
+}
+
+func setupProcRoot(t *testing.T) string {
+	root, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range testProcs {
+		dir := filepath.Join(root, p.pid)
+		if err := os.MkdirAll(filepath.Join(dir, "fd"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < p.fds; i++ {
+			ioutil.WriteFile(filepath.Join(dir, "fd", string(rune('a'+i))), nil, 0644)
+		}
+		if p.exe != "" {
+			os.Symlink(p.exe, filepath.Join(dir, "exe"))
+		}
+		ioutil.WriteFile(filepath.Join(dir, "comm"), []byte(p.comm), 0644)
+		ioutil.WriteFile(filepath.Join(dir, "cmdline"), []byte(p.cmdline), 0644)
+		ioutil.WriteFile(filepath.Join(dir, "cgroup"), []byte(p.cgroup), 0644)
+		if p.limits != "" {
+			ioutil.WriteFile(filepath.Join(dir, "limits"), []byte(p.limits), 0644)
+		}
+	}
+	ioutil.WriteFile(filepath.Join(root, "uptime"), []byte("1.00 1.00\n"), 0644)
+	return root
+}
+
+func TestParseMatcher(t *testing.T) {
+	m, err := ParseMatcher("web:unit:nginx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != "web" || m.Type != "unit" || m.Value != "nginx.service" {
+		t.Errorf("ParseMatcher(): unexpected %+v", m)
+	}
+
+	m, err = ParseMatcher("app.rb:cmdline:^ruby app\\.rb --port 80[0-9]+$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != "app_rb" || m.Value != "^ruby app\\.rb --port 80[0-9]+$" {
+		t.Errorf("ParseMatcher(): unexpected %+v", m)
+	}
+
+	for _, s := range []string{"nginx", "nginx:foo:bar", ":comm:nginx", "nginx:comm:("} {
+		if _, err := ParseMatcher(s); err == nil {
+			t.Errorf("ParseMatcher(%q) should be error", s)
+		}
+	}
+}
+
+func TestFindPids(t *testing.T) {
+	root := setupProcRoot(t)
+	defer os.RemoveAll(root)
+
+	pidfile := filepath.Join(root, "app.pid")
+	ioutil.WriteFile(pidfile, []byte("200\n"), 0644)
+
+	cases := []struct {
+		matcher string
+		expect  []string
+	}{
+		{"nginx:exe:/nginx$", []string{"100", "101"}},
+		{"nginx:comm:^nginx$", []string{"100", "101"}},
+		{"nginx:cmdline:nginx", []string{"100", "101"}},
+		{"nginx:cmdline:worker", []string{"101"}},
+		{"nginx:unit:nginx.service", []string{"100", "101"}},
+		{"app:cmdline:app\\.rb --port 8080", []string{"200"}},
+		{"app:pidfile:" + pidfile, []string{"200"}},
+		{"none:comm:^none$", nil},
+		{"vim:cmdline:mackerel-plugin-", []string{"400"}},
+	}
+	for _, c := range cases {
+		m, err := ParseMatcher(c.matcher)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pids, err := m.FindPids(root)
+		if err != nil {
+			t.Errorf("FindPids(%q): %s", c.matcher, err)
+		}
+		sort.Strings(pids)
+		if !reflect.DeepEqual(pids, c.expect) {
+			t.Errorf("FindPids(%q): %v should be %v", c.matcher, pids, c.expect)
+		}
+	}
+}
+
+func TestGetNumOpenFileDesc(t *testing.T) {
+	root := setupProcRoot(t)
+	defer os.RemoveAll(root)
+
+	m, _ := ParseMatcher("nginx:comm:^nginx$")
+	fds, err := RealOpenFd{root}.getNumOpenFileDesc(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]FdStat{
+		"100": {NumFd: 8, Limit: 1024},
+		"101": {NumFd: 12, Limit: 0},
+	}
+	if !reflect.DeepEqual(fds, expect) {
+		t.Errorf("getNumOpenFileDesc(): %v should be %v", fds, expect)
+	}
+}
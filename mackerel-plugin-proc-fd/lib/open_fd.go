@@ -1,59 +1,91 @@
 package mpprocfd
 
 import (
-	"fmt"
+	"bufio"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
-
-	"github.com/mattn/go-pipeline"
 )
 
+// FdStat is the number of open file descriptors and RLIMIT_NOFILE of a process
+type FdStat struct {
+	NumFd uint64
+	// Limit is the soft limit of open files, or 0 if it is unlimited or unknown
+	Limit uint64
+}
+
 // OpenFd interface
 type OpenFd interface {
-	getNumOpenFileDesc() (map[string]uint64, error)
+	getNumOpenFileDesc(m Matcher) (map[string]FdStat, error)
 }
 
 var openFd OpenFd
 
 // RealOpenFd struct
 type RealOpenFd struct {
-	process string
+	procRoot string
 }
 
-func (o RealOpenFd) getNumOpenFileDesc() (map[string]uint64, error) {
-	fds := make(map[string]uint64)
-
-	// Fetch all pids which contain specified process name
-	out, err := pipeline.Output(
-		[]string{"ps", "aux"},
-		[]string{"grep", o.process},
-		[]string{"grep", "-v", "grep"},
-		[]string{"grep", "-v", "mackerel-plugin-proc-fd"},
-		[]string{"awk", "{print $2}"},
-	)
+func (o RealOpenFd) getNumOpenFileDesc(m Matcher) (map[string]FdStat, error) {
+	pids, err := m.FindPids(o.procRoot)
 	if err != nil {
-		// No matching with p.Process invokes this case
 		return nil, err
 	}
 
-	// List the number of all open files beloging to each pid
-	for _, pid := range strings.Split(strings.TrimSpace(string(out)), "\n") {
-		out, err = pipeline.Output(
-			[]string{"ls", "-l", fmt.Sprintf("/proc/%s/fd", pid)},
-			[]string{"grep", "-v", "total"},
-			[]string{"wc", "-l"},
-		)
-		if err != nil {
-			// The process with pid terminates"
-			return nil, err
-		}
-
-		num, err := strconv.ParseUint(strings.TrimSpace(string(out)), 10, 32)
+	fds := make(map[string]FdStat)
+	for _, pid := range pids {
+		procDir := filepath.Join(o.procRoot, pid)
+		num, err := CountFd(procDir)
 		if err != nil {
-			return nil, err
+			if !os.IsNotExist(err) {
+				logger.Warningf("Failed to count fd of pid %s: %s", pid, err)
+			}
+			// The process with pid terminates
+			continue
 		}
-		fds[pid] = num
+		fds[pid] = FdStat{NumFd: num, Limit: openFilesLimit(procDir)}
 	}
 
 	return fds, nil
 }
+
+// CountFd returns the number of entries in procDir/fd
+func CountFd(procDir string) (uint64, error) {
+	dir, err := os.Open(filepath.Join(procDir, "fd"))
+	if err != nil {
+		return 0, err
+	}
+	defer dir.Close()
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(len(names)), nil
+}
+
+// $ cat /proc/PID/limits
+// Limit                     Soft Limit           Hard Limit           Units
+// Max open files            1024                 4096                 files
+func openFilesLimit(procDir string) uint64 {
+	f, err := os.Open(filepath.Join(procDir, "limits"))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Max open files") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "Max open files"))
+		if len(fields) == 0 {
+			return 0
+		}
+		// "unlimited" results in 0
+		limit, _ := strconv.ParseUint(fields[0], 10, 64)
+		return limit
+	}
+	return 0
+}
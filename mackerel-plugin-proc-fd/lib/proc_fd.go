@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"strings"
 
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
 	"github.com/mackerelio/mackerel-agent/logging"
@@ -12,70 +13,156 @@ import (
 
 var logger = logging.GetLogger("metrics.plugin.proc-fd")
 
+type stringSlice []string
+
+func (s *stringSlice) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func (s *stringSlice) String() string {
+	return fmt.Sprintf("%v", *s)
+}
+
 // ProcfdPlugin for fetching metrics
 type ProcfdPlugin struct {
-	Process           string
-	NormalizedProcess string
-	MetricName        string
+	Matchers []Matcher
 }
 
 // FetchMetrics fetch the metrics
 func (p ProcfdPlugin) FetchMetrics() (map[string]interface{}, error) {
-	fds, err := openFd.getNumOpenFileDesc()
-	if err != nil {
-		return nil, err
-	}
-
 	stat := make(map[string]interface{})
 
-	// Compute maximum open file descriptor
-	var maxFD uint64
-	for _, fd := range fds {
-		if fd > maxFD {
-			maxFD = fd
+	for _, m := range p.Matchers {
+		fds, err := openFd.getNumOpenFileDesc(m)
+		if err != nil {
+			logger.Warningf("Failed to fetch fds of %s: %s", m.Name, err)
+			continue
+		}
+		if len(fds) == 0 {
+			continue
+		}
+
+		// Compute maximum open file descriptor, minimum limit and maximum usage of the limit
+		var maxFD, minLimit uint64
+		var maxUsage float64
+		for _, fd := range fds {
+			if fd.NumFd > maxFD {
+				maxFD = fd.NumFd
+			}
+			if fd.Limit == 0 {
+				continue
+			}
+			if minLimit == 0 || fd.Limit < minLimit {
+				minLimit = fd.Limit
+			}
+			if usage := float64(fd.NumFd) * 100 / float64(fd.Limit); usage > maxUsage {
+				maxUsage = usage
+			}
+		}
+		stat[metricKey(m, "proc-fd", "max_fd")] = maxFD
+		if minLimit > 0 {
+			stat[metricKey(m, "proc-fd", "min_limit")] = minLimit
+			stat[metricKey(m, "proc-fd-usage", "max_percentage")] = maxUsage
 		}
 	}
-	stat["max_fd"] = maxFD
 
 	return stat, nil
 }
 
+// metricKey returns the key of the metric of the matcher in the graph.
+// The name of -process may contain "." as before, which "#" of the graphs cannot match,
+// so it has its own graphs whose metrics are keyed by the bare names.
+func metricKey(m Matcher, graph, metric string) string {
+	if strings.Contains(m.Name, ".") {
+		return metric
+	}
+	return graph + "." + m.Name + "." + metric
+}
+
 // GraphDefinition Graph definition
 func (p ProcfdPlugin) GraphDefinition() map[string]mp.Graphs {
-	return map[string]mp.Graphs{
-		fmt.Sprintf("proc-fd.%s", p.NormalizedProcess): {
-			Label: fmt.Sprintf("Opening fd by %s", p.NormalizedProcess),
+	graphs := map[string]mp.Graphs{
+		"proc-fd.#": {
+			Label: "Opening fd",
 			Unit:  "integer",
 			Metrics: []mp.Metrics{
 				{Name: "max_fd", Label: "Maximum opening fd", Diff: false, Type: "uint64"},
+				{Name: "min_limit", Label: "Minimum fd limit", Diff: false, Type: "uint64"},
+			},
+		},
+		"proc-fd-usage.#": {
+			Label: "Opening fd usage of limit",
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				{Name: "max_percentage", Label: "Maximum usage", Diff: false},
 			},
 		},
 	}
+	for _, m := range p.Matchers {
+		if !strings.Contains(m.Name, ".") {
+			continue
+		}
+		graphs["proc-fd."+m.Name] = mp.Graphs{
+			Label: fmt.Sprintf("Opening fd by %s", m.Name),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "max_fd", Label: "Maximum opening fd", Diff: false, Type: "uint64"},
+				{Name: "min_limit", Label: "Minimum fd limit", Diff: false, Type: "uint64"},
+			},
+		}
+		graphs["proc-fd-usage."+m.Name] = mp.Graphs{
+			Label: fmt.Sprintf("Opening fd usage of limit by %s", m.Name),
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				{Name: "max_percentage", Label: "Maximum usage", Diff: false},
+			},
+		}
+	}
+	return graphs
 }
 
-func normalizeForMetricName(process string) string {
-	// Mackerel accepts following characters in custom metric names
-	// [-a-zA-Z0-9_.]
+// normalizeProcess normalizes the name of -process as before matchers, keeping "."
+// (e.g. "php-fpm7.0") so that the metrics of existing users are not renamed.
+func normalizeProcess(process string) string {
 	re := regexp.MustCompile("[^-a-zA-Z0-9_.]")
 	return re.ReplaceAllString(process, "_")
 }
 
 // Do the plugin
 func Do() {
-	optProcess := flag.String("process", "", "Process name")
+	optProcess := flag.String("process", "", "Process name (regexp for the command line)")
+	optMatchers := &stringSlice{}
+	flag.Var(optMatchers, "matcher", "Process matcher <name>:<type>:<value> (type: exe, comm, cmdline, pidfile or unit)")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
 	flag.Parse()
 
-	if *optProcess == "" {
-		logger.Warningf("Process name is required")
+	var fd ProcfdPlugin
+	if *optProcess != "" {
+		m, err := NewMatcher(*optProcess, "cmdline", *optProcess)
+		if err != nil {
+			logger.Errorf("Invalid process: %s", err)
+			os.Exit(1)
+		}
+		m.Name = normalizeProcess(*optProcess)
+		fd.Matchers = append(fd.Matchers, m)
+	}
+	for _, s := range *optMatchers {
+		m, err := ParseMatcher(s)
+		if err != nil {
+			logger.Errorf("Invalid matcher: %s", err)
+			os.Exit(1)
+		}
+		fd.Matchers = append(fd.Matchers, m)
+	}
+
+	if len(fd.Matchers) == 0 {
+		logger.Warningf("Process name or matcher is required")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	var fd ProcfdPlugin
-	fd.Process = *optProcess
-	openFd = RealOpenFd{fd.Process}
-	fd.NormalizedProcess = normalizeForMetricName(*optProcess)
+	openFd = RealOpenFd{"/proc"}
 
 	helper := mp.NewMackerelPlugin(fd)
 	if *optTempfile != "" {
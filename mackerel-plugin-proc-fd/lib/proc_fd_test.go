@@ -6,27 +6,65 @@ func TestGraphDefinition(t *testing.T) {
 	var fd ProcfdPlugin
 
 	graph := fd.GraphDefinition()
-	if actual := len(graph); actual != 1 {
-		t.Errorf("GraphDefinition(): %d should be 1", actual)
+	if actual := len(graph); actual != 2 {
+		t.Errorf("GraphDefinition(): %d should be 2", actual)
 	}
 }
 
 type TestOpenFd struct{}
 
-func (o TestOpenFd) getNumOpenFileDesc() (map[string]uint64, error) {
-	return map[string]uint64{
-		"8273": 90,
-		"8274": 100,
-		"8275": 95,
+func (o TestOpenFd) getNumOpenFileDesc(m Matcher) (map[string]FdStat, error) {
+	if m.Name != "nginx" && m.Name != "php-fpm7.0" {
+		return map[string]FdStat{}, nil
+	}
+	return map[string]FdStat{
+		"8273": {NumFd: 90, Limit: 1024},
+		"8274": {NumFd: 100, Limit: 4096},
+		"8275": {NumFd: 95, Limit: 512},
 	}, nil
 }
 
 func TestFetchMetrics(t *testing.T) {
 	openFd = TestOpenFd{}
-	var fd ProcfdPlugin
+	fd := ProcfdPlugin{
+		Matchers: []Matcher{{Name: "nginx"}, {Name: "none"}},
+	}
 	stat, _ := fd.FetchMetrics()
 
+	if actual := stat["proc-fd.nginx.max_fd"].(uint64); actual != 100 {
+		t.Errorf("FetchMetrics(): max_fd(%d) should be 100", actual)
+	}
+	if actual := stat["proc-fd.nginx.min_limit"].(uint64); actual != 512 {
+		t.Errorf("FetchMetrics(): min_limit(%d) should be 512", actual)
+	}
+	if actual := stat["proc-fd-usage.nginx.max_percentage"].(float64); actual < 18.55 || actual > 18.56 {
+		t.Errorf("FetchMetrics(): max_percentage(%f) should be 18.55", actual)
+	}
+	if _, ok := stat["proc-fd.none.max_fd"]; ok {
+		t.Errorf("FetchMetrics(): max_fd of none should not be posted")
+	}
+}
+
+func TestFetchMetricsLegacyProcess(t *testing.T) {
+	openFd = TestOpenFd{}
+	// -process=php-fpm7.0 posts proc-fd.php-fpm7.0.max_fd as before
+	fd := ProcfdPlugin{
+		Matchers: []Matcher{{Name: normalizeProcess("php-fpm7.0")}},
+	}
+	stat, _ := fd.FetchMetrics()
 	if actual := stat["max_fd"].(uint64); actual != 100 {
 		t.Errorf("FetchMetrics(): max_fd(%d) should be 100", actual)
 	}
+
+	graph := fd.GraphDefinition()
+	g, ok := graph["proc-fd.php-fpm7.0"]
+	if !ok {
+		t.Fatalf("GraphDefinition(): proc-fd.php-fpm7.0 should be defined")
+	}
+	if g.Metrics[0].Name != "max_fd" {
+		t.Errorf("GraphDefinition(): %s should be max_fd", g.Metrics[0].Name)
+	}
+	if _, ok := graph["proc-fd-usage.php-fpm7.0"]; !ok {
+		t.Errorf("GraphDefinition(): proc-fd-usage.php-fpm7.0 should be defined")
+	}
 }
@@ -0,0 +1,182 @@
+package mpprocgroup
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+	"github.com/mackerelio/mackerel-agent-plugins/mackerel-plugin-proc-fd/lib"
+	"github.com/mackerelio/mackerel-agent/logging"
+)
+
+var logger = logging.GetLogger("metrics.plugin.proc-group")
+
+type stringSlice []string
+
+func (s *stringSlice) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func (s *stringSlice) String() string {
+	return fmt.Sprintf("%v", *s)
+}
+
+// metricKeys maps counters of readProcess to "<graph>.<metric>"
+var metricKeys = map[string][2]string{
+	"threads":                    {"processes", "threads"},
+	"rss":                        {"memory", "rss"},
+	"pss":                        {"memory", "pss"},
+	"utime":                      {"cpu", "user"},
+	"stime":                      {"cpu", "system"},
+	"fd":                         {"fd", "open"},
+	"read_bytes":                 {"io", "read"},
+	"write_bytes":                {"io", "write"},
+	"voluntary_ctxt_switches":    {"context_switches", "voluntary"},
+	"nonvoluntary_ctxt_switches": {"context_switches", "nonvoluntary"},
+}
+
+// ProcGroupPlugin mackerel plugin for groups of processes
+type ProcGroupPlugin struct {
+	Prefix   string
+	ProcRoot string
+	Matchers []mpprocfd.Matcher
+}
+
+// MetricKeyPrefix interface for PluginWithPrefix
+func (p ProcGroupPlugin) MetricKeyPrefix() string {
+	if p.Prefix == "" {
+		p.Prefix = "proc-group"
+	}
+	return p.Prefix
+}
+
+// FetchMetrics interface for mackerelplugin
+func (p ProcGroupPlugin) FetchMetrics() (map[string]interface{}, error) {
+	stat := make(map[string]interface{})
+
+	for _, m := range p.Matchers {
+		pids, err := m.FindPids(p.ProcRoot)
+		if err != nil {
+			logger.Warningf("Failed to find processes of %s: %s", m.Name, err)
+			continue
+		}
+
+		var count uint64
+		sum := make(map[string]uint64)
+		for _, pid := range pids {
+			values, err := readProcess(filepath.Join(p.ProcRoot, pid))
+			if err != nil {
+				// The process with pid terminates
+				continue
+			}
+			count++
+			for k, v := range values {
+				sum[k] += v
+			}
+		}
+
+		stat["processes."+m.Name+".count"] = count
+		for k, v := range sum {
+			key := metricKeys[k]
+			// a decrease caused by terminated processes is regarded as a reset,
+			// since Type of the graphs is not set
+			stat[key[0]+"."+m.Name+"."+key[1]] = float64(v)
+		}
+	}
+
+	return stat, nil
+}
+
+// GraphDefinition interface for mackerelplugin
+func (p ProcGroupPlugin) GraphDefinition() map[string]mp.Graphs {
+	labelPrefix := strings.Title(strings.Replace(p.MetricKeyPrefix(), "-", " ", -1))
+	return map[string]mp.Graphs{
+		"processes.#": {
+			Label: labelPrefix + " Processes",
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "count", Label: "Processes"},
+				{Name: "threads", Label: "Threads"},
+			},
+		},
+		"memory.#": {
+			Label: labelPrefix + " Memory",
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "rss", Label: "RSS"},
+				{Name: "pss", Label: "PSS"},
+			},
+		},
+		// utime and stime are in USER_HZ (1/100 sec), so the per-minute diff / 60 is percentage
+		"cpu.#": {
+			Label: labelPrefix + " CPU",
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				{Name: "user", Label: "User", Diff: true, Stacked: true, Scale: (1.0 / 60)},
+				{Name: "system", Label: "System", Diff: true, Stacked: true, Scale: (1.0 / 60)},
+			},
+		},
+		"fd.#": {
+			Label: labelPrefix + " Open fd",
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "open", Label: "Open fd"},
+			},
+		},
+		"io.#": {
+			Label: labelPrefix + " IO",
+			Unit:  "bytes/sec",
+			Metrics: []mp.Metrics{
+				{Name: "read", Label: "Read", Diff: true, Scale: (1.0 / 60)},
+				{Name: "write", Label: "Write", Diff: true, Scale: (1.0 / 60)},
+			},
+		},
+		"context_switches.#": {
+			Label: labelPrefix + " Context Switches",
+			Unit:  "float",
+			Metrics: []mp.Metrics{
+				{Name: "voluntary", Label: "Voluntary", Diff: true, Stacked: true, Scale: (1.0 / 60)},
+				{Name: "nonvoluntary", Label: "Nonvoluntary", Diff: true, Stacked: true, Scale: (1.0 / 60)},
+			},
+		},
+	}
+}
+
+// Do the plugin
+func Do() {
+	optMatchers := &stringSlice{}
+	flag.Var(optMatchers, "matcher", "Process matcher <name>:<type>:<value> (type: exe, comm, cmdline, pidfile or unit)")
+	optPrefix := flag.String("metric-key-prefix", "proc-group", "Metric key prefix")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	flag.Parse()
+
+	p := ProcGroupPlugin{
+		Prefix:   *optPrefix,
+		ProcRoot: "/proc",
+	}
+	for _, s := range *optMatchers {
+		m, err := mpprocfd.ParseMatcher(s)
+		if err != nil {
+			logger.Errorf("Invalid matcher: %s", err)
+			os.Exit(1)
+		}
+		p.Matchers = append(p.Matchers, m)
+	}
+	if len(p.Matchers) == 0 {
+		logger.Warningf("At least one matcher is required")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	helper := mp.NewMackerelPlugin(p)
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = fmt.Sprintf("/tmp/mackerel-plugin-%s", *optPrefix)
+	}
+	helper.Run()
+}
@@ -0,0 +1,78 @@
+package mpprocgroup
+
+import (
+	"testing"
+
+	"github.com/mackerelio/mackerel-agent-plugins/mackerel-plugin-proc-fd/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphDefinition(t *testing.T) {
+	var p ProcGroupPlugin
+
+	graphdef := p.GraphDefinition()
+	if len(graphdef) != 6 {
+		t.Errorf("GetTempfilename: %d should be 6", len(graphdef))
+	}
+}
+
+func TestReadProcess(t *testing.T) {
+	values, err := readProcess("./sample/proc/1201")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]uint64{
+		"utime":                      3021,
+		"stime":                      877,
+		"threads":                    1,
+		"rss":                        14084 * 1024,
+		"pss":                        8260 * 1024,
+		"voluntary_ctxt_switches":    982311,
+		"nonvoluntary_ctxt_switches": 3318,
+		"read_bytes":                 1228800,
+		"write_bytes":                91234304,
+		"fd":                         9,
+	}, values)
+
+	// smaps is used without smaps_rollup, and io is unreadable
+	values, err = readProcess("./sample/proc/1300")
+	assert.Nil(t, err)
+	assert.EqualValues(t, 65536*1024, values["pss"])
+	_, ok := values["read_bytes"]
+	assert.False(t, ok)
+
+	_, err = readProcess("./sample/proc/9999")
+	assert.NotNil(t, err)
+}
+
+func TestFetchMetrics(t *testing.T) {
+	nginx, _ := mpprocfd.ParseMatcher("nginx:comm:^nginx$")
+	memcached, _ := mpprocfd.ParseMatcher("memcached:exe:memcached")
+	cache, _ := mpprocfd.ParseMatcher("cache:cmdline:^/usr/bin/memcached -m 64")
+	p := ProcGroupPlugin{
+		ProcRoot: "./sample/proc",
+		Matchers: []mpprocfd.Matcher{nginx, memcached, cache},
+	}
+
+	stat, err := p.FetchMetrics()
+	assert.Nil(t, err)
+
+	assert.EqualValues(t, 2, stat["processes.nginx.count"])
+	assert.EqualValues(t, 2, stat["processes.nginx.threads"])
+	assert.EqualValues(t, (8944+14084)*1024, stat["memory.nginx.rss"])
+	assert.EqualValues(t, (3120+8260)*1024, stat["memory.nginx.pss"])
+	assert.EqualValues(t, 3141, stat["cpu.nginx.user"])
+	assert.EqualValues(t, 922, stat["cpu.nginx.system"])
+	assert.EqualValues(t, 15, stat["fd.nginx.open"])
+	assert.EqualValues(t, 1232896, stat["io.nginx.read"])
+	assert.EqualValues(t, 91242496, stat["io.nginx.write"])
+	assert.EqualValues(t, 987332, stat["context_switches.nginx.voluntary"])
+
+	// exe cannot be read in the sample
+	assert.EqualValues(t, 0, stat["processes.memcached.count"])
+	_, ok := stat["memory.memcached.rss"]
+	assert.False(t, ok)
+
+	assert.EqualValues(t, 1, stat["processes.cache.count"])
+	assert.EqualValues(t, 10, stat["processes.cache.threads"])
+	_, ok = stat["io.cache.read"]
+	assert.False(t, ok)
+}
@@ -0,0 +1,144 @@
+package mpprocgroup
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mackerelio/mackerel-agent-plugins/mackerel-plugin-proc-fd/lib"
+)
+
+// readProcess returns counters of a process keyed by
+// utime, stime, threads, rss, pss, voluntary_ctxt_switches, nonvoluntary_ctxt_switches,
+// read_bytes, write_bytes and fd. Values which cannot be read (e.g. /proc/PID/io of
+// other users' processes) are left out.
+func readProcess(procDir string) (map[string]uint64, error) {
+	// the process is regarded as terminated if stat cannot be read
+	p, err := readStat(procDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if status, err := readKeyValues(filepath.Join(procDir, "status")); err == nil {
+		for _, k := range []string{"Threads", "voluntary_ctxt_switches", "nonvoluntary_ctxt_switches"} {
+			if v, ok := status[k]; ok {
+				p[strings.ToLower(k)] = v
+			}
+		}
+		if v, ok := status["VmRSS"]; ok {
+			p["rss"] = v * 1024
+		}
+	}
+
+	if pss, err := readPss(procDir); err == nil {
+		p["pss"] = pss
+	}
+
+	if io, err := readKeyValues(filepath.Join(procDir, "io")); err == nil {
+		for _, k := range []string{"read_bytes", "write_bytes"} {
+			if v, ok := io[k]; ok {
+				p[k] = v
+			}
+		}
+	}
+
+	if fd, err := mpprocfd.CountFd(procDir); err == nil {
+		p["fd"] = fd
+	}
+
+	return p, nil
+}
+
+//	$ cat /proc/PID/stat
+//	1200 (nginx) S 1 1200 1200 0 -1 1077936448 3398 0 5 0 120 45 0 0 20 0 1 0 ...
+//
+// utime and stime are the 14th and 15th fields. The command name may contain spaces.
+func readStat(procDir string) (map[string]uint64, error) {
+	content, err := ioutil.ReadFile(filepath.Join(procDir, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	i := strings.LastIndex(string(content), ")")
+	if i < 0 {
+		return nil, fmt.Errorf("invalid stat: %s", procDir)
+	}
+	// fields after the command name start from the 3rd field (state)
+	fields := strings.Fields(string(content[i+1:]))
+	if len(fields) < 13 {
+		return nil, fmt.Errorf("invalid stat: %s", procDir)
+	}
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]uint64{"utime": utime, "stime": stime}, nil
+}
+
+// readPss reads smaps_rollup (Linux 4.14+) or sums up Pss in smaps.
+func readPss(procDir string) (uint64, error) {
+	f, err := os.Open(filepath.Join(procDir, "smaps_rollup"))
+	if err != nil {
+		f, err = os.Open(filepath.Join(procDir, "smaps"))
+		if err != nil {
+			return 0, err
+		}
+	}
+	defer f.Close()
+
+	var pss uint64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "Pss:" {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		pss += v * 1024
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return pss, nil
+}
+
+// readKeyValues reads lines like "VmRSS:	8944 kB" into a map. Units are dropped.
+func readKeyValues(path string) (map[string]uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		fields := strings.Fields(kv[1])
+		if len(fields) == 0 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		values[strings.TrimSpace(kv[0])] = v
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
@@ -0,0 +1,60 @@
+package mpprometheus
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Rule maps the families whose names match Match to a graph.
+// Graph can refer to the groups of Match as $1, and Labels selects the labels
+// (in order) which identify the series, instead of all the labels.
+type Rule struct {
+	Match  string   `toml:"match"`
+	Graph  string   `toml:"graph"`
+	Label  string   `toml:"label"`
+	Unit   string   `toml:"unit"`
+	Labels []string `toml:"labels"`
+	re     *regexp.Regexp
+}
+
+// Config is the configuration file of the rules
+type Config struct {
+	Rules []Rule `toml:"rules"`
+}
+
+var validUnits = map[string]bool{
+	"float":        true,
+	"integer":      true,
+	"percentage":   true,
+	"seconds":      true,
+	"milliseconds": true,
+	"bytes":        true,
+	"bytes/sec":    true,
+	"bits/sec":     true,
+	"iops":         true,
+}
+
+// LoadConfig reads the rules in TOML
+func LoadConfig(file string) ([]Rule, error) {
+	var c Config
+	if _, err := toml.DecodeFile(file, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", file, err)
+	}
+	for i := range c.Rules {
+		r := &c.Rules[i]
+		re, err := regexp.Compile(r.Match)
+		if err != nil {
+			return nil, fmt.Errorf("invalid match of rules[%d]: %s", i, err)
+		}
+		r.re = re
+		if r.Graph == "" {
+			return nil, fmt.Errorf("graph of rules[%d] is required", i)
+		}
+		if r.Unit != "" && !validUnits[r.Unit] {
+			return nil, fmt.Errorf("unknown unit of rules[%d]: %s", i, r.Unit)
+		}
+	}
+	return c.Rules, nil
+}
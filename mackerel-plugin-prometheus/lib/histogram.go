@@ -0,0 +1,132 @@
+package mpprometheus
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type bucket struct {
+	le    float64
+	count float64
+}
+
+type byUpperBound []bucket
+
+func (b byUpperBound) Len() int           { return len(b) }
+func (b byUpperBound) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byUpperBound) Less(i, j int) bool { return b[i].le < b[j].le }
+
+// histogramQuantile estimates the q-quantile from the cumulative buckets by linear interpolation
+// within the bucket, as histogram_quantile() of Prometheus does.
+// It returns false if there are no observations or no +Inf bucket.
+func histogramQuantile(q float64, buckets []bucket) (float64, bool) {
+	sort.Sort(byUpperBound(buckets))
+	if len(buckets) < 2 || !math.IsInf(buckets[len(buckets)-1].le, 1) {
+		return 0, false
+	}
+	total := buckets[len(buckets)-1].count
+	if total <= 0 {
+		return 0, false
+	}
+
+	rank := q * total
+	i := sort.Search(len(buckets)-1, func(i int) bool { return buckets[i].count >= rank })
+	if i == len(buckets)-1 {
+		// in the +Inf bucket, the upper bound of the highest finite bucket is the best estimate
+		return buckets[len(buckets)-2].le, true
+	}
+	if i == 0 && buckets[0].le <= 0 {
+		return buckets[0].le, true
+	}
+
+	lower, lowerCount := 0.0, 0.0
+	if i > 0 {
+		lower, lowerCount = buckets[i-1].le, buckets[i-1].count
+	}
+	upper, count := buckets[i].le, buckets[i].count-lowerCount
+	if count <= 0 {
+		return upper, true
+	}
+	return lower + (upper-lower)*(rank-lowerCount)/count, true
+}
+
+// quantileName converts 0.5 to "p50" and 0.999 to "p99_9"
+func quantileName(q float64) string {
+	percentile := math.Floor(q*1e6+0.5) / 1e4
+	return "p" + strings.Replace(strconv.FormatFloat(percentile, 'f', -1, 64), ".", "_", -1)
+}
+
+// bucketDelta returns the increase of the buckets since the previous counts.
+// It returns false at the first run of the series, and the current counts if the counter was reset.
+func bucketDelta(key string, buckets []bucket, prev map[string]float64) ([]bucket, bool) {
+	delta := make([]bucket, len(buckets))
+	reset := false
+	for i, b := range buckets {
+		p, ok := prev[bucketKey(key, b.le)]
+		if !ok {
+			return nil, false
+		}
+		if b.count < p {
+			reset = true
+		}
+		delta[i] = bucket{le: b.le, count: b.count - p}
+	}
+	if reset {
+		return buckets, true
+	}
+	return delta, true
+}
+
+func bucketKey(key string, le float64) string {
+	return key + "\t" + strconv.FormatFloat(le, 'g', -1, 64)
+}
+
+// loadBuckets reads the bucket counts of the previous run
+func loadBuckets(file string) map[string]float64 {
+	buckets := make(map[string]float64)
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return buckets
+	}
+	if err := json.Unmarshal(content, &buckets); err != nil {
+		logger.Warningf("Failed to read %s: %s", file, err)
+	}
+	return buckets
+}
+
+func saveBuckets(file string, buckets map[string]float64) error {
+	content, err := json.Marshal(buckets)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(file, content)
+}
+
+// writeFileAtomic writes the content to a temporary file and renames it,
+// so that an interrupted run does not leave a truncated file
+func writeFileAtomic(file string, content []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file))
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), file)
+}
@@ -0,0 +1,177 @@
+package mpprometheus
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// sample is a line of the text exposition format
+type sample struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+// family is a metric family, with the samples of _bucket, _sum and _count for histograms and summaries
+type family struct {
+	name    string
+	typ     string
+	samples []sample
+}
+
+// parseText parses the Prometheus text exposition format (version 0.0.4), and returns the families in order.
+// The samples without TYPE are in the families of "untyped".
+func parseText(r io.Reader) ([]*family, error) {
+	var families []*family
+	byName := make(map[string]*family)
+	add := func(name, typ string) *family {
+		f := &family{name: name, typ: typ}
+		families = append(families, f)
+		byName[name] = f
+		return f
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			fields := strings.Fields(line)
+			if len(fields) >= 4 && fields[1] == "TYPE" {
+				if f, ok := byName[fields[2]]; ok {
+					f.typ = fields[3]
+				} else {
+					add(fields[2], fields[3])
+				}
+			}
+			continue
+		}
+
+		s, err := parseSample(line)
+		if err != nil {
+			return nil, err
+		}
+		f := familyOf(byName, s.name)
+		if f == nil {
+			f = add(s.name, "untyped")
+		}
+		f.samples = append(f.samples, s)
+	}
+	return families, scanner.Err()
+}
+
+// familyOf finds the family of a sample by the suffixes of histograms, summaries and counters of OpenMetrics
+func familyOf(byName map[string]*family, name string) *family {
+	if f, ok := byName[name]; ok {
+		return f
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count", "_total", "_created"} {
+		if !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		f, ok := byName[strings.TrimSuffix(name, suffix)]
+		if !ok {
+			continue
+		}
+		switch {
+		case f.typ == "histogram" && suffix != "_total",
+			f.typ == "summary" && suffix != "_bucket" && suffix != "_total",
+			f.typ == "counter" && (suffix == "_total" || suffix == "_created"):
+			return f
+		}
+	}
+	// the families of counters are named with _total in the text format, e.g. foo_created of foo_total
+	if strings.HasSuffix(name, "_created") {
+		if f, ok := byName[strings.TrimSuffix(name, "_created")+"_total"]; ok && f.typ == "counter" {
+			return f
+		}
+	}
+	return nil
+}
+
+// parseSample parses `name{label="value",...} value [timestamp]`
+func parseSample(line string) (sample, error) {
+	s := sample{labels: make(map[string]string)}
+	i := strings.IndexAny(line, "{ \t")
+	if i <= 0 {
+		return s, fmt.Errorf("invalid sample: %s", line)
+	}
+	s.name = line[:i]
+	rest := line[i:]
+
+	if rest[0] == '{' {
+		n, err := parseLabels(rest, s.labels)
+		if err != nil {
+			return s, fmt.Errorf("invalid labels of %s: %s", s.name, err)
+		}
+		rest = rest[n:]
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return s, fmt.Errorf("no value of %s", s.name)
+	}
+	v, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return s, fmt.Errorf("invalid value of %s: %s", s.name, err)
+	}
+	s.value = v
+	return s, nil
+}
+
+// parseLabels parses the labels in braces at the beginning of s, and returns the length of the labels
+func parseLabels(s string, labels map[string]string) (int, error) {
+	i := 1
+	for {
+		for i < len(s) && (s[i] == ' ' || s[i] == ',') {
+			i++
+		}
+		if i >= len(s) {
+			return 0, fmt.Errorf("unterminated labels")
+		}
+		if s[i] == '}' {
+			return i + 1, nil
+		}
+
+		eq := strings.IndexByte(s[i:], '=')
+		if eq < 0 {
+			return 0, fmt.Errorf("no value of label")
+		}
+		name := strings.TrimSpace(s[i : i+eq])
+		i += eq + 1
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+		if i >= len(s) || s[i] != '"' {
+			return 0, fmt.Errorf("label %s is not quoted", name)
+		}
+		i++
+
+		var value bytes.Buffer
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+				switch s[i] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(s[i])
+				}
+				continue
+			}
+			value.WriteByte(s[i])
+		}
+		if i >= len(s) {
+			return 0, fmt.Errorf("unterminated value of label %s", name)
+		}
+		labels[name] = value.String()
+		i++
+	}
+}
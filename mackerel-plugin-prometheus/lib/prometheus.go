@@ -0,0 +1,437 @@
+package mpprometheus
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+	"github.com/mackerelio/mackerel-agent/logging"
+)
+
+var logger = logging.GetLogger("metrics.plugin.prometheus")
+
+// PrometheusPlugin mackerel plugin for Prometheus exposition format
+type PrometheusPlugin struct {
+	URL     string
+	Prefix  string
+	Include *regexp.Regexp
+	Exclude *regexp.Regexp
+	Rules   []Rule
+	// MaxSeries is the limit of the number of the values posted in a run
+	MaxSeries int
+	// Quantiles are calculated from the buckets of histograms
+	Quantiles []float64
+	// StateFile keeps the buckets of histograms to calculate the quantiles of the observations
+	// since the previous run. The quantiles since the start of the process are calculated if empty.
+	StateFile string
+
+	// the result of FetchMetrics, which GraphDefinition reuses in the same run
+	collected *collected
+}
+
+// MetricKeyPrefix interface for PluginWithPrefix
+func (p PrometheusPlugin) MetricKeyPrefix() string {
+	if p.Prefix == "" {
+		p.Prefix = "prometheus"
+	}
+	return p.Prefix
+}
+
+func (p PrometheusPlugin) scrape() ([]*family, error) {
+	req, err := http.NewRequest("GET", p.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	// the text format, not the protocol buffer
+	req.Header.Set("Accept", "text/plain;version=0.0.4;q=1,*/*;q=0.1")
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", p.URL, resp.Status)
+	}
+	return parseText(resp.Body)
+}
+
+// FetchMetrics interface for mackerelplugin
+func (p *PrometheusPlugin) FetchMetrics() (map[string]interface{}, error) {
+	families, err := p.scrape()
+	if err != nil {
+		return nil, err
+	}
+
+	var prev map[string]float64
+	if p.StateFile != "" {
+		prev = loadBuckets(p.StateFile)
+	}
+	c := p.collect(families, prev)
+	if p.StateFile != "" {
+		if err := saveBuckets(p.StateFile, c.buckets); err != nil {
+			logger.Warningf("Failed to save %s: %s", p.StateFile, err)
+		}
+	}
+	if c.dropped > 0 {
+		logger.Warningf("%d series are dropped over -max-series %d", c.dropped, p.MaxSeries)
+	}
+	p.collected = c
+	return c.values, nil
+}
+
+// GraphDefinition interface for mackerelplugin
+// The graphs are built from the scrape of FetchMetrics if it has been called, so that they agree with the values.
+func (p *PrometheusPlugin) GraphDefinition() map[string]mp.Graphs {
+	c := p.collected
+	if c == nil {
+		families, err := p.scrape()
+		if err != nil {
+			logger.Errorf("Failed to scrape %s: %s", p.URL, err)
+			return map[string]mp.Graphs{}
+		}
+		c = p.collect(families, nil)
+	}
+	return c.definitions()
+}
+
+// collected is the graphs and the values converted from the families.
+// used is the graphs which have values under the limit of the series.
+type collected struct {
+	graphs  map[string]mp.Graphs
+	used    map[string]bool
+	values  map[string]interface{}
+	buckets map[string]float64
+	dropped int
+	max     int
+}
+
+// definitions returns the graphs except those whose series are all dropped
+func (c *collected) definitions() map[string]mp.Graphs {
+	graphs := make(map[string]mp.Graphs)
+	for name, g := range c.graphs {
+		if c.used[name] {
+			graphs[name] = g
+		}
+	}
+	return graphs
+}
+
+// add adds a value to the series of the graph, unless it is over the limit.
+// The samples which have the same series name after the selection of the labels are summed.
+// NaN and infinity are not posted.
+func (c *collected) add(graph, series string, v float64) {
+	c.merge(graph, series, v, func(a, b float64) float64 { return a + b })
+}
+
+// merge sets a value of the series of the graph, combining it with the existing value by fn
+func (c *collected) merge(graph, series string, v float64, fn func(a, b float64) float64) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return
+	}
+	key := graph + "." + series
+	if prev, ok := c.values[key]; ok {
+		v = fn(prev.(float64), v)
+	} else if c.max > 0 && len(c.values) >= c.max {
+		c.dropped++
+		return
+	}
+	c.values[key] = v
+	c.used[graph] = true
+}
+
+// target is a graph which a family is mapped to
+type target struct {
+	graph  string
+	label  string
+	unit   string
+	labels []string
+}
+
+var invalidChars = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+func sanitize(s string) string {
+	return invalidChars.ReplaceAllString(s, "_")
+}
+
+// sanitizeGraph sanitizes each part of a graph name separated by dots
+func sanitizeGraph(s string) string {
+	parts := strings.Split(s, ".")
+	for i, part := range parts {
+		parts[i] = sanitize(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+// defaultUnit guesses the unit by the base unit in the name of the family
+func defaultUnit(name string) string {
+	name = strings.TrimSuffix(name, "_total")
+	switch {
+	case strings.HasSuffix(name, "_bytes"):
+		return "bytes"
+	case strings.HasSuffix(name, "_seconds"):
+		return "seconds"
+	}
+	return "float"
+}
+
+// target maps the family to a graph by the first matching rule
+func (p PrometheusPlugin) target(f *family) target {
+	t := target{graph: sanitize(f.name), label: f.name, unit: defaultUnit(f.name)}
+	for _, r := range p.Rules {
+		m := r.re.FindStringSubmatchIndex(f.name)
+		if m == nil {
+			continue
+		}
+		t.graph = sanitizeGraph(string(r.re.ExpandString(nil, r.Graph, f.name, m)))
+		if r.Label != "" {
+			t.label = r.Label
+		}
+		if r.Unit != "" {
+			t.unit = r.Unit
+		}
+		t.labels = r.Labels
+		break
+	}
+	return t
+}
+
+// seriesName joins the values of the labels (all the labels except le and quantile in order of the names
+// if names is empty), or "value" if there is no label.
+func seriesName(labels map[string]string, names []string) string {
+	if len(names) == 0 {
+		for name := range labels {
+			if name != "le" && name != "quantile" {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+	}
+	var parts []string
+	for _, name := range names {
+		parts = append(parts, sanitize(labels[name]))
+	}
+	if s := strings.Join(parts, "_"); s != "" {
+		return s
+	}
+	return "value"
+}
+
+func (p PrometheusPlugin) collect(families []*family, prev map[string]float64) *collected {
+	c := &collected{
+		graphs:  make(map[string]mp.Graphs),
+		used:    make(map[string]bool),
+		values:  make(map[string]interface{}),
+		buckets: make(map[string]float64),
+		max:     p.MaxSeries,
+	}
+	sort.Sort(byName(families))
+
+	for _, f := range families {
+		if p.Include != nil && !p.Include.MatchString(f.name) {
+			continue
+		}
+		if p.Exclude != nil && p.Exclude.MatchString(f.name) {
+			continue
+		}
+		if len(f.samples) == 0 {
+			continue
+		}
+		t := p.target(f)
+		if _, ok := c.graphs[t.graph]; ok {
+			logger.Warningf("%s is skipped since graph %s is already used", f.name, t.graph)
+			continue
+		}
+		if _, ok := c.graphs[t.graph+".count"]; ok {
+			logger.Warningf("%s is skipped since graph %s is already used", f.name, t.graph)
+			continue
+		}
+
+		switch f.typ {
+		case "histogram", "summary":
+			p.collectDistribution(c, f, t, prev)
+		default:
+			c.graphs[t.graph] = mp.Graphs{
+				Label: t.label,
+				Unit:  t.unit,
+				Metrics: []mp.Metrics{
+					{Name: "*", Label: "%1", Diff: f.typ == "counter"},
+				},
+			}
+			for _, s := range f.samples {
+				if strings.HasSuffix(s.name, "_created") {
+					continue
+				}
+				c.add(t.graph, seriesName(s.labels, t.labels), s.value)
+			}
+		}
+	}
+	return c
+}
+
+// collectDistribution posts the count and the sum as counters, and the quantiles of a histogram or a summary
+func (p PrometheusPlugin) collectDistribution(c *collected, f *family, t target, prev map[string]float64) {
+	c.graphs[t.graph+".count"] = mp.Graphs{
+		Label:   t.label + " Count",
+		Unit:    "integer",
+		Metrics: []mp.Metrics{{Name: "*", Label: "%1", Diff: true}},
+	}
+	c.graphs[t.graph+".sum"] = mp.Graphs{
+		Label:   t.label + " Sum",
+		Unit:    t.unit,
+		Metrics: []mp.Metrics{{Name: "*", Label: "%1", Diff: true}},
+	}
+	c.graphs[t.graph+".quantile"] = mp.Graphs{
+		Label:   t.label + " Quantiles",
+		Unit:    t.unit,
+		Metrics: []mp.Metrics{{Name: "*", Label: "%1", Diff: false}},
+	}
+
+	var order []string
+	buckets := make(map[string]map[float64]float64)
+	for _, s := range f.samples {
+		series := seriesName(s.labels, t.labels)
+		switch {
+		case s.name == f.name+"_count":
+			c.add(t.graph+".count", series, s.value)
+		case s.name == f.name+"_sum":
+			c.add(t.graph+".sum", series, s.value)
+		case s.name == f.name+"_bucket":
+			le, err := strconv.ParseFloat(s.labels["le"], 64)
+			if err != nil {
+				continue
+			}
+			if _, ok := buckets[series]; !ok {
+				order = append(order, series)
+				buckets[series] = make(map[float64]float64)
+			}
+			buckets[series][le] += s.value
+		case s.name == f.name:
+			// quantiles of a summary, which cannot be summed; the highest one is posted
+			q, err := strconv.ParseFloat(s.labels["quantile"], 64)
+			if err != nil {
+				continue
+			}
+			c.merge(t.graph+".quantile", quantileSeries(series, q), s.value, math.Max)
+		}
+	}
+
+	for _, series := range order {
+		var bs []bucket
+		for le, count := range buckets[series] {
+			bs = append(bs, bucket{le: le, count: count})
+		}
+		sort.Sort(byUpperBound(bs))
+		key := t.graph + "." + series
+		for _, b := range bs {
+			c.buckets[bucketKey(key, b.le)] = b.count
+		}
+		if prev != nil {
+			var ok bool
+			bs, ok = bucketDelta(key, bs, prev)
+			if !ok {
+				continue
+			}
+		}
+		for _, q := range p.Quantiles {
+			if v, ok := histogramQuantile(q, append([]bucket(nil), bs...)); ok {
+				c.add(t.graph+".quantile", quantileSeries(series, q), v)
+			}
+		}
+	}
+}
+
+func quantileSeries(series string, q float64) string {
+	if series == "value" {
+		return quantileName(q)
+	}
+	return series + "_" + quantileName(q)
+}
+
+type byName []*family
+
+func (f byName) Len() int           { return len(f) }
+func (f byName) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+func (f byName) Less(i, j int) bool { return f[i].name < f[j].name }
+
+// parseQuantiles parses the comma separated quantiles, e.g. "0.5,0.9,0.99"
+func parseQuantiles(s string) ([]float64, error) {
+	var quantiles []float64
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		q, err := strconv.ParseFloat(part, 64)
+		if err != nil || q < 0 || q > 1 {
+			return nil, fmt.Errorf("invalid quantile: %s", part)
+		}
+		quantiles = append(quantiles, q)
+	}
+	return quantiles, nil
+}
+
+// Do the plugin
+func Do() {
+	optURL := flag.String("url", "", "URL of the metrics (e.g. http://localhost:9100/metrics)")
+	optConf := flag.String("conf", "", "Configuration file of the rules to map the families to graphs (TOML)")
+	optInclude := flag.String("include", "", "Regexp of the names of the families to post")
+	optExclude := flag.String("exclude", "", "Regexp of the names of the families not to post")
+	optMaxSeries := flag.Int("max-series", 500, "Maximum number of the values posted in a run (0 for unlimited)")
+	optQuantiles := flag.String("quantiles", "0.5,0.9,0.99", "Quantiles calculated from histograms")
+	optPrefix := flag.String("metric-key-prefix", "prometheus", "Metric key prefix")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	flag.Parse()
+
+	fail := func(err error) {
+		fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-prometheus: %s\n", err)
+		os.Exit(1)
+	}
+	if *optURL == "" {
+		fail(fmt.Errorf("-url is required"))
+	}
+
+	p := PrometheusPlugin{URL: *optURL, Prefix: *optPrefix, MaxSeries: *optMaxSeries}
+	var err error
+	if *optInclude != "" {
+		if p.Include, err = regexp.Compile(*optInclude); err != nil {
+			fail(err)
+		}
+	}
+	if *optExclude != "" {
+		if p.Exclude, err = regexp.Compile(*optExclude); err != nil {
+			fail(err)
+		}
+	}
+	if p.Quantiles, err = parseQuantiles(*optQuantiles); err != nil {
+		fail(err)
+	}
+	if *optConf != "" {
+		if p.Rules, err = LoadConfig(*optConf); err != nil {
+			fail(err)
+		}
+	}
+
+	tempfile := *optTempfile
+	if tempfile == "" {
+		dir := os.Getenv("MACKEREL_PLUGIN_WORKDIR")
+		if dir == "" {
+			dir = os.TempDir()
+		}
+		tempfile = filepath.Join(dir, fmt.Sprintf("mackerel-plugin-%s", p.MetricKeyPrefix()))
+	}
+	p.StateFile = tempfile + "-histogram"
+
+	helper := mp.NewMackerelPlugin(&p)
+	helper.Tempfile = tempfile
+	helper.Run()
+}
@@ -0,0 +1,320 @@
+package mpprometheus
+
+import (
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestParseText(t *testing.T) {
+	f, err := os.Open("fixtures/metrics.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	families, err := parseText(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byName := make(map[string]*family)
+	for _, f := range families {
+		byName[f.name] = f
+	}
+	expected := map[string]struct {
+		typ     string
+		samples int
+	}{
+		"go_goroutines":                 {"gauge", 1},
+		"http_requests_total":           {"counter", 3},
+		"http_request_duration_seconds": {"histogram", 6},
+		"rpc_duration_seconds":          {"summary", 4},
+		"process_resident_memory_bytes": {"gauge", 1},
+		"node_temperature":              {"untyped", 1},
+	}
+	if len(byName) != len(expected) {
+		t.Errorf("families should be %d, but %d", len(expected), len(byName))
+	}
+	for name, e := range expected {
+		f, ok := byName[name]
+		if !ok {
+			t.Errorf("%s should be parsed", name)
+			continue
+		}
+		if f.typ != e.typ || len(f.samples) != e.samples {
+			t.Errorf("%s should be %s with %d samples, but %s with %d", name, e.typ, e.samples, f.typ, len(f.samples))
+		}
+	}
+
+	s := byName["node_temperature"].samples[0]
+	if s.labels["sensor"] != `cpu "0"` || s.labels["zone"] != `a\b` {
+		t.Errorf("escaped labels are not parsed: %v", s.labels)
+	}
+}
+
+func TestParseTextError(t *testing.T) {
+	for _, text := range []string{
+		"foo{bar=\"baz\" 1\n",
+		"foo{bar=baz} 1\n",
+		"foo abc\n",
+	} {
+		if _, err := parseText(strings.NewReader(text)); err == nil {
+			t.Errorf("%q should be an error", text)
+		}
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	buckets := []bucket{
+		{le: 1, count: 100},
+		{le: 0.1, count: 60},
+		{le: inf, count: 100},
+		{le: 0.5, count: 90},
+	}
+	for q, expected := range map[float64]float64{
+		0.3:  0.05,
+		0.75: 0.3,
+		0.95: 0.75,
+		1:    1,
+	} {
+		v, ok := histogramQuantile(q, buckets)
+		if !ok || !almostEqual(v, expected) {
+			t.Errorf("%v-quantile should be %v, but %v", q, expected, v)
+		}
+	}
+
+	if _, ok := histogramQuantile(0.5, []bucket{{le: 1, count: 0}, {le: inf, count: 0}}); ok {
+		t.Errorf("the quantile of no observations should not be calculated")
+	}
+	if _, ok := histogramQuantile(0.5, []bucket{{le: 1, count: 1}, {le: 2, count: 2}}); ok {
+		t.Errorf("the quantile without +Inf bucket should not be calculated")
+	}
+}
+
+func TestQuantileName(t *testing.T) {
+	for q, expected := range map[float64]string{
+		0.5:   "p50",
+		0.9:   "p90",
+		0.99:  "p99",
+		0.999: "p99_9",
+	} {
+		if name := quantileName(q); name != expected {
+			t.Errorf("quantileName(%v) should be %s, but %s", q, expected, name)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	rules, err := LoadConfig("fixtures/rules.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || rules[0].Graph != "http.$1" || len(rules[0].Labels) != 1 || rules[0].re == nil {
+		t.Errorf("rules are not loaded: %+v", rules)
+	}
+}
+
+func testServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "fixtures/metrics.txt")
+	}))
+}
+
+func TestFetchMetrics(t *testing.T) {
+	ts := testServer()
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "mackerel-plugin-prometheus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := PrometheusPlugin{
+		URL:       ts.URL,
+		Quantiles: []float64{0.5, 0.99},
+		StateFile: filepath.Join(dir, "state"),
+	}
+	stat, err := p.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]float64{
+		"go_goroutines.value":                      42,
+		"http_requests_total.200_get":              1027,
+		"http_requests_total.500_post":             3,
+		"http_request_duration_seconds.count._api": 100,
+		"http_request_duration_seconds.sum._api":   23.5,
+		"rpc_duration_seconds.count.value":         1200,
+		"rpc_duration_seconds.sum.value":           17.2,
+		"rpc_duration_seconds.quantile.p50":        0.012,
+		"rpc_duration_seconds.quantile.p99":        0.25,
+		"process_resident_memory_bytes.value":      2.5e+07,
+	}
+	for key, v := range expected {
+		if stat[key] != v {
+			t.Errorf("%s should be %v, but %v", key, v, stat[key])
+		}
+	}
+	if len(stat) != len(expected) {
+		t.Errorf("%d values should be fetched, but %v", len(expected), stat)
+	}
+
+	// the quantiles of a histogram are calculated from the second run
+	stat, err = p.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stat["http_request_duration_seconds.quantile._api_p50"]; ok {
+		t.Errorf("the quantiles should not be calculated without new observations")
+	}
+
+	// the quantiles since the start of the process without the state
+	p.StateFile = ""
+	stat, err = p.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := stat["http_request_duration_seconds.quantile._api_p50"]; !almostEqual(v.(float64), 0.083333) {
+		t.Errorf("p50 should be 0.0833, but %v", v)
+	}
+	if v := stat["http_request_duration_seconds.quantile._api_p99"]; !almostEqual(v.(float64), 0.95) {
+		t.Errorf("p99 should be 0.95, but %v", v)
+	}
+}
+
+func TestFetchMetricsWithRules(t *testing.T) {
+	ts := testServer()
+	defer ts.Close()
+
+	rules, err := LoadConfig("fixtures/rules.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := PrometheusPlugin{
+		URL:     ts.URL,
+		Rules:   rules,
+		Include: regexp.MustCompile(`^(http|process)_`),
+		Exclude: regexp.MustCompile(`_seconds$`),
+	}
+	stat, err := p.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]float64{
+		"http.requests.200":                   1027,
+		"http.requests.500":                   3,
+		"process.resident_memory_bytes.value": 2.5e+07,
+	}
+	for key, v := range expected {
+		if stat[key] != v {
+			t.Errorf("%s should be %v, but %v", key, v, stat[key])
+		}
+	}
+	if len(stat) != len(expected) {
+		t.Errorf("%d values should be fetched, but %v", len(expected), stat)
+	}
+
+	graphs := p.GraphDefinition()
+	g, ok := graphs["http.requests"]
+	if !ok {
+		t.Fatalf("http.requests should be defined: %v", graphs)
+	}
+	if g.Label != "HTTP Requests" || g.Unit != "integer" || !g.Metrics[0].Diff {
+		t.Errorf("http.requests is not defined by the rule: %+v", g)
+	}
+	if g := graphs["process.resident_memory_bytes"]; g.Unit != "bytes" || g.Metrics[0].Diff {
+		t.Errorf("the unit should be guessed from the name: %+v", g)
+	}
+}
+
+func TestCollectSumsCollidingSeries(t *testing.T) {
+	exposition := []string{
+		`# TYPE http_requests_total counter`,
+		`http_requests_total{code="200",method="get"} 1027`,
+		`http_requests_total{code="200",method="post"} 13`,
+		`http_requests_total{code="500",method="post"} 3`,
+		`# TYPE http_request_duration_seconds histogram`,
+		`http_request_duration_seconds_bucket{code="200",method="get",le="0.1"} 60`,
+		`http_request_duration_seconds_bucket{code="200",method="get",le="1"} 60`,
+		`http_request_duration_seconds_bucket{code="200",method="get",le="+Inf"} 60`,
+		`http_request_duration_seconds_bucket{code="200",method="post",le="0.1"} 0`,
+		`http_request_duration_seconds_bucket{code="200",method="post",le="1"} 40`,
+		`http_request_duration_seconds_bucket{code="200",method="post",le="+Inf"} 40`,
+		`http_request_duration_seconds_count{code="200",method="get"} 60`,
+		`http_request_duration_seconds_count{code="200",method="post"} 40`,
+	}
+	p := PrometheusPlugin{
+		Rules:     []Rule{{Match: `^http_`, Graph: "$0", Labels: []string{"code"}, re: regexp.MustCompile(`^http_.+$`)}},
+		Quantiles: []float64{0.5},
+	}
+
+	// the values do not depend on the order of the samples
+	for _, reverse := range []bool{false, true} {
+		lines := append([]string(nil), exposition...)
+		if reverse {
+			lines[1], lines[2] = lines[2], lines[1]
+			lines[11], lines[12] = lines[12], lines[11]
+		}
+		families, err := parseText(strings.NewReader(strings.Join(lines, "\n") + "\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stat := p.collect(families, nil).values
+		expected := map[string]float64{
+			"http_requests_total.200":                        1040,
+			"http_requests_total.500":                        3,
+			"http_request_duration_seconds.count.200":        100,
+			"http_request_duration_seconds.quantile.200_p50": 0.0833,
+		}
+		for key, v := range expected {
+			if got, ok := stat[key].(float64); !ok || !almostEqual(got, v) {
+				t.Errorf("%s should be %v, but %v (reverse: %v)", key, v, stat[key], reverse)
+			}
+		}
+	}
+}
+
+func TestFetchMetricsMaxSeries(t *testing.T) {
+	scrapes := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scrapes++
+		http.ServeFile(w, r, "fixtures/metrics.txt")
+	}))
+	defer ts.Close()
+
+	p := PrometheusPlugin{URL: ts.URL, MaxSeries: 3}
+	stat, err := p.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stat) != 3 {
+		t.Errorf("values should be limited to 3, but %v", stat)
+	}
+
+	// the graphs are built from the same scrape, without the dropped series
+	graphs := p.GraphDefinition()
+	if scrapes != 1 {
+		t.Errorf("the endpoint should be scraped once in a run, but %d times", scrapes)
+	}
+	for key := range stat {
+		if _, ok := graphs[key[:strings.LastIndex(key, ".")]]; !ok {
+			t.Errorf("the graph of %s should be defined: %v", key, graphs)
+		}
+	}
+	if len(graphs) > len(stat) {
+		t.Errorf("the graphs of the dropped series should not be defined: %v", graphs)
+	}
+}
+
+var inf = math.Inf(1)
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-4
+}
@@ -2,6 +2,10 @@ package mprackstats
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -10,15 +14,15 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
 )
 
-var sock string
-
 func parseAddress(uri string) (scheme, path, port string, err error) {
 	u, err := url.Parse(uri)
 	if err != nil {
@@ -44,59 +48,120 @@ func parseAddress(uri string) (scheme, path, port string, err error) {
 func parseBody(r io.Reader, index string) (stats map[string]interface{}, err error) {
 	scanner := bufio.NewScanner(r)
 	stats = make(map[string]interface{})
+	re := regexp.MustCompile(fmt.Sprintf("%s$", regexp.QuoteMeta(index)))
 	for scanner.Scan() {
 		p := strings.Split(scanner.Text(), " ")
 		if len(p) == 2 {
 			stats[strings.Trim(p[0], ":")], err = strconv.ParseFloat(p[1], 64)
 		} else {
-			re := regexp.MustCompile(fmt.Sprintf("%s$", index))
 			if ok := re.Match([]byte(p[0])); ok && err == nil {
 				stats[strings.Trim(p[len(p)-2], ":")], err = strconv.ParseFloat(p[len(p)-1], 64)
 			}
 		}
 	}
-	stats["active"] = stats["active"].(float64) - 1
-
-	return stats, err
-}
-
-func parseBodyHTTP(uri, port string) (stats map[string]interface{}, err error) {
-	var req *http.Request
-	req, err = http.NewRequest("GET", uri, nil)
 	if err != nil {
 		return stats, err
 	}
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return stats, err
+
+	active, ok := stats["active"].(float64)
+	if !ok {
+		return stats, fmt.Errorf("active of %s is not found", index)
 	}
-	defer resp.Body.Close()
+	// exclude the request for the stats itself
+	stats["active"] = active - 1
 
-	stats, err = parseBody(resp.Body, ":"+port)
+	return stats, nil
+}
 
-	return stats, err
+// pumaStatus is the status of a Puma server, or a worker of clustered mode
+type pumaStatus struct {
+	Backlog      float64 `json:"backlog"`
+	Running      float64 `json:"running"`
+	PoolCapacity float64 `json:"pool_capacity"`
+	MaxThreads   float64 `json:"max_threads"`
 }
 
-func fakeDial(proto, addr string) (conn net.Conn, err error) {
-	return net.Dial("unix", sock)
+// pumaStats is the response of /stats of Puma control server
+type pumaStats struct {
+	pumaStatus
+	WorkerStatus []struct {
+		Index      int        `json:"index"`
+		LastStatus pumaStatus `json:"last_status"`
+	} `json:"worker_status"`
 }
 
-func parseBodyUnix(path string) (stats map[string]interface{}, err error) {
-	tr := &http.Transport{
-		Dial: fakeDial,
+// parsePumaStats converts the stats of Puma to active, queued and capacity.
+// The threads which can not take a request (max_threads - pool_capacity) are regarded as active.
+func parsePumaStats(r io.Reader, metricKey string) (map[string]interface{}, error) {
+	var s pumaStats
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, err
 	}
 
-	client := &http.Client{Transport: tr}
-	resp, err := client.Get(fmt.Sprintf("http://dummy/%s", strings.TrimLeft(path, "/")))
+	stats := make(map[string]interface{})
+	if len(s.WorkerStatus) == 0 {
+		stats["active"] = s.MaxThreads - s.PoolCapacity
+		stats["queued"] = s.Backlog
+		stats["capacity"] = s.MaxThreads
+		return stats, nil
+	}
 
-	if err != nil {
-		return stats, err
+	var active, queued, capacity float64
+	for _, w := range s.WorkerStatus {
+		active += w.LastStatus.MaxThreads - w.LastStatus.PoolCapacity
+		queued += w.LastStatus.Backlog
+		capacity += w.LastStatus.MaxThreads
+		prefix := fmt.Sprintf("rack.%s.workers.worker%d", metricKey, w.Index)
+		stats[prefix+".backlog"] = w.LastStatus.Backlog
+		stats[prefix+".pool_capacity"] = w.LastStatus.PoolCapacity
 	}
-	defer resp.Body.Close()
+	stats["active"] = active
+	stats["queued"] = queued
+	stats["capacity"] = capacity
+	return stats, nil
+}
 
-	stats, err = parseBody(resp.Body, sock)
+// passengerInfo is the output of passenger-status --show=xml
+type passengerInfo struct {
+	Max         float64 `xml:"max"`
+	WaitList    float64 `xml:"get_wait_list_size"`
+	Supergroups []struct {
+		Groups []struct {
+			WaitList  float64 `xml:"get_wait_list_size"`
+			Processes []struct {
+				Sessions float64 `xml:"sessions"`
+			} `xml:"processes>process"`
+		} `xml:"group"`
+	} `xml:"supergroups>supergroup"`
+}
 
-	return stats, err
+// parsePassengerStatus converts the pool of Passenger to active (sessions of the processes),
+// queued (the wait lists of the pool and the groups) and capacity (the max processes).
+func parsePassengerStatus(r io.Reader) (map[string]interface{}, error) {
+	var info passengerInfo
+	decoder := xml.NewDecoder(r)
+	// passenger-status declares iso8859-1, though the values used here are ASCII
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	if err := decoder.Decode(&info); err != nil {
+		return nil, err
+	}
+
+	active, queued := 0.0, info.WaitList
+	for _, sg := range info.Supergroups {
+		for _, g := range sg.Groups {
+			queued += g.WaitList
+			for _, p := range g.Processes {
+				active += p.Sessions
+			}
+		}
+	}
+	return map[string]interface{}{
+		"active":   active,
+		"queued":   queued,
+		"capacity": info.Max,
+	}, nil
 }
 
 // RackStatsPlugin mackerel plugin for Rack servers
@@ -104,6 +169,46 @@ type RackStatsPlugin struct {
 	Address   string
 	Path      string
 	MetricKey string
+	// Mode is the format of the stats: "raindrops" (default), "puma" or "passenger"
+	Mode string
+	// Token is the control token of Puma
+	Token string
+	// PassengerStatus is the passenger-status command
+	PassengerStatus string
+}
+
+// get requests path of Address, which is a URL or "unix:/path/to/sock"
+func (u RackStatsPlugin) get(path string) (io.ReadCloser, error) {
+	scheme, sock, _, err := parseAddress(u.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	var uri string
+	switch scheme {
+	case "http", "https":
+		uri = fmt.Sprintf("%s/%s", u.Address, strings.TrimLeft(path, "/"))
+	case "unix":
+		client.Transport = &http.Transport{
+			Dial: func(proto, addr string) (net.Conn, error) {
+				return net.Dial("unix", sock)
+			},
+		}
+		uri = fmt.Sprintf("http://dummy/%s", strings.TrimLeft(path, "/"))
+	default:
+		return nil, fmt.Errorf("unsupported address: %s", u.Address)
+	}
+
+	resp, err := client.Get(uri)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s returned %s", path, resp.Status)
+	}
+	return resp.Body, nil
 }
 
 // FetchMetrics interface for mackerelplugin
@@ -113,42 +218,75 @@ func (u RackStatsPlugin) FetchMetrics() (stats map[string]interface{}, err error
 }
 
 func (u RackStatsPlugin) parseStats() (stats map[string]interface{}, err error) {
-	scheme, path, port, err := parseAddress(u.Address)
+	switch u.Mode {
+	case "puma":
+		path := u.Path
+		if u.Token != "" {
+			path += "?token=" + url.QueryEscape(u.Token)
+		}
+		body, err := u.get(path)
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		key, _ := u.metricKeyAndLabel()
+		return parsePumaStats(body, key)
+	case "passenger":
+		out, err := exec.Command(u.PassengerStatus, "--show=xml").Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to exec %s: %s", u.PassengerStatus, err)
+		}
+		return parsePassengerStatus(bytes.NewReader(out))
+	case "", "raindrops":
+	default:
+		return nil, errors.New("unknown mode: " + u.Mode)
+	}
 
-	switch scheme {
-	case "http":
-		stats, err = parseBodyHTTP(fmt.Sprintf("%s/%s", u.Address, strings.TrimLeft(u.Path, "/")), port)
-	case "unix":
-		sock = path
-		stats, err = parseBodyUnix(u.Path)
+	scheme, sock, port, err := parseAddress(u.Address)
+	if err != nil {
+		return nil, err
+	}
+	body, err := u.get(u.Path)
+	if err != nil {
+		return nil, err
 	}
+	defer body.Close()
 
-	return stats, err
+	// the listener of Address in the stats of raindrops
+	index := ":" + port
+	if scheme == "unix" {
+		index = sock
+	}
+	return parseBody(body, index)
 }
 
-// GraphDefinition interface for mackerelplugin
-func (u RackStatsPlugin) GraphDefinition() map[string]mp.Graphs {
+func (u RackStatsPlugin) metricKeyAndLabel() (string, string) {
+	if u.MetricKey != "" {
+		return u.MetricKey, fmt.Sprintf("Rack %s Stats", u.MetricKey)
+	}
+	if u.Mode == "passenger" {
+		return "passenger", "Rack Passenger Stats"
+	}
+
 	scheme, path, port, err := parseAddress(u.Address)
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	var label string
-	if u.MetricKey == "" {
-		switch scheme {
-		case "http":
-			u.MetricKey = port
-			label = fmt.Sprintf("Rack Port %s Stats", port)
-		case "unix":
-			u.MetricKey = strings.Replace(strings.Replace(path, "/", "_", -1), ".", "_", -1)
-			label = fmt.Sprintf("Rack %s Stats", path)
-		}
-	} else {
-		label = fmt.Sprintf("Rack %s Stats", u.MetricKey)
+	switch scheme {
+	case "http", "https":
+		return port, fmt.Sprintf("Rack Port %s Stats", port)
+	case "unix":
+		return strings.Replace(strings.Replace(path, "/", "_", -1), ".", "_", -1), fmt.Sprintf("Rack %s Stats", path)
 	}
+	return "", ""
+}
 
-	return map[string]mp.Graphs{
-		fmt.Sprintf("rack.%s.stats", u.MetricKey): {
+// GraphDefinition interface for mackerelplugin
+func (u RackStatsPlugin) GraphDefinition() map[string]mp.Graphs {
+	key, label := u.metricKeyAndLabel()
+
+	graphdef := map[string]mp.Graphs{
+		fmt.Sprintf("rack.%s.stats", key): {
 			Label: label,
 			Unit:  "integer",
 			Metrics: []mp.Metrics{
@@ -156,15 +294,30 @@ func (u RackStatsPlugin) GraphDefinition() map[string]mp.Graphs {
 				{Name: "active", Label: "Active", Diff: false},
 				{Name: "writing", Label: "Writing", Diff: false},
 				{Name: "calling", Label: "Calling", Diff: false},
+				{Name: "capacity", Label: "Capacity", Diff: false},
 			},
 		},
 	}
+	if u.Mode == "puma" {
+		graphdef[fmt.Sprintf("rack.%s.workers.#", key)] = mp.Graphs{
+			Label: strings.TrimSuffix(label, "Stats") + "Puma Workers",
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "backlog", Label: "Backlog", Diff: false},
+				{Name: "pool_capacity", Label: "Pool Capacity", Diff: false},
+			},
+		}
+	}
+	return graphdef
 }
 
 // Do the plugin
 func Do() {
 	optAddress := flag.String("address", "http://localhost:8080", "URL or Unix Domain Socket")
-	optPath := flag.String("path", "/_raindrops", "Path")
+	optPath := flag.String("path", "", "Path (default \"/_raindrops\", or \"/stats\" for puma)")
+	optMode := flag.String("mode", "raindrops", "Stats format: raindrops, puma or passenger")
+	optToken := flag.String("token", "", "Control token of Puma")
+	optPassengerStatus := flag.String("passenger-status", "passenger-status", "passenger-status command")
 	optMetricKey := flag.String("metric-key-prefix", "", "Metric Key Prefix")
 	optVersion := flag.Bool("version", false, "Version")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
@@ -178,8 +331,26 @@ func Do() {
 	var rack RackStatsPlugin
 	rack.Address = *optAddress
 	rack.Path = *optPath
+	rack.Mode = *optMode
+	rack.Token = *optToken
+	rack.PassengerStatus = *optPassengerStatus
 	rack.MetricKey = *optMetricKey
 
+	switch rack.Mode {
+	case "raindrops":
+		if rack.Path == "" {
+			rack.Path = "/_raindrops"
+		}
+	case "puma":
+		if rack.Path == "" {
+			rack.Path = "/stats"
+		}
+	case "passenger":
+	default:
+		fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-rack-stats: unknown mode %s\n", rack.Mode)
+		os.Exit(1)
+	}
+
 	helper := mp.NewMackerelPlugin(rack)
 	if *optTempfile != "" {
 		helper.Tempfile = *optTempfile
@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -115,3 +116,108 @@ func TestParseUnix(t *testing.T) {
 	assert.EqualValues(t, reflect.TypeOf(stats["queued"]).String(), "float64")
 	assert.EqualValues(t, stats["queued"], 80)
 }
+
+func TestParseBodyWithoutActive(t *testing.T) {
+	_, err := parseBody(strings.NewReader("calling: 0\nwriting: 0\n0.0.0.0:8080 active: 1\n"), ":9090")
+	assert.NotNil(t, err)
+}
+
+func TestParsePuma(t *testing.T) {
+	single := `{"started_at":"2023-01-01T00:00:00Z","backlog":2,"running":5,"pool_capacity":1,"max_threads":5,"requests_count":100}`
+	stats, err := parsePumaStats(strings.NewReader(single), "9293")
+	assert.Nil(t, err)
+	assert.EqualValues(t, 4, stats["active"])
+	assert.EqualValues(t, 2, stats["queued"])
+	assert.EqualValues(t, 5, stats["capacity"])
+
+	clustered := `{
+  "started_at": "2023-01-01T00:00:00Z",
+  "workers": 2,
+  "phase": 0,
+  "booted_workers": 2,
+  "old_workers": 0,
+  "worker_status": [
+    {"pid": 101, "index": 0, "phase": 0, "booted": true, "last_status": {"backlog": 0, "running": 5, "pool_capacity": 5, "max_threads": 5}},
+    {"pid": 102, "index": 1, "phase": 0, "booted": true, "last_status": {"backlog": 3, "running": 5, "pool_capacity": 0, "max_threads": 5}}
+  ]
+}`
+	stats, err = parsePumaStats(strings.NewReader(clustered), "9293")
+	assert.Nil(t, err)
+	assert.EqualValues(t, 5, stats["active"])
+	assert.EqualValues(t, 3, stats["queued"])
+	assert.EqualValues(t, 10, stats["capacity"])
+	assert.EqualValues(t, 3, stats["rack.9293.workers.worker1.backlog"])
+	assert.EqualValues(t, 5, stats["rack.9293.workers.worker0.pool_capacity"])
+}
+
+func TestParsePumaUnix(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sock := fmt.Sprintf("%s/pumactl.sock", dir)
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/stats" || req.URL.Query().Get("token") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"backlog":0,"running":2,"pool_capacity":1,"max_threads":3}`)
+	}))
+
+	rack := RackStatsPlugin{Address: "unix:" + sock, Path: "/stats", Mode: "puma", Token: "secret"}
+	stats, err := rack.parseStats()
+	assert.Nil(t, err)
+	assert.EqualValues(t, 2, stats["active"])
+	assert.EqualValues(t, 3, stats["capacity"])
+	assert.Len(t, rack.GraphDefinition(), 2)
+
+	rack.Token = "wrong"
+	_, err = rack.parseStats()
+	assert.NotNil(t, err)
+}
+
+func TestParsePassenger(t *testing.T) {
+	stub := `<?xml version="1.0" encoding="iso8859-1" ?>
+<info version="3">
+  <passenger_version>6.0.17</passenger_version>
+  <process_count>3</process_count>
+  <max>6</max>
+  <capacity_used>3</capacity_used>
+  <get_wait_list_size>1</get_wait_list_size>
+  <supergroups>
+    <supergroup>
+      <name>/var/www/app</name>
+      <state>READY</state>
+      <get_wait_list_size>0</get_wait_list_size>
+      <capacity_used>3</capacity_used>
+      <group default="true">
+        <name>/var/www/app (production)</name>
+        <get_wait_list_size>4</get_wait_list_size>
+        <processes>
+          <process><pid>101</pid><sessions>1</sessions><busyness>1</busyness></process>
+          <process><pid>102</pid><sessions>1</sessions><busyness>1</busyness></process>
+          <process><pid>103</pid><sessions>0</sessions><busyness>0</busyness></process>
+        </processes>
+      </group>
+    </supergroup>
+  </supergroups>
+</info>
+`
+	stats, err := parsePassengerStatus(strings.NewReader(stub))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 2, stats["active"])
+	assert.EqualValues(t, 5, stats["queued"])
+	assert.EqualValues(t, 6, stats["capacity"])
+
+	rack := RackStatsPlugin{Mode: "passenger"}
+	graphdef := rack.GraphDefinition()
+	_, ok := graphdef["rack.passenger.stats"]
+	assert.True(t, ok)
+}
@@ -2,12 +2,17 @@ package mpsquid
 
 import (
 	"bufio"
+	"encoding/base64"
 	"flag"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
 	"os"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
 )
@@ -28,54 +33,297 @@ var graphdef = map[string]mp.Graphs{
 			{Name: "byte_ratio", Label: "Byte Ratio", Diff: false},
 		},
 	},
+	"squid.cache_hit_ratio.60min": {
+		Label: "Squid Client Cache Hit Ratio (60min)",
+		Unit:  "percentage",
+		Metrics: []mp.Metrics{
+			{Name: "request_ratio_60min", Label: "Request Ratio", Diff: false},
+			{Name: "byte_ratio_60min", Label: "Byte Ratio", Diff: false},
+		},
+	},
+	"squid.client_http": {
+		Label: "Squid Client HTTP",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "client_http_hits", Label: "Hits", Diff: true},
+			{Name: "client_http_errors", Label: "Errors", Diff: true},
+		},
+	},
+	"squid.client_http_traffic": {
+		Label: "Squid Client HTTP Traffic",
+		Unit:  "bytes",
+		Metrics: []mp.Metrics{
+			{Name: "client_http_kbytes_in", Label: "In", Diff: true, Scale: 1024},
+			{Name: "client_http_kbytes_out", Label: "Out", Diff: true, Scale: 1024},
+		},
+	},
+	"squid.server": {
+		Label: "Squid Server Requests",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "server_requests", Label: "Requests", Diff: true},
+			{Name: "server_errors", Label: "Errors", Diff: true},
+		},
+	},
+	"squid.server_traffic": {
+		Label: "Squid Server Traffic",
+		Unit:  "bytes",
+		Metrics: []mp.Metrics{
+			{Name: "server_kbytes_in", Label: "In", Diff: true, Scale: 1024},
+			{Name: "server_kbytes_out", Label: "Out", Diff: true, Scale: 1024},
+		},
+	},
+	"squid.service_time.5min": {
+		Label: "Squid Median Service Time (5min)",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "svc_time_all_5min", Label: "All", Diff: false},
+			{Name: "svc_time_miss_5min", Label: "Miss", Diff: false},
+			{Name: "svc_time_hit_5min", Label: "Hit", Diff: false},
+			{Name: "svc_time_dns_5min", Label: "DNS", Diff: false},
+		},
+	},
+	"squid.service_time.60min": {
+		Label: "Squid Median Service Time (60min)",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "svc_time_all_60min", Label: "All", Diff: false},
+			{Name: "svc_time_miss_60min", Label: "Miss", Diff: false},
+			{Name: "svc_time_hit_60min", Label: "Hit", Diff: false},
+			{Name: "svc_time_dns_60min", Label: "DNS", Diff: false},
+		},
+	},
+	"squid.file_descriptors": {
+		Label: "Squid File Descriptors",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "fd_used", Label: "Used", Diff: false},
+			{Name: "fd_max", Label: "Max", Diff: false},
+		},
+	},
+	"squid.memory": {
+		Label: "Squid Memory",
+		Unit:  "bytes",
+		Metrics: []mp.Metrics{
+			{Name: "memory_accounted", Label: "Memory Pools", Diff: false, Scale: 1024},
+		},
+	},
+	"squid.store_entries": {
+		Label: "Squid Store Entries",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "store_entries", Label: "Entries", Diff: false},
+		},
+	},
+	"squid.store_size": {
+		Label: "Squid Store Size",
+		Unit:  "bytes",
+		Metrics: []mp.Metrics{
+			{Name: "swap_size", Label: "Swap", Diff: false, Scale: 1024},
+			{Name: "mem_size", Label: "Memory", Diff: false, Scale: 1024},
+		},
+	},
 }
 
 // SquidPlugin mackerel plugin for squid
 type SquidPlugin struct {
 	Target   string
+	Password string
+	// SMP requests the cache manager with the "/squid-internal-mgr/" URL, which SMP Squid requires
+	SMP      bool
 	Tempfile string
 }
 
+var infoRegexps = map[*regexp.Regexp]string{
+	regexp.MustCompile("Number of HTTP requests received:\t([0-9]+)"): "requests",
+	// version 2
+	regexp.MustCompile("Request Hit Ratios:\t5min: ([0-9\\.]+)%"): "request_ratio",
+	regexp.MustCompile("Byte Hit Ratios:\t5min: ([0-9\\.]+)%"):    "byte_ratio",
+	// version 3
+	regexp.MustCompile("Hits as % of all requests:\t5min: ([0-9\\.]+)%"): "request_ratio",
+	regexp.MustCompile("Hits as % of bytes sent:\t5min: ([0-9\\.]+)%"):   "byte_ratio",
+	// 60min of the ratios on the same lines
+	regexp.MustCompile("(?:Request Hit Ratios|Hits as % of all requests):.*60min: ([0-9\\.]+)%"): "request_ratio_60min",
+	regexp.MustCompile("(?:Byte Hit Ratios|Hits as % of bytes sent):.*60min: ([0-9\\.]+)%"):      "byte_ratio_60min",
+	regexp.MustCompile("Maximum number of file descriptors:\\s+([0-9]+)"):                        "fd_max",
+	regexp.MustCompile("Number of file desc currently in use:\\s+([0-9]+)"):                      "fd_used",
+	regexp.MustCompile("Storage Swap size:\\s+([0-9]+) KB"):                                      "swap_size",
+	regexp.MustCompile("Storage Mem size:\\s+([0-9]+) KB"):                                       "mem_size",
+	regexp.MustCompile("^\\s*([0-9]+) StoreEntries$"):                                            "store_entries",
+	regexp.MustCompile("Total accounted:\\s+([0-9]+) KB"):                                        "memory_accounted",
+}
+
+// mgr:counters
+var counterKeys = map[string]string{
+	"client_http.hits":       "client_http_hits",
+	"client_http.errors":     "client_http_errors",
+	"client_http.kbytes_in":  "client_http_kbytes_in",
+	"client_http.kbytes_out": "client_http_kbytes_out",
+	"server.all.requests":    "server_requests",
+	"server.all.errors":      "server_errors",
+	"server.all.kbytes_in":   "server_kbytes_in",
+	"server.all.kbytes_out":  "server_kbytes_out",
+}
+
+// mgr:5min and mgr:60min
+var serviceTimeKeys = map[string]string{
+	"client_http.all_median_svc_time":  "svc_time_all",
+	"client_http.miss_median_svc_time": "svc_time_miss",
+	"client_http.hit_median_svc_time":  "svc_time_hit",
+	"dns.median_svc_time":              "svc_time_dns",
+}
+
 // FetchMetrics interface for mackerelplugin
 func (m SquidPlugin) FetchMetrics() (map[string]interface{}, error) {
-	conn, err := net.Dial("tcp", m.Target)
+	stat := make(map[string]interface{})
+
+	body, err := m.fetchPage("info")
+	if err != nil {
+		return nil, err
+	}
+	err = parseInfo(body, stat)
+	body.Close()
 	if err != nil {
 		return nil, err
 	}
-	fmt.Fprintln(conn, "GET cache_object://"+m.Target+"/info HTTP/1.0\n\n")
-	scanner := bufio.NewScanner(conn)
 
-	stat := make(map[string]interface{})
-	//regexpmap := make(map[string]*regexp.Regexp)
-	regexpmap := map[*regexp.Regexp]string{
-		regexp.MustCompile("Number of HTTP requests received:\t([0-9]+)"): "requests",
-		// version 2
-		regexp.MustCompile("Request Hit Ratios:\t5min: ([0-9\\.]+)%"): "request_ratio",
-		regexp.MustCompile("Byte Hit Ratios:\t5min: ([0-9\\.]+)%"):    "byte_ratio",
-		// version 3
-		regexp.MustCompile("Hits as % of all requests:\t5min: ([0-9\\.]+)%"): "request_ratio",
-		regexp.MustCompile("Hits as % of bytes sent:\t5min: ([0-9\\.]+)%"):   "byte_ratio",
+	body, err = m.fetchPage("counters")
+	if err != nil {
+		return nil, err
+	}
+	err = parseValues(body, counterKeys, "", stat)
+	body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, period := range []string{"5min", "60min"} {
+		body, err = m.fetchPage(period)
+		if err != nil {
+			return nil, err
+		}
+		err = parseValues(body, serviceTimeKeys, "_"+period, stat)
+		body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return stat, nil
+}
+
+// fetchPage requests a page of the cache manager.
+// The legacy form "cache_object://host/page" is sent to the proxy port as is, since it is not a URL of net/http.
+func (m SquidPlugin) fetchPage(page string) (io.ReadCloser, error) {
+	header := http.Header{}
+	if m.Password != "" {
+		// the user name is not checked by the cache manager
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("manager:"+m.Password)))
+	}
+
+	if m.SMP {
+		req, err := http.NewRequest("GET", "http://"+m.Target+"/squid-internal-mgr/"+page, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header = header
+		client := &http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		return checkResponse(resp, page)
+	}
+
+	conn, err := net.DialTimeout("tcp", m.Target, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	fmt.Fprintf(conn, "GET cache_object://%s/%s HTTP/1.0\r\n", m.Target, page)
+	header.Write(conn)
+	if _, err := io.WriteString(conn, "\r\n"); err != nil {
+		conn.Close()
+		return nil, err
 	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	body, err := checkResponse(resp, page)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return connBody{ReadCloser: body, conn: conn}, nil
+}
+
+// connBody closes the connection with the body, which is not closed by the body of http.ReadResponse
+type connBody struct {
+	io.ReadCloser
+	conn net.Conn
+}
+
+func (b connBody) Close() error {
+	err := b.ReadCloser.Close()
+	if cerr := b.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
 
+// checkResponse returns the body if the status is OK, e.g. not 401 for a wrong password
+func checkResponse(resp *http.Response, page string) (io.ReadCloser, error) {
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to get %s of cache manager: %s", page, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+func parseInfo(r io.Reader, stat map[string]interface{}) error {
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		line := scanner.Text()
-		s := string(line)
-
-		for rexp, key := range regexpmap {
-			match := rexp.FindStringSubmatch(s)
+		for rexp, key := range infoRegexps {
+			match := rexp.FindStringSubmatch(line)
 			if match == nil {
 				continue
 			}
-
-			stat[key], err = strconv.ParseFloat(match[1], 64)
+			v, err := strconv.ParseFloat(match[1], 64)
 			if err != nil {
-				return nil, err
+				return err
 			}
-			break
+			stat[key] = v
 		}
 	}
+	return scanner.Err()
+}
 
-	return stat, err
+// parseValues parses the lines like "client_http.requests = 12.3/sec" or "dns.median_svc_time = 0.012 seconds".
+func parseValues(r io.Reader, keys map[string]string, suffix string, stat map[string]interface{}) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), " = ", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, ok := keys[strings.TrimSpace(kv[0])]
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(kv[1])
+		if len(fields) == 0 {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSuffix(fields[0], "/sec"), 64)
+		if err != nil {
+			return err
+		}
+		stat[key+suffix] = v
+	}
+	return scanner.Err()
 }
 
 // GraphDefinition interface for mackerelplugin
@@ -87,11 +335,15 @@ func (m SquidPlugin) GraphDefinition() map[string]mp.Graphs {
 func Do() {
 	optHost := flag.String("host", "localhost", "Hostname")
 	optPort := flag.String("port", "3128", "Port")
+	optPassword := flag.String("password", os.Getenv("SQUID_CACHEMGR_PASSWORD"), "Password of cachemgr_passwd")
+	optSMP := flag.Bool("smp", false, "Use the /squid-internal-mgr/ URL for SMP Squid")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
 	flag.Parse()
 
 	var squid SquidPlugin
 	squid.Target = fmt.Sprintf("%s:%s", *optHost, *optPort)
+	squid.Password = *optPassword
+	squid.SMP = *optSMP
 	helper := mp.NewMackerelPlugin(squid)
 	helper.Tempfile = *optTempfile
 
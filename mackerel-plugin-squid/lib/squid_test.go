@@ -0,0 +1,122 @@
+package mpsquid
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func cacheManagerHandler(prefix string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, password, _ := r.BasicAuth()
+		if password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		content, err := ioutil.ReadFile("fixtures/" + strings.TrimPrefix(r.URL.Path, prefix) + ".txt")
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(content)
+	}
+}
+
+// serveLegacy serves "cache_object://" requests, which net/http cannot parse because of "_" of the scheme
+func serveLegacy(t *testing.T, handler http.HandlerFunc) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			tp := textproto.NewReader(bufio.NewReader(conn))
+			line, err := tp.ReadLine()
+			header, _ := tp.ReadMIMEHeader()
+			fields := strings.Fields(line)
+			if err == nil && len(fields) == 3 && strings.HasPrefix(fields[1], "cache_object://") {
+				hostPath := strings.TrimPrefix(fields[1], "cache_object://")
+				req := &http.Request{Header: http.Header(header), URL: &url.URL{Path: hostPath[strings.Index(hostPath, "/"):]}}
+				w := httptest.NewRecorder()
+				handler(w, req)
+				// the body is terminated by closing the connection as squid does
+				fmt.Fprintf(conn, "HTTP/1.0 %d %s\r\n\r\n%s", w.Code, http.StatusText(w.Code), w.Body.Bytes())
+			}
+			conn.Close()
+		}
+	}()
+	return l
+}
+
+func testFetchMetrics(t *testing.T, squid SquidPlugin) {
+	stat, err := squid.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, 1024, stat["requests"])
+	assert.EqualValues(t, 42.5, stat["request_ratio"])
+	assert.EqualValues(t, 30.2, stat["byte_ratio"])
+	assert.EqualValues(t, 40.1, stat["request_ratio_60min"])
+	assert.EqualValues(t, 28.7, stat["byte_ratio_60min"])
+	assert.EqualValues(t, 16384, stat["fd_max"])
+	assert.EqualValues(t, 25, stat["fd_used"])
+	assert.EqualValues(t, 204800, stat["swap_size"])
+	assert.EqualValues(t, 10240, stat["mem_size"])
+	assert.EqualValues(t, 8192, stat["store_entries"])
+	assert.EqualValues(t, 32768, stat["memory_accounted"])
+
+	assert.EqualValues(t, 435, stat["client_http_hits"])
+	assert.EqualValues(t, 7, stat["client_http_errors"])
+	assert.EqualValues(t, 40960, stat["client_http_kbytes_out"])
+	assert.EqualValues(t, 589, stat["server_requests"])
+	assert.EqualValues(t, 28672, stat["server_kbytes_in"])
+
+	assert.EqualValues(t, 0.012346, stat["svc_time_all_5min"])
+	assert.EqualValues(t, 0.04519, stat["svc_time_miss_5min"])
+	assert.EqualValues(t, 0.00094, stat["svc_time_dns_5min"])
+	assert.EqualValues(t, 0.01309, stat["svc_time_all_60min"])
+}
+
+func TestFetchMetricsLegacy(t *testing.T) {
+	l := serveLegacy(t, cacheManagerHandler("/"))
+	defer l.Close()
+
+	testFetchMetrics(t, SquidPlugin{Target: l.Addr().String(), Password: "secret"})
+
+	_, err := SquidPlugin{Target: l.Addr().String(), Password: "wrong"}.FetchMetrics()
+	assert.NotNil(t, err)
+}
+
+func TestFetchMetricsSMP(t *testing.T) {
+	ts := httptest.NewServer(cacheManagerHandler("/squid-internal-mgr/"))
+	defer ts.Close()
+
+	testFetchMetrics(t, SquidPlugin{Target: strings.TrimPrefix(ts.URL, "http://"), Password: "secret", SMP: true})
+}
+
+func TestConnBodyClose(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	body := connBody{ReadCloser: ioutil.NopCloser(strings.NewReader("")), conn: client}
+	assert.Nil(t, body.Close())
+	_, err := client.Write([]byte("x"))
+	assert.NotNil(t, err, "the connection should be closed with the body")
+}
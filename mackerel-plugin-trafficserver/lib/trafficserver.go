@@ -2,12 +2,20 @@ package mptrafficserver
 
 import (
 	"bytes"
+	"encoding/json"
 	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
 )
@@ -39,6 +47,81 @@ var graphdef = map[string]mp.Graphs{
 			{Name: "conn_client", Label: "Client"},
 		},
 	},
+	"trafficserver.cache_usage": {
+		Label: "Trafficserver Cache Usage",
+		Unit:  "bytes",
+		Metrics: []mp.Metrics{
+			{Name: "cache_bytes_used", Label: "Used"},
+			{Name: "cache_bytes_total", Label: "Total"},
+		},
+	},
+	"trafficserver.cache_volume.#": {
+		Label: "Trafficserver Cache Volume Usage",
+		Unit:  "bytes",
+		Metrics: []mp.Metrics{
+			{Name: "bytes_used", Label: "Used"},
+			{Name: "bytes_total", Label: "Total"},
+		},
+	},
+	"trafficserver.ram_cache": {
+		Label: "Trafficserver RAM Cache Hits/Misses",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "ram_cache_hits", Label: "Hits", Diff: true, Stacked: true},
+			{Name: "ram_cache_misses", Label: "Misses", Diff: true, Stacked: true},
+		},
+	},
+	"trafficserver.ram_cache_usage": {
+		Label: "Trafficserver RAM Cache Usage",
+		Unit:  "bytes",
+		Metrics: []mp.Metrics{
+			{Name: "ram_cache_bytes_used", Label: "Used"},
+			{Name: "ram_cache_total_bytes", Label: "Total"},
+		},
+	},
+	"trafficserver.origin_errors": {
+		Label: "Trafficserver Origin Connection Errors",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "origin_connect_fail", Label: "Connect Failures", Diff: true},
+			{Name: "origin_broken_connections", Label: "Broken Connections", Diff: true},
+		},
+	},
+	"trafficserver.transactions": {
+		Label: "Trafficserver Transactions",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "transaction_count_hit_fresh", Label: "Hit Fresh", Diff: true, Stacked: true},
+			{Name: "transaction_count_hit_revalidated", Label: "Hit Revalidated", Diff: true, Stacked: true},
+			{Name: "transaction_count_miss_cold", Label: "Miss Cold", Diff: true, Stacked: true},
+			{Name: "transaction_count_miss_not_cacheable", Label: "Miss Not Cacheable", Diff: true, Stacked: true},
+			{Name: "transaction_count_miss_changed", Label: "Miss Changed", Diff: true, Stacked: true},
+			{Name: "transaction_count_miss_client_no_cache", Label: "Miss Client No Cache", Diff: true, Stacked: true},
+		},
+	},
+	"trafficserver.transaction_time": {
+		Label: "Trafficserver Transaction Time (msec, 10s average)",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "transaction_msec_hit_fresh", Label: "Hit Fresh"},
+			{Name: "transaction_msec_hit_revalidated", Label: "Hit Revalidated"},
+			{Name: "transaction_msec_miss_cold", Label: "Miss Cold"},
+			{Name: "transaction_msec_miss_not_cacheable", Label: "Miss Not Cacheable"},
+			{Name: "transaction_msec_miss_changed", Label: "Miss Changed"},
+			{Name: "transaction_msec_miss_client_no_cache", Label: "Miss Client No Cache"},
+		},
+	},
+	"trafficserver.ssl": {
+		Label: "Trafficserver SSL Handshakes",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "ssl_handshake_success", Label: "Success (Inbound)", Diff: true},
+			{Name: "ssl_error_ssl", Label: "SSL Errors", Diff: true},
+			{Name: "ssl_error_syscall", Label: "Syscall Errors", Diff: true},
+			{Name: "ssl_user_agent_cert_verify_fail", Label: "Client Cert Verify Failures", Diff: true},
+			{Name: "ssl_origin_server_cert_verify_fail", Label: "Origin Cert Verify Failures", Diff: true},
+		},
+	},
 }
 
 var metricVarDef = map[string]string{
@@ -52,48 +135,279 @@ var metricVarDef = map[string]string{
 	"conn_client":  "proxy.node.current_client_connections",
 }
 
+// metricVarSums are summed for the metrics of metricVarDef if the proxy.node.* variables,
+// which are removed in ATS 9 together with traffic_manager, are not found
+var metricVarSums = map[string][]string{
+	"cache_hits": {
+		"proxy.process.http.cache_hit_fresh",
+		"proxy.process.http.cache_hit_mem_fresh",
+		"proxy.process.http.cache_hit_revalidated",
+		"proxy.process.http.cache_hit_ims",
+		"proxy.process.http.cache_hit_stale_served",
+	},
+	"cache_misses": {
+		"proxy.process.http.cache_miss_cold",
+		"proxy.process.http.cache_miss_changed",
+		"proxy.process.http.cache_miss_client_no_cache",
+		"proxy.process.http.cache_miss_client_not_cacheable",
+		"proxy.process.http.cache_miss_ims",
+	},
+	"conn_server": {"proxy.process.http.current_server_connections"},
+	"conn_client": {"proxy.process.http.current_client_connections"},
+}
+
+var transactionTypes = []string{
+	"hit_fresh",
+	"hit_revalidated",
+	"miss_cold",
+	"miss_not_cacheable",
+	"miss_changed",
+	"miss_client_no_cache",
+}
+
+// floatVarDef are the variables posted as float64, while the ones of metricVarDef are uint64
+var floatVarDef = map[string]string{
+	"cache_bytes_used":                   "proxy.process.cache.bytes_used",
+	"cache_bytes_total":                  "proxy.process.cache.bytes_total",
+	"ram_cache_hits":                     "proxy.process.cache.ram_cache.hits",
+	"ram_cache_misses":                   "proxy.process.cache.ram_cache.misses",
+	"ram_cache_bytes_used":               "proxy.process.cache.ram_cache.bytes_used",
+	"ram_cache_total_bytes":              "proxy.process.cache.ram_cache.total_bytes",
+	"origin_connect_fail":                "proxy.process.http.err_connect_fail_count_stat",
+	"origin_broken_connections":          "proxy.process.http.broken_server_connections",
+	"ssl_handshake_success":              "proxy.process.ssl.total_success_handshake_count_in",
+	"ssl_error_ssl":                      "proxy.process.ssl.ssl_error_ssl",
+	"ssl_error_syscall":                  "proxy.process.ssl.ssl_error_syscall",
+	"ssl_user_agent_cert_verify_fail":    "proxy.process.ssl.user_agent_cert_verify_failed",
+	"ssl_origin_server_cert_verify_fail": "proxy.process.ssl.origin_server_cert_verify_failed",
+}
+
+func init() {
+	for _, t := range transactionTypes {
+		floatVarDef["transaction_count_"+t] = "proxy.process.http.transaction_counts." + t
+		floatVarDef["transaction_msec_"+t] = "proxy.node.http.transaction_msec_avg_10s." + t
+	}
+}
+
+var cacheVolumeVar = regexp.MustCompile(`^proxy\.process\.cache\.(volume_[0-9]+)\.(bytes_used|bytes_total)$`)
+
 // TrafficserverPlugin mackerel plugin for apache trafficserver
 type TrafficserverPlugin struct {
 	Tempfile string
+	// Source is one of "traffic_ctl", "traffic_line" and "http", or "" to select by availability
+	Source   string
+	StatsURL string
+	// StateFile keeps the total transaction times and counts of the previous run, to calculate
+	// the average transaction times without proxy.node.* variables (ATS 9 and later)
+	StateFile string
 }
 
 // FetchMetrics interface for mackerelplugin
 func (m TrafficserverPlugin) FetchMetrics() (map[string]interface{}, error) {
-	var err error
-	strp, err := getDataWithCommand()
-	if err != nil {
-		return nil, err
+	source := m.Source
+	if source == "" {
+		source = detectSource()
+	}
+
+	var vars map[string]string
+	switch source {
+	case "http":
+		var err error
+		vars, err = getDataWithHTTP(m.StatsURL)
+		if err != nil {
+			return nil, err
+		}
+	case "traffic_ctl", "traffic_line":
+		strp, err := getDataWithCommand(source)
+		if err != nil {
+			return nil, err
+		}
+		vars = readVars(*strp)
+	default:
+		return nil, fmt.Errorf("unknown source: %s", source)
 	}
 
 	stat := make(map[string]interface{})
-	parseVars(strp, &stat)
+	setMetrics(vars, stat)
+	if m.StateFile != "" {
+		prev := loadTransactionTotals(m.StateFile)
+		cur := setTransactionTime(vars, prev, stat)
+		if err := saveTransactionTotals(m.StateFile, cur); err != nil {
+			getStderrLogger().Printf("Failed to save %s: %s", m.StateFile, err)
+		}
+	}
 
 	return stat, nil
 }
 
+// detectSource selects traffic_ctl of ATS 6 and later, traffic_line of the earlier versions,
+// or stats_over_http if neither of them is found.
+func detectSource() string {
+	for _, command := range []string{"traffic_ctl", "traffic_line"} {
+		if _, err := exec.LookPath(command); err == nil {
+			return command
+		}
+	}
+	return "http"
+}
+
 func parseVars(text *string, statp *map[string]interface{}) error {
-	stat := *statp
+	setMetrics(readVars(*text), *statp)
 
-	varMetric := make(map[string]string)
-	for metric, varkey := range metricVarDef {
-		varMetric[varkey] = metric
-	}
+	return nil
+}
 
-	lines := strings.Split(*text, "\n")
+// readVars reads the lines of "<name> <value>" of traffic_ctl and traffic_line
+func readVars(text string) map[string]string {
+	vars := make(map[string]string)
+	lines := strings.Split(text, "\n")
 	for _, line := range lines {
 		factors := strings.Split(line, " ")
-		varkey := factors[0]
+		if len(factors) < 2 {
+			continue
+		}
+		vars[factors[0]] = factors[1]
+	}
+	return vars
+}
+
+// setTransactionTime sets the average transaction times (msec) since the previous run from the total
+// times (sec) and the counts of transactions, unless proxy.node.http.transaction_msec_avg_10s.* are set.
+// It returns the current totals to be kept for the next run.
+func setTransactionTime(vars map[string]string, prev map[string]float64, stat map[string]interface{}) map[string]float64 {
+	cur := make(map[string]float64)
+	for _, t := range transactionTypes {
+		if _, ok := stat["transaction_msec_"+t]; ok {
+			continue
+		}
+		totaltime, err := strconv.ParseFloat(vars["proxy.process.http.transaction_totaltime."+t], 64)
+		if err != nil {
+			continue
+		}
+		count, err := strconv.ParseFloat(vars["proxy.process.http.transaction_counts."+t], 64)
+		if err != nil {
+			continue
+		}
+		cur[t+".totaltime"] = totaltime
+		cur[t+".count"] = count
 
-		if metric, present := varMetric[varkey]; present {
-			stat[metric], _ = strconv.ParseUint(factors[1], 10, 64)
+		prevTime, ok1 := prev[t+".totaltime"]
+		prevCount, ok2 := prev[t+".count"]
+		// no transactions, or the counters were reset by a restart
+		if !ok1 || !ok2 || count <= prevCount || totaltime < prevTime {
+			continue
 		}
+		stat["transaction_msec_"+t] = (totaltime - prevTime) * 1000 / (count - prevCount)
 	}
+	return cur
+}
 
-	return nil
+func loadTransactionTotals(file string) map[string]float64 {
+	totals := make(map[string]float64)
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return totals
+	}
+	if err := json.Unmarshal(content, &totals); err != nil {
+		getStderrLogger().Printf("Failed to read %s: %s", file, err)
+	}
+	return totals
+}
+
+func saveTransactionTotals(file string, totals map[string]float64) error {
+	content, err := json.Marshal(totals)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(file, content)
 }
 
-func getDataWithCommand() (*string, error) {
-	cmd := exec.Command("traffic_line", "-m", "^proxy")
+// writeFileAtomic writes the content to a temporary file and renames it,
+// so that an interrupted run does not leave a truncated file
+func writeFileAtomic(file string, content []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file))
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), file)
+}
+
+// parseStatsJSON parses the JSON of the stats_over_http plugin, whose values are strings before ATS 9.
+func parseStatsJSON(r io.Reader) (map[string]string, error) {
+	var body struct {
+		Global map[string]interface{} `json:"global"`
+	}
+	if err := json.NewDecoder(r).Decode(&body); err != nil {
+		return nil, err
+	}
+	vars := make(map[string]string)
+	for k, v := range body.Global {
+		switch v := v.(type) {
+		case string:
+			vars[k] = v
+		case float64:
+			vars[k] = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	}
+	return vars, nil
+}
+
+func setMetrics(vars map[string]string, stat map[string]interface{}) {
+	for metric, varkey := range metricVarDef {
+		if v, present := vars[varkey]; present {
+			stat[metric], _ = strconv.ParseUint(v, 10, 64)
+			continue
+		}
+		var sum uint64
+		var found bool
+		for _, varkey := range metricVarSums[metric] {
+			if v, err := strconv.ParseUint(vars[varkey], 10, 64); err == nil {
+				sum += v
+				found = true
+			}
+		}
+		if found {
+			stat[metric] = sum
+		}
+	}
+
+	for metric, varkey := range floatVarDef {
+		if v, err := strconv.ParseFloat(vars[varkey], 64); err == nil {
+			stat[metric] = v
+		}
+	}
+
+	for varkey, value := range vars {
+		match := cacheVolumeVar.FindStringSubmatch(varkey)
+		if match == nil {
+			continue
+		}
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			stat["trafficserver.cache_volume."+match[1]+"."+match[2]] = v
+		}
+	}
+}
+
+func getDataWithCommand(command string) (*string, error) {
+	var cmd *exec.Cmd
+	if command == "traffic_ctl" {
+		cmd = exec.Command("traffic_ctl", "metric", "match", "^proxy")
+	} else {
+		cmd = exec.Command("traffic_line", "-m", "^proxy")
+	}
 
 	var out bytes.Buffer
 	cmd.Stdout = &out
@@ -106,6 +420,19 @@ func getDataWithCommand() (*string, error) {
 	return &str, nil
 }
 
+func getDataWithHTTP(url string) (map[string]string, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get %s: %s", url, resp.Status)
+	}
+	return parseStatsJSON(resp.Body)
+}
+
 // GraphDefinition interface for mackerelplugin
 func (m TrafficserverPlugin) GraphDefinition() map[string]mp.Graphs {
 	return graphdef
@@ -123,9 +450,22 @@ func getStderrLogger() *log.Logger {
 // Do the plugin
 func Do() {
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	optSource := flag.String("source", "", "traffic_ctl, traffic_line or http (default: selected by availability)")
+	optStatsURL := flag.String("stats-url", "http://127.0.0.1:8080/_stats", "URL of the stats_over_http plugin for -source=http")
 	flag.Parse()
 
 	var trafficserver TrafficserverPlugin
+	trafficserver.Source = *optSource
+	trafficserver.StatsURL = *optStatsURL
+	tempfile := *optTempfile
+	if tempfile == "" {
+		dir := os.Getenv("MACKEREL_PLUGIN_WORKDIR")
+		if dir == "" {
+			dir = os.TempDir()
+		}
+		tempfile = filepath.Join(dir, "mackerel-plugin-trafficserver")
+	}
+	trafficserver.StateFile = tempfile + "-transaction"
 
 	helper := mp.NewMackerelPlugin(trafficserver)
 	helper.Tempfile = *optTempfile
@@ -1,6 +1,10 @@
 package mptrafficserver
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -18,6 +22,92 @@ func TestParsePluginConfig(t *testing.T) {
 	assert.EqualValues(t, stat["http_5xx"], 164237)
 	assert.EqualValues(t, stat["conn_server"], 90)
 	assert.EqualValues(t, stat["conn_client"], 2)
+
+	assert.EqualValues(t, stat["cache_bytes_used"], 5353586688)
+	assert.EqualValues(t, stat["trafficserver.cache_volume.volume_0.bytes_total"], 5361975296)
+	assert.EqualValues(t, stat["ram_cache_hits"], 2122644)
+	assert.EqualValues(t, stat["origin_connect_fail"], 96737)
+	assert.EqualValues(t, stat["origin_broken_connections"], 38491)
+	assert.EqualValues(t, stat["transaction_count_miss_cold"], 65931881)
+	assert.EqualValues(t, stat["transaction_msec_miss_cold"], 984)
+}
+
+func TestParseStatsJSON(t *testing.T) {
+	// stats_over_http of ATS 9, without proxy.node.* variables
+	stub := `{ "global": {
+"proxy.process.http.2xx_responses": "1200",
+"proxy.process.http.5xx_responses": "3",
+"proxy.process.http.cache_hit_fresh": "100",
+"proxy.process.http.cache_hit_revalidated": "20",
+"proxy.process.http.cache_miss_cold": "300",
+"proxy.process.http.cache_miss_changed": "4",
+"proxy.process.http.current_client_connections": "7",
+"proxy.process.http.current_server_connections": "5",
+"proxy.process.cache.volume_1.bytes_used": "1048576",
+"proxy.process.cache.volume_1.bytes_total": "268435456",
+"proxy.process.cache.volume_2.bytes_used": "0",
+"proxy.process.cache.volume_2.bytes_total": "268435456",
+"proxy.process.ssl.total_success_handshake_count_in": "50",
+"proxy.process.ssl.ssl_error_ssl": "2",
+"proxy.process.http.transaction_totaltime.hit_fresh": "1.250000",
+"proxy.process.http.transaction_counts.hit_fresh": "100",
+"server": "9.1.0"
+}
+}`
+	vars, err := parseStatsJSON(strings.NewReader(stub))
+	assert.Nil(t, err)
+	stat := make(map[string]interface{})
+	setMetrics(vars, stat)
+
+	assert.EqualValues(t, stat["http_2xx"], 1200)
+	assert.EqualValues(t, stat["cache_hits"], 120)
+	assert.EqualValues(t, stat["cache_misses"], 304)
+	assert.EqualValues(t, stat["conn_client"], 7)
+	assert.EqualValues(t, stat["conn_server"], 5)
+	assert.EqualValues(t, stat["trafficserver.cache_volume.volume_1.bytes_used"], 1048576)
+	assert.EqualValues(t, stat["trafficserver.cache_volume.volume_2.bytes_total"], 268435456)
+	assert.EqualValues(t, stat["ssl_handshake_success"], 50)
+	assert.EqualValues(t, stat["ssl_error_ssl"], 2)
+	_, ok := stat["transaction_msec_hit_fresh"]
+	assert.False(t, ok)
+
+	// the average since the previous run is calculated from the total time and the count
+	prev := setTransactionTime(vars, nil, stat)
+	assert.Equal(t, map[string]float64{"hit_fresh.totaltime": 1.25, "hit_fresh.count": 100}, prev)
+	_, ok = stat["transaction_msec_hit_fresh"]
+	assert.False(t, ok, "the average should not be calculated at the first run")
+
+	vars["proxy.process.http.transaction_totaltime.hit_fresh"] = "2.250000"
+	vars["proxy.process.http.transaction_counts.hit_fresh"] = "150"
+	setTransactionTime(vars, prev, stat)
+	assert.InDelta(t, 20.0, stat["transaction_msec_hit_fresh"], 0.0001)
+}
+
+func TestSetTransactionTimeWithNodeVars(t *testing.T) {
+	stat := make(map[string]interface{})
+	parseVars(&parseVarsStub, &stat)
+	vars := readVars(parseVarsStub)
+	prev := map[string]float64{"miss_cold.totaltime": 0, "miss_cold.count": 0}
+	cur := setTransactionTime(vars, prev, stat)
+	// proxy.node.* variables are preferred
+	assert.EqualValues(t, 984, stat["transaction_msec_miss_cold"])
+	assert.NotContains(t, cur, "miss_cold.count")
+}
+
+func TestSaveTransactionTotals(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "mackerel-plugin-trafficserver-transaction")
+
+	totals := map[string]float64{"miss_cold.totaltime": 12.5, "miss_cold.count": 100}
+	assert.Nil(t, saveTransactionTotals(file, totals))
+	assert.Equal(t, totals, loadTransactionTotals(file))
+
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(t, files, 1, "the temporary file should be renamed")
 }
 
 var parseVarsStub = `
@@ -0,0 +1,109 @@
+package mpunicorn
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// readPpid returns the parent pid in /proc/PID/stat
+func readPpid(procDir string) (string, error) {
+	content, err := ioutil.ReadFile(filepath.Join(procDir, "stat"))
+	if err != nil {
+		return "", err
+	}
+	// comm in parentheses may contain spaces
+	s := string(content)
+	fields := strings.Fields(s[strings.LastIndex(s, ")")+1:])
+	if len(fields) < 2 {
+		return "", fmt.Errorf("invalid stat of %s", procDir)
+	}
+	return fields[1], nil
+}
+
+// findChildPids returns the pids of the children of ppid under procRoot (usually /proc)
+func findChildPids(procRoot, ppid string) ([]string, error) {
+	dir, err := os.Open(procRoot)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	var pids []string
+	for _, pid := range names {
+		if _, err := strconv.Atoi(pid); err != nil {
+			continue
+		}
+		parent, err := readPpid(filepath.Join(procRoot, pid))
+		if err != nil {
+			// The process with pid terminates
+			continue
+		}
+		if parent == ppid {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// readPss returns PSS of the process in bytes from smaps_rollup, or the sum of smaps before Linux 4.14.
+// PSS divides the pages shared by copy-on-write among the forked processes, unlike RSS.
+func readPss(procDir string) (float64, error) {
+	f, err := os.Open(filepath.Join(procDir, "smaps_rollup"))
+	if os.IsNotExist(err) {
+		f, err = os.Open(filepath.Join(procDir, "smaps"))
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var pss float64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "Pss:" {
+			continue
+		}
+		kb, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return 0, err
+		}
+		pss += kb * 1024
+	}
+	return pss, scanner.Err()
+}
+
+// socketInodes returns the inodes of the sockets opened by the process
+func socketInodes(procDir string) (map[string]bool, error) {
+	fdDir := filepath.Join(procDir, "fd")
+	dir, err := os.Open(fdDir)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	inodes := make(map[string]bool)
+	for _, name := range names {
+		link, err := os.Readlink(filepath.Join(fdDir, name))
+		if err != nil {
+			continue
+		}
+		if strings.HasPrefix(link, "socket:[") && strings.HasSuffix(link, "]") {
+			inodes[link[len("socket:["):len(link)-1]] = true
+		}
+	}
+	return inodes, nil
+}
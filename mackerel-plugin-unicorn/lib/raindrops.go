@@ -0,0 +1,58 @@
+package mpunicorn
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// "<listener> active: N" and "<listener> queued: N" lines of Raindrops::Middleware
+var raindropsListenerRe = regexp.MustCompile(`^\S+ (active|queued): ([0-9]+)$`)
+
+// fetchRaindrops gets the activity of the listeners from the endpoint of Raindrops::Middleware (e.g. /_raindrops)
+func fetchRaindrops(uri string) (activity, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(uri)
+	if err != nil {
+		return activity{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return activity{}, fmt.Errorf("Raindrops returned %s", resp.Status)
+	}
+	return parseRaindrops(resp.Body)
+}
+
+func parseRaindrops(r io.Reader) (activity, error) {
+	var a activity
+	found := false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		m := raindropsListenerRe.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		n, err := strconv.Atoi(m[2])
+		if err != nil {
+			return a, err
+		}
+		found = true
+		if m[1] == "active" {
+			a.busy += n
+		} else {
+			a.queued += n
+			a.hasQueued = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return a, err
+	}
+	if !found {
+		return a, fmt.Errorf("Cannot find listener stats in Raindrops output")
+	}
+	return a, nil
+}
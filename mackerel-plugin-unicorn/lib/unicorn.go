@@ -2,7 +2,6 @@ package mpunicorn
 
 import (
 	"flag"
-	"fmt"
 	"io/ioutil"
 
 	"os"
@@ -33,12 +32,23 @@ var graphdef = map[string]mp.Graphs{
 			{Name: "idle_workers", Label: "Idle Workers", Diff: false, Stacked: true},
 		},
 	},
+	"unicorn.queue": {
+		Label: "Unicorn Listen Queue",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "queued", Label: "Queued", Diff: false, Stacked: false},
+		},
+	},
 }
 
 // UnicornPlugin mackerel plugin for Unicorn
+// The busy workers are counted by Raindrops::Middleware at the Raindrops URI if set,
+// or by the connections of the listeners in ProcRoot.
 type UnicornPlugin struct {
 	MasterPid  string
 	WorkerPids []string
+	ProcRoot   string
+	Raindrops  string
 	Tempfile   string
 }
 
@@ -47,31 +57,40 @@ func (u UnicornPlugin) FetchMetrics() (map[string]interface{}, error) {
 	stat := make(map[string]interface{})
 
 	workers := len(u.WorkerPids)
-	idles, err := idleWorkerCount(u.WorkerPids)
+	var a activity
+	var err error
+	if u.Raindrops != "" {
+		a, err = fetchRaindrops(u.Raindrops)
+	} else {
+		a, err = listenerActivity(u.ProcRoot, u.MasterPid, u.WorkerPids)
+	}
 	if err != nil {
-		return stat, err
+		logger.Warningf("Failed to count busy workers: %s", err)
+	} else {
+		busy := a.busy
+		if busy > workers {
+			busy = workers
+		}
+		stat["busy_workers"] = float64(busy)
+		stat["idle_workers"] = float64(workers - busy)
+		if a.hasQueued {
+			stat["queued"] = float64(a.queued)
+		}
 	}
-	stat["idle_workers"] = fmt.Sprint(idles)
-	stat["busy_workers"] = fmt.Sprint(workers - idles)
 
-	workersM, err := workersMemory()
+	workersM, averageM, err := workersMemory(u.ProcRoot, u.WorkerPids)
 	if err != nil {
 		return stat, err
 	}
 	stat["memory_workers"] = workersM
+	stat["memory_workeravg"] = averageM
 
-	masterM, err := masterMemory()
+	masterM, err := masterMemory(u.ProcRoot, u.MasterPid)
 	if err != nil {
 		return stat, err
 	}
 	stat["memory_master"] = masterM
 
-	averageM, err := workersMemoryAvg()
-	if err != nil {
-		return stat, err
-	}
-	stat["memory_workeravg"] = averageM
-
 	return stat, nil
 }
 
@@ -83,12 +102,10 @@ func (u UnicornPlugin) GraphDefinition() map[string]mp.Graphs {
 // Do the plugin
 func Do() {
 	optPidFile := flag.String("pidfile", "", "Pid file name")
+	optRaindrops := flag.String("raindrops", "", "URI of Raindrops::Middleware (e.g. http://127.0.0.1:8080/_raindrops)")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
 	flag.Parse()
-	var unicorn UnicornPlugin
-
-	command = RealCommand{}
-	pipedCommands = RealPipedCommands{}
+	unicorn := UnicornPlugin{ProcRoot: "/proc", Raindrops: *optRaindrops}
 
 	if *optPidFile == "" {
 		logger.Errorf("Required unicorn pidfile.")
@@ -99,10 +116,10 @@ func Do() {
 			logger.Errorf("Failed to load unicorn pid file. %s", err)
 			os.Exit(1)
 		}
-		unicorn.MasterPid = strings.Replace(string(pid), "\n", "", 1)
+		unicorn.MasterPid = strings.TrimSpace(string(pid))
 	}
 
-	workerPids, err := fetchUnicornWorkerPids(unicorn.MasterPid)
+	workerPids, err := fetchUnicornWorkerPids(unicorn.ProcRoot, unicorn.MasterPid)
 	if err != nil {
 		logger.Errorf("Failed to fetch unicorn worker pids. %s", err)
 		os.Exit(1)
@@ -2,45 +2,32 @@ package mpunicorn
 
 import (
 	"fmt"
-
-	"strings"
+	"path/filepath"
 )
 
-func workersMemory() (string, error) {
-	out, err := pipedCommands.Output(
-		[]string{"ps", "auxw"},
-		[]string{"grep", "[u]nicorn"},
-		[]string{"grep", "-v", "master"},
-		[]string{"awk", "{m+=$6*1024} END{print m;}"},
-	)
-	if err != nil {
-		return "", fmt.Errorf("Cannot get unicorn workers memory: %s", err)
+// workersMemory returns the total and the average PSS of the workers
+func workersMemory(procRoot string, pids []string) (float64, float64, error) {
+	var total float64
+	var n int
+	for _, pid := range pids {
+		pss, err := readPss(filepath.Join(procRoot, pid))
+		if err != nil {
+			// The worker with pid terminates
+			continue
+		}
+		total += pss
+		n++
 	}
-	return strings.Trim(string(out), "\n"), nil
-}
-
-func masterMemory() (string, error) {
-	out, err := pipedCommands.Output(
-		[]string{"ps", "auxw"},
-		[]string{"grep", "[u]nicorn"},
-		[]string{"grep", "master"},
-		[]string{"awk", "{m+=$6*1024} END{print m;}"},
-	)
-	if err != nil {
-		return "", fmt.Errorf("Cannot get unicorn master memory: %s", err)
+	if n == 0 {
+		return 0, 0, fmt.Errorf("Cannot get unicorn workers memory")
 	}
-	return strings.Trim(string(out), "\n"), nil
+	return total, total / float64(n), nil
 }
 
-func workersMemoryAvg() (string, error) {
-	out, err := pipedCommands.Output(
-		[]string{"ps", "auxw"},
-		[]string{"grep", "[u]nicorn"},
-		[]string{"grep", "-v", "master"},
-		[]string{"awk", "{mem=$6*1024+mem; proc++} END{print mem/proc}"},
-	)
+func masterMemory(procRoot string, pid string) (float64, error) {
+	pss, err := readPss(filepath.Join(procRoot, pid))
 	if err != nil {
-		return "", fmt.Errorf("Cannot get unicorn memory average: %s", err)
+		return 0, fmt.Errorf("Cannot get unicorn master memory: %s", err)
 	}
-	return strings.Trim(string(out), "\n"), nil
+	return pss, nil
 }
@@ -2,47 +2,30 @@ package mpunicorn
 
 import "testing"
 
-type TestWorkersMemoryPipedCommands struct{}
-
-func (r TestWorkersMemoryPipedCommands) Output(commands ...[]string) ([]byte, error) {
-	return []byte("3321016320\n"), nil
-}
-
 func TestWorkersMemory(t *testing.T) {
-	pipedCommands = TestWorkersMemoryPipedCommands{}
-	expectedMemory := "3321016320"
-	m, _ := workersMemory()
-	if m != expectedMemory {
-		t.Errorf("workersMemory: expected %s but got %s", expectedMemory, m)
+	// 3002 has smaps without smaps_rollup
+	total, avg, err := workersMemory("./sample/proc", []string{"3001", "3002", "9999"})
+	if err != nil {
+		t.Fatal(err)
 	}
-}
-
-type TestMasterMemoryPipedCommands struct{}
-
-func (r TestMasterMemoryPipedCommands) Output(commands ...[]string) ([]byte, error) {
-	return []byte("114636\n"), nil
-}
-
-func TestMasterMemory(t *testing.T) {
-	pipedCommands = TestMasterMemoryPipedCommands{}
-	expectedMemory := "114636"
-	m, _ := masterMemory()
-	if m != expectedMemory {
-		t.Errorf("masterMemory: expected %s but got %s", expectedMemory, m)
+	var expectedMemory float64 = 204800 * 1024
+	if total != expectedMemory {
+		t.Errorf("workersMemory: expected %v but got %v", expectedMemory, total)
+	}
+	if avg != expectedMemory/2 {
+		t.Errorf("workersMemory: expected average %v but got %v", expectedMemory/2, avg)
 	}
-}
-
-type TestWorkersMemoryAvgPipedCommands struct{}
 
-func (r TestWorkersMemoryAvgPipedCommands) Output(commands ...[]string) ([]byte, error) {
-	return []byte("204277504\n"), nil
+	_, _, err = workersMemory("./sample/proc", []string{"9999"})
+	if err == nil {
+		t.Errorf("workersMemory: should fail without workers")
+	}
 }
 
-func TestWorkersMemoryAvg(t *testing.T) {
-	pipedCommands = TestWorkersMemoryAvgPipedCommands{}
-	expectedMemory := "204277504"
-	m, _ := workersMemoryAvg()
+func TestMasterMemory(t *testing.T) {
+	var expectedMemory float64 = 20480 * 1024
+	m, _ := masterMemory("./sample/proc", "3000")
 	if m != expectedMemory {
-		t.Errorf("workersMemoryAvg: expected %s but got %s", expectedMemory, m)
+		t.Errorf("masterMemory: expected %v but got %v", expectedMemory, m)
 	}
 }
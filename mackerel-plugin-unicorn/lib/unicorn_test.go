@@ -1,13 +1,66 @@
 package mpunicorn
 
-import "testing"
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
 
 func TestFetchMetrics(t *testing.T) {
-	var unicorn UnicornPlugin
+	root := setupProcRoot(t)
+	defer os.RemoveAll(root)
+
+	unicorn := UnicornPlugin{
+		MasterPid:  "3000",
+		WorkerPids: []string{"3001", "3002", "3003"},
+		ProcRoot:   root,
+	}
 
-	stat, _ := unicorn.FetchMetrics()
-	if len(stat) != 5 {
-		t.Errorf("GetStat: %d should be 5", len(stat))
+	stat, err := unicorn.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stat) != 6 {
+		t.Errorf("GetStat: %d should be 6", len(stat))
+	}
+	expected := map[string]float64{
+		"busy_workers":     2,
+		"idle_workers":     1,
+		"queued":           3,
+		"memory_workers":   (102400 + 102400 + 98304) * 1024,
+		"memory_workeravg": (102400 + 102400 + 98304) * 1024 / 3.0,
+		"memory_master":    20480 * 1024,
+	}
+	for k, v := range expected {
+		if stat[k] != v {
+			t.Errorf("FetchMetrics: %s expected %v but got %v", k, v, stat[k])
+		}
+	}
+}
+
+func TestFetchMetricsRaindrops(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "calling: 0\nwriting: 1\n/tmp/unicorn.sock active: 1\n/tmp/unicorn.sock queued: 4\n")
+	}))
+	defer ts.Close()
+	root := setupProcRoot(t)
+	defer os.RemoveAll(root)
+
+	unicorn := UnicornPlugin{
+		MasterPid:  "3000",
+		WorkerPids: []string{"3001", "3002", "3003"},
+		ProcRoot:   root,
+		Raindrops:  ts.URL + "/_raindrops",
+	}
+
+	stat, err := unicorn.FetchMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat["busy_workers"] != 1.0 || stat["idle_workers"] != 2.0 || stat["queued"] != 4.0 {
+		t.Errorf("FetchMetrics: unexpected workers %v", stat)
 	}
 }
 
@@ -15,7 +68,7 @@ func TestGraphDefinition(t *testing.T) {
 	var unicorn UnicornPlugin
 
 	graphdef := unicorn.GraphDefinition()
-	if len(graphdef) != 2 {
-		t.Errorf("GetTempfilename: %d should be 2", len(graphdef))
+	if len(graphdef) != 3 {
+		t.Errorf("GetTempfilename: %d should be 3", len(graphdef))
 	}
 }
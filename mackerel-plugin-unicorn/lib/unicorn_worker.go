@@ -1,78 +1,183 @@
 package mpunicorn
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
-	"time"
-
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 )
 
-func idleWorkerCount(pids []string) (int, error) {
-	var beforeCPU []string
-	var afterCPU []string
-	idles := 0
+// activity is the number of the workers processing requests, and the number of
+// the connections waiting in the listen queue if known
+type activity struct {
+	busy      int
+	queued    int
+	hasQueued bool
+}
 
-	for _, pid := range pids {
-		cputime, err := cpuTime(pid)
-		if err != nil {
-			return idles, err
-		}
-		beforeCPU = append(beforeCPU, cputime)
+// fetchUnicornWorkerPids returns the workers forked by the master m.
+// A new master forked by USR2 is excluded.
+func fetchUnicornWorkerPids(procRoot, m string) ([]string, error) {
+	pids, err := findChildPids(procRoot, m)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s: %s", procRoot, err)
 	}
-	time.Sleep(1 * time.Second)
+
+	var workerPids []string
 	for _, pid := range pids {
-		cputime, err := cpuTime(pid)
-		if err != nil {
-			return idles, err
+		cmdline, err := ioutil.ReadFile(filepath.Join(procRoot, pid, "cmdline"))
+		if err != nil || bytes.Contains(cmdline, []byte("master")) {
+			continue
 		}
-		afterCPU = append(afterCPU, cputime)
+		workerPids = append(workerPids, pid)
 	}
-	for i := range pids {
-		b, _ := strconv.Atoi(beforeCPU[i])
-		a, _ := strconv.Atoi(afterCPU[i])
-		if (a - b) == 0 {
-			idles++
-		}
+
+	if len(workerPids) > 0 {
+		return workerPids, nil
 	}
 
-	return idles, nil
+	return workerPids, fmt.Errorf("Cannot get unicorn worker pids")
 }
 
-func fetchUnicornWorkerPids(m string) ([]string, error) {
-	var workerPids []string
+// netSocket is a socket in /proc/net/{tcp,tcp6,unix}
+type netSocket struct {
+	listen      bool
+	established bool
+	// the local port of TCP or the path of UNIX domain socket
+	address string
+	// the length of the accept queue of a listening TCP socket
+	queued int
+}
 
-	out, err := command.Output("ps", "wh", "--ppid", m)
-	if err != nil {
-		return workerPids, fmt.Errorf("Failed to ps of command: %s", err)
+// tcpStates of /proc/net/tcp (include/net/tcp_states.h)
+const (
+	tcpEstablished = "01"
+	tcpListen      = "0A"
+)
+
+// unix socket flag of the listening sockets (__SO_ACCEPTCON) and state of the connected sockets (SS_CONNECTED)
+const (
+	unixAcceptCon = 0x10000
+	unixConnected = "03"
+)
+
+// readNetSockets reads the TCP and UNIX domain sockets of the network namespace of procRoot, keyed by inodes
+func readNetSockets(procRoot string) (map[string]netSocket, error) {
+	sockets := make(map[string]netSocket)
+	for _, name := range []string{"tcp", "tcp6"} {
+		err := readNetFile(filepath.Join(procRoot, "net", name), func(fields []string) {
+			// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+			if len(fields) < 10 {
+				return
+			}
+			local := fields[1][strings.LastIndex(fields[1], ":")+1:]
+			port, err := strconv.ParseUint(local, 16, 16)
+			if err != nil {
+				return
+			}
+			s := netSocket{
+				listen:      fields[3] == tcpListen,
+				established: fields[3] == tcpEstablished,
+				address:     strconv.FormatUint(port, 10),
+			}
+			if s.listen {
+				// rx_queue of a listening socket is the number of the connections not accepted yet
+				queue := strings.SplitN(fields[4], ":", 2)
+				if len(queue) == 2 {
+					n, _ := strconv.ParseUint(queue[1], 16, 32)
+					s.queued = int(n)
+				}
+			}
+			sockets[fields[9]] = s
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
 	}
 
-	for _, line := range strings.Split(string(out), "\n") {
-		if !strings.Contains(line, "worker") {
-			continue
+	err := readNetFile(filepath.Join(procRoot, "net", "unix"), func(fields []string) {
+		// Num RefCount Protocol Flags Type St Inode Path
+		if len(fields) < 8 {
+			return
 		}
-		words := strings.SplitN(strings.TrimSpace(line), " ", 5)
-		if len(words) < 5 {
-			continue
+		flags, err := strconv.ParseUint(fields[3], 16, 32)
+		if err != nil {
+			return
+		}
+		sockets[fields[6]] = netSocket{
+			listen:      flags&unixAcceptCon != 0,
+			established: flags&unixAcceptCon == 0 && fields[5] == unixConnected,
+			address:     fields[7],
 		}
-		workerPids = append(workerPids, words[0])
+	})
+	if err != nil {
+		return nil, err
 	}
+	return sockets, nil
+}
 
-	if len(workerPids) > 0 {
-		return workerPids, nil
+// readNetFile calls fn with the fields of each line of a file in /proc/net except the header
+func readNetFile(file string, fn func([]string)) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
 	}
+	defer f.Close()
 
-	return workerPids, fmt.Errorf("Cannot get unicorn worker pids")
+	scanner := bufio.NewScanner(f)
+	scanner.Scan()
+	for scanner.Scan() {
+		fn(strings.Fields(scanner.Text()))
+	}
+	return scanner.Err()
 }
 
-func cpuTime(pid string) (string, error) {
-	out, err := pipedCommands.Output(
-		[]string{"cat", fmt.Sprintf("/proc/%s/stat", pid)},
-		[]string{"awk", "{print $14+$15}"},
-	)
+// listenerActivity finds the listeners opened by the master, and counts the workers which have
+// an accepted connection of the listeners, that is, the workers processing requests.
+// The listen queue is known for TCP listeners only.
+func listenerActivity(procRoot, masterPid string, workerPids []string) (activity, error) {
+	var a activity
+	sockets, err := readNetSockets(procRoot)
+	if err != nil {
+		return a, err
+	}
+	masterInodes, err := socketInodes(filepath.Join(procRoot, masterPid))
 	if err != nil {
-		return "", fmt.Errorf("Failed to cat /proc/%s/stat: %s", pid, err)
+		return a, err
 	}
 
-	return strings.Trim(string(out), "\n"), nil
+	listeners := make(map[string]bool)
+	for inode := range masterInodes {
+		s, ok := sockets[inode]
+		if !ok || !s.listen {
+			continue
+		}
+		listeners[s.address] = true
+		if !strings.HasPrefix(s.address, "/") && !strings.HasPrefix(s.address, "@") {
+			a.queued += s.queued
+			a.hasQueued = true
+		}
+	}
+	if len(listeners) == 0 {
+		return a, fmt.Errorf("Cannot find the listeners of unicorn master %s", masterPid)
+	}
+
+	for _, pid := range workerPids {
+		inodes, err := socketInodes(filepath.Join(procRoot, pid))
+		if err != nil {
+			// The worker with pid terminates
+			continue
+		}
+		for inode := range inodes {
+			if s, ok := sockets[inode]; ok && s.established && listeners[s.address] {
+				a.busy++
+				break
+			}
+		}
+	}
+	return a, nil
 }
@@ -1,66 +1,121 @@
 package mpunicorn
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
 )
 
-type TestFetchUnicornWorkerPidsCommand struct{}
+// fdLinks is the targets of the links in /proc/<pid>/fd, which are created in the test
+// since file names such as socket:[1001] cannot be checked out on every platform.
+var fdLinks = map[string]map[string]string{
+	"3000": {"0": "/dev/null", "3": "socket:[1001]", "4": "socket:[1002]", "5": "socket:[1003]"},
+	"3001": {"3": "socket:[1001]", "4": "socket:[1002]", "7": "socket:[2001]"},
+	"3002": {"3": "socket:[1001]", "4": "socket:[1002]", "8": "socket:[2002]", "9": "pipe:[5555]"},
+	"3003": {"3": "socket:[1001]", "4": "socket:[1002]", "9": "socket:[3001]"},
+	"3004": {"3": "socket:[1001]"},
+	"4000": {"3": "socket:[4001]"},
+}
 
-func (r TestFetchUnicornWorkerPidsCommand) Output(command string, args ...string) ([]byte, error) {
-	out := ` PID TTY      STAT   TIME COMMAND
-  584 ?        Sl     0:27 unicorn worker[7] -c config/unicorn.rb -E deployment
- 1857 ?        Sl     0:24 unicorn worker[6] -c config/unicorn.rb -E deployment
- 2258 ?        Sl     0:19 unicorn worker[4] -c config/unicorn.rb -E deployment
- 2627 ?        Sl     0:21 unicorn worker[2] -c config/unicorn.rb -E deployment
- 2872 ?        Sl     0:22 unicorn worker[5] -c config/unicorn.rb -E deployment
- 3085 ?        Sl     0:21 unicorn worker[3] -c config/unicorn.rb -E deployment
- 3546 ?        Sl     0:17 unicorn worker[10] -c config/unicorn.rb -E deployment
- 4392 ?        Sl     0:16 unicorn worker[8] -c config/unicorn.rb -E deployment
- 6049 ?        Sl     0:12 unicorn worker[14] -c config/unicorn.rb -E deployment
- 8430 ?        Sl     0:13 unicorn worker[0] -c config/unicorn.rb -E deployment
- 8744 ?        Sl     0:10 unicorn worker[13] -c config/unicorn.rb -E deployment
- 9293 ?        Sl     0:11 unicorn worker[1] -c config/unicorn.rb -E deployment
-10425 ?        Sl     0:08 unicorn worker[11] -c config/unicorn.rb -E deployment
-11152 ?        Sl     0:05 unicorn worker[9] -c config/unicorn.rb -E deployment
-11576 ?        Sl     0:05 unicorn worker[15] -c config/unicorn.rb -E deployment
-11685 ?        Sl     0:04 unicorn worker[12] -c config/unicorn.rb -E deployment`
-	return []byte(out), nil
+// setupProcRoot copies ./sample/proc to a temporary directory and creates the fd links in it
+func setupProcRoot(t *testing.T) string {
+	root, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = filepath.Walk("./sample/proc", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel("./sample/proc", path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(root, rel), 0755)
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(root, rel), b, 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for pid, links := range fdLinks {
+		dir := filepath.Join(root, pid, "fd")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for fd, target := range links {
+			if err := os.Symlink(target, filepath.Join(dir, fd)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return root
 }
 
 func TestFetchUnicornWorkerPids(t *testing.T) {
-	command = TestFetchUnicornWorkerPidsCommand{}
-	masterPid := "30661"
-	expectedPids := []string{"584", "1857", "2258", "2627", "2872", "3085", "3546",
-		"4392", "6049", "8430", "8744", "9293", "10425", "11152", "11576", "11685"}
-	pids, _ := fetchUnicornWorkerPids(masterPid)
+	masterPid := "3000"
+	// 3004 is a new master forked by USR2
+	expectedPids := []string{"3001", "3002", "3003"}
+	pids, _ := fetchUnicornWorkerPids("./sample/proc", masterPid)
+	sort.Strings(pids)
 	if !reflect.DeepEqual(pids, expectedPids) {
 		t.Errorf("fetchUnicornWorkerPids: expected %s but got %s", expectedPids, pids)
 	}
+
+	_, err := fetchUnicornWorkerPids("./sample/proc", "4000")
+	if err == nil {
+		t.Errorf("fetchUnicornWorkerPids: should fail without workers")
+	}
 }
 
-type TestCPUTimePipedCommands struct{}
+func TestListenerActivity(t *testing.T) {
+	root := setupProcRoot(t)
+	defer os.RemoveAll(root)
 
-func (r TestCPUTimePipedCommands) Output(commands ...[]string) ([]byte, error) {
-	return []byte("418\n"), nil
-}
+	// 3001 has a TCP connection to port 8080, and 3003 has a connection to /tmp/unicorn.sock
+	a, err := listenerActivity(root, "3000", []string{"3001", "3002", "3003"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := activity{busy: 2, queued: 3, hasQueued: true}
+	if a != expected {
+		t.Errorf("listenerActivity: expected %+v but got %+v", expected, a)
+	}
 
-func TestIdleWorkerCount(t *testing.T) {
-	pipedCommands = TestCPUTimePipedCommands{}
-	pids := []string{"584", "1857", "2258", "2627", "2872", "3085", "3546"}
-	expectedCount := len(pids)
-	c, _ := idleWorkerCount(pids)
-	if c != 7 {
-		t.Errorf("idleWorkerCount: expected %d but got %d", expectedCount, c)
+	_, err = listenerActivity(root, "4000", []string{"3001"})
+	if err == nil {
+		t.Errorf("listenerActivity: should fail without listeners")
 	}
 }
 
-func TestCPUTime(t *testing.T) {
-	pipedCommands = TestCPUTimePipedCommands{}
-	pid := "3061"
-	expectedCPUTime := "418"
-	c, _ := cpuTime(pid)
-	if c != expectedCPUTime {
-		t.Errorf("cpuTime: expected %s but got %s", expectedCPUTime, c)
+func TestParseRaindrops(t *testing.T) {
+	out := `calling: 0
+writing: 0
+0.0.0.0:8080 active: 2
+0.0.0.0:8080 queued: 1
+/tmp/unicorn.sock active: 3
+/tmp/unicorn.sock queued: 0
+`
+	a, err := parseRaindrops(strings.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := activity{busy: 5, queued: 1, hasQueued: true}
+	if a != expected {
+		t.Errorf("parseRaindrops: expected %+v but got %+v", expected, a)
+	}
+
+	_, err = parseRaindrops(strings.NewReader("<html></html>"))
+	if err == nil {
+		t.Errorf("parseRaindrops: should fail without listener stats")
 	}
 }
@@ -0,0 +1,33 @@
+package mpuptime
+
+import "syscall"
+
+const (
+	timeError = 5      // TIME_ERROR: clock not synchronized
+	staUnsync = 0x0040 // STA_UNSYNC
+	staNano   = 0x2000 // STA_NANO: offset is in nanoseconds instead of microseconds
+)
+
+// fetchClock reads the kernel clock discipline by adjtimex(2) without modifying it.
+func fetchClock() (map[string]interface{}, error) {
+	var tx syscall.Timex
+	state, err := syscall.Adjtimex(&tx)
+	if err != nil {
+		return nil, err
+	}
+
+	offset := float64(tx.Offset) / 1e6
+	if tx.Status&staNano != 0 {
+		offset = float64(tx.Offset) / 1e9
+	}
+	synchronized := 1
+	if state == timeError || tx.Status&staUnsync != 0 {
+		synchronized = 0
+	}
+	return map[string]interface{}{
+		"offset":             offset,
+		"max_error":          float64(tx.Maxerror) / 1e6,
+		"est_error":          float64(tx.Esterror) / 1e6,
+		"clock_synchronized": synchronized,
+	}, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package mpuptime
+
+import "errors"
+
+func fetchClock() (map[string]interface{}, error) {
+	return nil, errors.New("adjtimex is not supported")
+}
@@ -0,0 +1,128 @@
+package mpuptime
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	bootIDPath         = "/proc/sys/kernel/random/boot_id"
+	osReleasePath      = "/proc/sys/kernel/osrelease"
+	rebootRequiredPath = "/var/run/reboot-required"
+	kernelImageGlob    = "/boot/vmlinuz-*"
+)
+
+// detectReboot compares the current boot ID with the one saved in stateFile by the last run,
+// and saves the current one. It returns false on the first run.
+func detectReboot(stateFile string) (bool, error) {
+	content, err := ioutil.ReadFile(bootIDPath)
+	if err != nil {
+		return false, err
+	}
+	bootID := strings.TrimSpace(string(content))
+
+	last, err := ioutil.ReadFile(stateFile)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if err := writeFileAtomic(stateFile, []byte(bootID+"\n")); err != nil {
+		return false, err
+	}
+	return len(last) > 0 && strings.TrimSpace(string(last)) != bootID, nil
+}
+
+// writeFileAtomic writes the content to a temporary file and renames it,
+// so that an interrupted run does not leave a truncated file
+func writeFileAtomic(file string, content []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file))
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), file)
+}
+
+// rebootRequired returns true when /var/run/reboot-required exists (Debian/Ubuntu)
+// or a kernel newer than the running one is installed in /boot.
+// It returns an error if the version of the running kernel is not available, e.g. not on Linux.
+func rebootRequired() (bool, error) {
+	content, err := ioutil.ReadFile(osReleasePath)
+	if err != nil {
+		return false, err
+	}
+	running := strings.TrimSpace(string(content))
+
+	if _, err := os.Stat(rebootRequiredPath); err == nil {
+		return true, nil
+	}
+
+	images, err := filepath.Glob(kernelImageGlob)
+	if err != nil {
+		return false, err
+	}
+	prefix := strings.TrimSuffix(kernelImageGlob, "*")
+	for _, image := range images {
+		installed := strings.TrimPrefix(image, prefix)
+		// vmlinuz-0-rescue-* on RHEL is not a kernel version
+		if installed == "" || !unicode.IsDigit(rune(installed[0])) {
+			continue
+		}
+		if compareKernelVersion(installed, running) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// compareKernelVersion compares versions like "4.4.0-57-generic" and "4.4.0-104-generic"
+// treating each run of digits as a number.
+func compareKernelVersion(a, b string) int {
+	as, bs := splitVersion(a), splitVersion(b)
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aerr := strconv.ParseUint(as[i], 10, 64)
+		bn, berr := strconv.ParseUint(bs[i], 10, 64)
+		switch {
+		case aerr == nil && berr == nil:
+			if an != bn {
+				if an > bn {
+					return 1
+				}
+				return -1
+			}
+		case as[i] != bs[i]:
+			if as[i] > bs[i] {
+				return 1
+			}
+			return -1
+		}
+	}
+	return len(as) - len(bs)
+}
+
+func splitVersion(v string) []string {
+	var parts []string
+	start := 0
+	for i := 1; i <= len(v); i++ {
+		if i == len(v) || unicode.IsDigit(rune(v[i])) != unicode.IsDigit(rune(v[i-1])) {
+			parts = append(parts, v[start:i])
+			start = i
+		}
+	}
+	return parts
+}
@@ -3,6 +3,8 @@ package mpuptime
 import (
 	"flag"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
@@ -12,6 +14,8 @@ import (
 // UptimePlugin mackerel plugin
 type UptimePlugin struct {
 	Prefix string
+	// StateFile keeps the boot ID of the previous run to detect a reboot
+	StateFile string
 }
 
 // MetricKeyPrefix interface for PluginWithPrefix
@@ -33,6 +37,24 @@ func (u UptimePlugin) GraphDefinition() map[string]mp.Graphs {
 				{Name: "seconds", Label: "Seconds"},
 			},
 		},
+		"status": {
+			Label: labelPrefix + " Status",
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "rebooted", Label: "Rebooted since last run"},
+				{Name: "reboot_required", Label: "Reboot required"},
+				{Name: "clock_synchronized", Label: "Clock synchronized"},
+			},
+		},
+		"clock": {
+			Label: labelPrefix + " Clock Offset (sec)",
+			Unit:  "float",
+			Metrics: []mp.Metrics{
+				{Name: "offset", Label: "Offset"},
+				{Name: "est_error", Label: "Estimated error"},
+				{Name: "max_error", Label: "Maximum error"},
+			},
+		},
 	}
 }
 
@@ -42,7 +64,30 @@ func (u UptimePlugin) FetchMetrics() (map[string]interface{}, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Faild to fetch uptime metrics: %s", err)
 	}
-	return map[string]interface{}{"seconds": ut}, nil
+	stat := map[string]interface{}{"seconds": ut}
+
+	// the following are not available on some platforms, so errors are just ignored
+	if u.StateFile != "" {
+		if rebooted, err := detectReboot(u.StateFile); err == nil {
+			stat["rebooted"] = boolToInt(rebooted)
+		}
+	}
+	if required, err := rebootRequired(); err == nil {
+		stat["reboot_required"] = boolToInt(required)
+	}
+	if clock, err := fetchClock(); err == nil {
+		for k, v := range clock {
+			stat[k] = v
+		}
+	}
+	return stat, nil
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
 }
 
 // Do the plugin
@@ -54,6 +99,15 @@ func Do() {
 	u := UptimePlugin{
 		Prefix: *optPrefix,
 	}
+	tempfile := *optTempfile
+	if tempfile == "" {
+		dir := os.Getenv("MACKEREL_PLUGIN_WORKDIR")
+		if dir == "" {
+			dir = os.TempDir()
+		}
+		tempfile = filepath.Join(dir, fmt.Sprintf("mackerel-plugin-%s", u.MetricKeyPrefix()))
+	}
+	u.StateFile = tempfile + "-boot_id"
 	helper := mp.NewMackerelPlugin(u)
 	helper.Tempfile = *optTempfile
 	helper.Run()
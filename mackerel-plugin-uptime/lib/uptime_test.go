@@ -0,0 +1,87 @@
+package mpuptime
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphDefinition(t *testing.T) {
+	u := UptimePlugin{Prefix: "uptime"}
+
+	graphdef := u.GraphDefinition()
+	if len(graphdef) != 3 {
+		t.Errorf("GetTempfilename: %d should be 3", len(graphdef))
+	}
+}
+
+func TestDetectReboot(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bootIDPath = filepath.Join(dir, "boot_id")
+	stateFile := filepath.Join(dir, "state")
+
+	ioutil.WriteFile(bootIDPath, []byte("2cb5e57a-6e17-4c5e-b1a9-4b3a3f3c4e1d\n"), 0644)
+	rebooted, err := detectReboot(stateFile)
+	assert.Nil(t, err)
+	assert.False(t, rebooted, "first run")
+
+	rebooted, err = detectReboot(stateFile)
+	assert.Nil(t, err)
+	assert.False(t, rebooted, "same boot")
+
+	ioutil.WriteFile(bootIDPath, []byte("f1a8c1c4-3fd2-4f38-9a4b-0d1f0b6c7e55\n"), 0644)
+	rebooted, err = detectReboot(stateFile)
+	assert.Nil(t, err)
+	assert.True(t, rebooted, "boot ID changed")
+
+	rebooted, err = detectReboot(stateFile)
+	assert.Nil(t, err)
+	assert.False(t, rebooted, "after reboot")
+}
+
+func TestRebootRequired(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rebootRequiredPath = filepath.Join(dir, "reboot-required")
+	osReleasePath = filepath.Join(dir, "osrelease")
+	kernelImageGlob = filepath.Join(dir, "vmlinuz-*")
+
+	_, err = rebootRequired()
+	assert.NotNil(t, err, "should fail without the version of the running kernel")
+
+	ioutil.WriteFile(osReleasePath, []byte("4.4.0-98-generic\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "vmlinuz-4.4.0-98-generic"), nil, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "vmlinuz-4.4.0-97-generic"), nil, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "vmlinuz-0-rescue-3f5c1b2a"), nil, 0644)
+	required, err := rebootRequired()
+	assert.Nil(t, err)
+	assert.False(t, required)
+
+	ioutil.WriteFile(filepath.Join(dir, "vmlinuz-4.4.0-104-generic"), nil, 0644)
+	required, _ = rebootRequired()
+	assert.True(t, required)
+
+	os.Remove(filepath.Join(dir, "vmlinuz-4.4.0-104-generic"))
+	ioutil.WriteFile(rebootRequiredPath, nil, 0644)
+	required, _ = rebootRequired()
+	assert.True(t, required)
+}
+
+func TestCompareKernelVersion(t *testing.T) {
+	assert.True(t, compareKernelVersion("4.4.0-104-generic", "4.4.0-98-generic") > 0)
+	assert.True(t, compareKernelVersion("3.10.0-693.el7.x86_64", "3.10.0-862.el7.x86_64") < 0)
+	assert.True(t, compareKernelVersion("4.14.1", "4.9.60") > 0)
+	assert.Equal(t, 0, compareKernelVersion("4.9.0-4-amd64", "4.9.0-4-amd64"))
+}
@@ -1,12 +1,14 @@
 package mpvarnish
 
 import (
+	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"regexp"
-	"strconv"
 	"strings"
 
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
@@ -69,6 +71,63 @@ var graphdef = map[string]mp.Graphs{
 			{Name: "available", Label: "Available", Diff: false},
 		},
 	},
+	"varnish.threads": {
+		Label: "Varnish Threads",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "threads", Label: "Threads", Diff: false},
+			{Name: "thread_queue_len", Label: "Queued", Diff: false},
+		},
+	},
+	"varnish.thread_events": {
+		Label: "Varnish Thread Events",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "threads_created", Label: "Created", Diff: true},
+			{Name: "threads_destroyed", Label: "Destroyed", Diff: true},
+			{Name: "threads_failed", Label: "Failed", Diff: true},
+			{Name: "threads_limited", Label: "Limited", Diff: true},
+		},
+	},
+	"varnish.sessions": {
+		Label: "Varnish Sessions",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "sess_queued", Label: "Queued", Diff: true},
+			{Name: "sess_dropped", Label: "Dropped", Diff: true},
+			{Name: "sess_fail", Label: "Failed", Diff: true},
+		},
+	},
+	"varnish.lru": {
+		Label: "Varnish LRU",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "n_lru_nuked", Label: "Nuked", Diff: true},
+		},
+	},
+	"varnish.backend_requests.#": {
+		Label: "Varnish Backend Requests",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "req", Label: "Requests", Diff: true},
+		},
+	},
+	"varnish.backend_connections.#": {
+		Label: "Varnish Backend Connections",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "conn", Label: "Connections", Diff: false},
+		},
+	},
+	"varnish.backend_failures.#": {
+		Label: "Varnish Backend Failures",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "fail", Label: "Conn fail", Diff: true},
+			{Name: "busy", Label: "Busy", Diff: true},
+			{Name: "unhealthy", Label: "Unhealthy", Diff: true},
+		},
+	},
 }
 
 // VarnishPlugin mackerel plugin for varnish
@@ -80,80 +139,137 @@ type VarnishPlugin struct {
 
 // FetchMetrics interface for mackerelplugin
 func (m VarnishPlugin) FetchMetrics() (map[string]interface{}, error) {
-	var out []byte
-	var err error
-
-	if m.VarnishName == "" {
-		out, err = exec.Command(m.VarnishStatPath, "-1").CombinedOutput()
-	} else {
-		out, err = exec.Command(m.VarnishStatPath, "-1", "-n", m.VarnishName).CombinedOutput()
+	args := []string{"-j"}
+	if m.VarnishName != "" {
+		args = append(args, "-n", m.VarnishName)
 	}
+	out, err := exec.Command(m.VarnishStatPath, args...).Output()
 	if err != nil {
 		return nil, fmt.Errorf("%s: %s", err, out)
 	}
+	return parseVarnishStat(bytes.NewReader(out))
+}
+
+// MAIN counters (without the "MAIN." prefix of Varnish 4 and later) and their metric names
+var mainCounters = map[string]string{
+	"cache_hit":         "cache_hits",
+	"backend_req":       "backend_req",
+	"backend_conn":      "backend_conn",
+	"backend_fail":      "backend_fail",
+	"n_object":          "n_object",
+	"n_objectcore":      "n_objectcore",
+	"n_objecthead":      "n_objecthead",
+	"n_expired":         "n_expired",
+	"n_lru_nuked":       "n_lru_nuked",
+	"busy_sleep":        "busy_sleep",
+	"busy_wakeup":       "busy_wakeup",
+	"threads":           "threads",
+	"thread_queue_len":  "thread_queue_len",
+	"threads_created":   "threads_created",
+	"threads_destroyed": "threads_destroyed",
+	"threads_failed":    "threads_failed",
+	"threads_limited":   "threads_limited",
+	"sess_queued":       "sess_queued",
+	"sess_dropped":      "sess_dropped",
+	"sess_fail":         "sess_fail",
+}
+
+// counters which make up the client requests
+var requestCounters = map[string]bool{
+	"cache_hit":     true,
+	"cache_miss":    true,
+	"cache_hitpass": true,
+}
+
+// VBE counters and their graphs
+var backendCounters = map[string]string{
+	"req":       "varnish.backend_requests",
+	"conn":      "varnish.backend_connections",
+	"fail":      "varnish.backend_failures",
+	"busy":      "varnish.backend_failures",
+	"unhealthy": "varnish.backend_failures",
+}
+
+type varnishCounter struct {
+	Value float64 `json:"value"`
+}
+
+var nonMetricNameRe = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+// backendName strips the VCL name from the ident of a backend,
+// e.g. "boot.default" of Varnish 4.1 and later, or "default(127.0.0.1,,8080)" of Varnish 4.0.
+func backendName(ident string) string {
+	if i := strings.Index(ident, "("); i >= 0 {
+		ident = ident[:i]
+	}
+	if i := strings.Index(ident, "."); i >= 0 {
+		ident = ident[i+1:]
+	}
+	return nonMetricNameRe.ReplaceAllString(ident, "_")
+}
 
-	lineexp := regexp.MustCompile("^([^ ]+) +(\\d+)")
-	smaexp := regexp.MustCompile("^SMA\\.([^\\.]+)\\.(.+)$")
+// parseVarnishStat parses the output of "varnishstat -j".
+// Varnish 6.5 and later put the counters in "counters", while the earlier versions put them at the top level.
+func parseVarnishStat(r io.Reader) (map[string]interface{}, error) {
+	var root map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&root); err != nil {
+		return nil, err
+	}
+	if raw, ok := root["counters"]; ok {
+		root = nil
+		if err := json.Unmarshal(raw, &root); err != nil {
+			return nil, err
+		}
+	}
 
 	stat := map[string]interface{}{
 		"requests": float64(0),
 	}
-
-	var tmpv float64
-	for _, line := range strings.Split(string(out), "\n") {
-		match := lineexp.FindStringSubmatch(line)
-		if match == nil {
+	for name, raw := range root {
+		if name == "timestamp" || name == "version" {
 			continue
 		}
-
-		tmpv, err = strconv.ParseFloat(match[2], 64)
-		if err != nil {
+		var c varnishCounter
+		if err := json.Unmarshal(raw, &c); err != nil {
 			continue
 		}
 
-		switch match[1] {
-		case "cache_hit", "MAIN.cache_hit":
-			stat["cache_hits"] = tmpv
-			stat["requests"] = stat["requests"].(float64) + tmpv
-		case "cache_miss", "MAIN.cache_miss":
-			stat["requests"] = stat["requests"].(float64) + tmpv
-		case "cache_hitpass", "MAIN.cache_hitpass":
-			stat["requests"] = stat["requests"].(float64) + tmpv
-		case "MAIN.backend_req":
-			stat["backend_req"] = tmpv
-		case "MAIN.backend_conn":
-			stat["backend_conn"] = tmpv
-		case "MAIN.backend_fail":
-			stat["backend_fail"] = tmpv
-		case "MAIN.n_object":
-			stat["n_object"] = tmpv
-		case "MAIN.n_objectcore":
-			stat["n_objectcore"] = tmpv
-		case "MAIN.n_expired":
-			stat["n_expired"] = tmpv
-		case "MAIN.n_objecthead":
-			stat["n_objecthead"] = tmpv
-		case "MAIN.busy_sleep":
-			stat["busy_sleep"] = tmpv
-		case "MAIN.busy_wakeup":
-			stat["busy_wakeup"] = tmpv
-		default:
-			smamatch := smaexp.FindStringSubmatch(match[1])
-			if smamatch == nil || smamatch[1] == "Transient" {
+		switch {
+		case strings.HasPrefix(name, "VBE."):
+			// the ident may contain dots, e.g. "VBE.reload_20170101_000000.default.req"
+			i := strings.LastIndex(name, ".")
+			graph, ok := backendCounters[name[i+1:]]
+			if !ok {
+				continue
+			}
+			key := graph + "." + backendName(name[len("VBE."):i]) + "." + name[i+1:]
+			// backends of the VCLs which are not discarded yet are summed
+			v, _ := stat[key].(float64)
+			stat[key] = v + c.Value
+		case strings.HasPrefix(name, "SMA."):
+			parts := strings.SplitN(name, ".", 3)
+			if len(parts) != 3 || parts[1] == "Transient" {
 				continue
 			}
-			if smamatch[2] == "g_alloc" {
-				fmt.Printf("%+v\n", smamatch)
-				stat["varnish.sma.g_alloc."+smamatch[1]+".g_alloc"] = tmpv
-			} else if smamatch[2] == "g_bytes" {
-				stat["varnish.sma.memory."+smamatch[1]+".allocated"] = tmpv
-			} else if smamatch[2] == "g_space" {
-				stat["varnish.sma.memory."+smamatch[1]+".available"] = tmpv
+			switch parts[2] {
+			case "g_alloc":
+				stat["varnish.sma.g_alloc."+parts[1]+".g_alloc"] = c.Value
+			case "g_bytes":
+				stat["varnish.sma.memory."+parts[1]+".allocated"] = c.Value
+			case "g_space":
+				stat["varnish.sma.memory."+parts[1]+".available"] = c.Value
+			}
+		default:
+			counter := strings.TrimPrefix(name, "MAIN.")
+			if requestCounters[counter] {
+				stat["requests"] = stat["requests"].(float64) + c.Value
+			}
+			if key, ok := mainCounters[counter]; ok {
+				stat[key] = c.Value
 			}
 		}
 	}
-
-	return stat, err
+	return stat, nil
 }
 
 // GraphDefinition interface for mackerelplugin
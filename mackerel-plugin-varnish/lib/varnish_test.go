@@ -0,0 +1,76 @@
+package mpvarnish
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func parseFixture(t *testing.T, name string) map[string]interface{} {
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	stat, err := parseVarnishStat(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stat
+}
+
+func TestParseVarnishStat5(t *testing.T) {
+	stat := parseFixture(t, "fixtures/varnishstat-5.json")
+
+	assert.EqualValues(t, 10000, stat["requests"])
+	assert.EqualValues(t, 9000, stat["cache_hits"])
+	assert.EqualValues(t, 1100, stat["backend_req"])
+	assert.EqualValues(t, 3, stat["backend_fail"])
+	assert.EqualValues(t, 500, stat["n_object"])
+	assert.EqualValues(t, 200, stat["threads"])
+	assert.EqualValues(t, 4, stat["thread_queue_len"])
+	assert.EqualValues(t, 1, stat["threads_limited"])
+	assert.EqualValues(t, 7, stat["sess_queued"])
+	assert.EqualValues(t, 1, stat["sess_dropped"])
+	assert.EqualValues(t, 12, stat["n_lru_nuked"])
+
+	assert.EqualValues(t, 1000, stat["varnish.sma.g_alloc.s0.g_alloc"])
+	assert.EqualValues(t, 10485760, stat["varnish.sma.memory.s0.allocated"])
+	assert.EqualValues(t, 257949696, stat["varnish.sma.memory.s0.available"])
+	_, ok := stat["varnish.sma.g_alloc.Transient.g_alloc"]
+	assert.False(t, ok)
+
+	// the backends of the boot and the reloaded VCL are summed
+	assert.EqualValues(t, 1100, stat["varnish.backend_requests.default.req"])
+	assert.EqualValues(t, 3, stat["varnish.backend_connections.default.conn"])
+	assert.EqualValues(t, 50, stat["varnish.backend_requests.api.req"])
+	_, ok = stat["varnish.backend_failures.default.fail"]
+	assert.False(t, ok)
+}
+
+func TestParseVarnishStat6(t *testing.T) {
+	stat := parseFixture(t, "fixtures/varnishstat-6.json")
+
+	assert.EqualValues(t, 4000, stat["requests"])
+	assert.EqualValues(t, 3000, stat["cache_hits"])
+	assert.EqualValues(t, 100, stat["threads"])
+	assert.EqualValues(t, 2, stat["sess_queued"])
+	assert.EqualValues(t, 266338304, stat["varnish.sma.memory.s0.available"])
+
+	assert.EqualValues(t, 600, stat["varnish.backend_requests.web1.req"])
+	assert.EqualValues(t, 3, stat["varnish.backend_connections.web1.conn"])
+	assert.EqualValues(t, 2, stat["varnish.backend_failures.web1.fail"])
+	assert.EqualValues(t, 1, stat["varnish.backend_failures.web1.busy"])
+	assert.EqualValues(t, 5, stat["varnish.backend_failures.web1.unhealthy"])
+	assert.EqualValues(t, 400, stat["varnish.backend_requests.web-2.req"])
+	_, ok := stat["MGT.uptime"]
+	assert.False(t, ok)
+}
+
+func TestBackendName(t *testing.T) {
+	assert.Equal(t, "default", backendName("boot.default"))
+	assert.Equal(t, "default", backendName("reload_20170612_120000.default"))
+	assert.Equal(t, "default", backendName("default(127.0.0.1,,8080)"))
+	assert.Equal(t, "api_v1", backendName("boot.api.v1"))
+}
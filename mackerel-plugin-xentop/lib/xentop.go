@@ -2,8 +2,10 @@ package mpxentop
 
 import (
 	"bufio"
+	"bytes"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"strconv"
@@ -12,11 +14,8 @@ import (
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
 )
 
-// index from table headers to array index
-// This is generated dynamically at generateIndex
-var index = map[string]int{}
-
 var graphdef = map[string]mp.Graphs{
+	// CPU(sec) is a cumulative counter. Its difference per minute is converted to percentage.
 	"xentop.cpu.#": {
 		Label: "Xentop CPU",
 		Unit:  "percentage",
@@ -31,6 +30,21 @@ var graphdef = map[string]mp.Graphs{
 			{Name: "memory", Label: "memory", Stacked: true},
 		},
 	},
+	"xentop.memory_size.#": {
+		Label: "Xentop Memory Size",
+		Unit:  "bytes",
+		Metrics: []mp.Metrics{
+			{Name: "current", Label: "current"},
+			{Name: "max", Label: "max"},
+		},
+	},
+	"xentop.vcpu.#": {
+		Label: "Xentop vCPUs",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "vcpu", Label: "vcpu", Stacked: true},
+		},
+	},
 	"xentop.nettx.#": {
 		Label: "Xentop Nettx",
 		Unit:  "bytes",
@@ -61,92 +75,91 @@ var graphdef = map[string]mp.Graphs{
 	},
 }
 
-// XentopPlugin mackerel plugin for xentop
-type XentopPlugin struct {
-	XenVersion int
+// column of xentop and the metric key format with its scale
+type xentopColumn struct {
+	Header    string
+	KeyFormat string
+	Scale     float64
 }
 
-// FetchMetrics interface for mackerelplugin
-func (m XentopPlugin) FetchMetrics() (map[string]interface{}, error) {
-	stat := make(map[string]interface{})
+var xentopColumns = []xentopColumn{
+	{"CPU(sec)", "xentop.cpu.%s.cpu", 100.0 / 60},
+	{"MEM(%)", "xentop.memory.%s.memory", 1},
+	{"MEM(k)", "xentop.memory_size.%s.current", 1024},
+	{"MAXMEM(k)", "xentop.memory_size.%s.max", 1024},
+	{"VCPUS", "xentop.vcpu.%s.vcpu", 1},
+	{"NETTX(k)", "xentop.nettx.%s.nettx", 1000},
+	{"NETRX(k)", "xentop.netrx.%s.netrx", 1000},
+	{"VBD_RD", "xentop.vbdrd.%s.vbdrd", 1},
+	{"VBD_WR", "xentop.vbdwr.%s.vbdwr", 1},
+}
 
-	var cmd *exec.Cmd
-	if m.XenVersion == 4 {
-		cmd = exec.Command("/bin/sh", "-c", "xentop --batch -i 1 -f")
-	} else {
-		cmd = exec.Command("/bin/sh", "-c", "xentop --batch -i 1")
-	}
-	stdout, err := cmd.StdoutPipe()
+// XentopPlugin mackerel plugin for xentop
+type XentopPlugin struct{}
 
+// FetchMetrics interface for mackerelplugin
+func (m XentopPlugin) FetchMetrics() (map[string]interface{}, error) {
+	// -f (full names) is not supported by xentop of Xen 3.x
+	out, err := exec.Command("xentop", "--batch", "-i", "1", "-f").Output()
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		out, err = exec.Command("xentop", "--batch", "-i", "1").Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to run xentop: %s", err)
+		}
 	}
+	return parseXentop(bytes.NewReader(out))
+}
 
-	execErr := cmd.Start()
-	if execErr != nil {
-		fmt.Println(execErr)
-		os.Exit(1)
-	}
+// $ xentop --batch -i 1 -f
+// NAME  STATE   CPU(sec) CPU(%)     MEM(k) MEM(%)  MAXMEM(k) MAXMEM(%) VCPUS NETS NETTX(k) NETRX(k) VBDS   VBD_OO   VBD_RD   VBD_WR  VBD_RSECT  VBD_WSECT SSID
+// Domain-0 -----r      61813    0.0    4194304   12.5   no limit       n/a     8    0        0        0    0        0        0        0          0          0    0
+// web01 --b---      10520    0.0    2097152    6.2    2098176       6.3     2    1  1520420  2894134    1        0    42551   189534    1543008   11029480    0
+//
+// The columns are mapped by the header, which differs between Xen versions.
+func parseXentop(r io.Reader) (map[string]interface{}, error) {
+	stat := make(map[string]interface{})
+	var index map[string]int
 
-	dom0 := false
-	scanner := bufio.NewScanner(stdout)
-	hasIndex := false
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
-		sf := strings.Fields(string(scanner.Text()))
-		if sf[0] == "NAME" {
-			generateIndex(sf, index)
-			hasIndex = true
+		// MAXMEM(k) of Domain-0 is "no limit"
+		sf := strings.Fields(strings.Replace(scanner.Text(), "no limit", "n/a", -1))
+		if len(sf) == 0 {
 			continue
 		}
-		if !hasIndex {
+		if sf[0] == "NAME" {
+			index = make(map[string]int)
+			for i, column := range sf {
+				index[column] = i
+			}
 			continue
 		}
-		if stringInSlice("n/a", sf) {
-			changeIndex(&index)
-			dom0 = true
+		if index == nil || len(sf) != len(index) {
+			continue
 		}
-		name := normalizeXenName(sf[index["NAME"]])
 
-		var errParse error
-		var tmpval float64 // avoid `stat[*] *= 1000` because of go interface bug
-
-		tmpval, errParse = strconv.ParseFloat(sf[index["CPU(sec)"]], 64)
-		if errParse != nil {
-			return nil, errParse
-		}
-		stat[fmt.Sprintf("xentop.cpu.%s.cpu", name)] = tmpval * 100 / 60
-		stat[fmt.Sprintf("xentop.memory.%s.memory", name)], errParse = strconv.ParseFloat(sf[index["MEM(%)"]], 64)
-		if errParse != nil {
-			return nil, errParse
-		}
-		tmpval, errParse = strconv.ParseFloat(sf[index["NETTX(k)"]], 64)
-		if errParse != nil {
-			return nil, errParse
-		}
-		stat[fmt.Sprintf("xentop.nettx.%s.nettx", name)] = tmpval * 1000
-		tmpval, errParse = strconv.ParseFloat(sf[index["NETRX(k)"]], 64)
-		if errParse != nil {
-			return nil, errParse
-		}
-		stat[fmt.Sprintf("xentop.netrx.%s.netrx", name)] = tmpval * 1000
-		stat[fmt.Sprintf("xentop.vbdrd.%s.vbdrd", name)], errParse = strconv.ParseFloat(sf[index["VBD_RD"]], 64)
-		if errParse != nil {
-			return nil, errParse
-		}
-		stat[fmt.Sprintf("xentop.vbdwr.%s.vbdwr", name)], errParse = strconv.ParseFloat(sf[index["VBD_WR"]], 64)
-		if errParse != nil {
-			return nil, errParse
-		}
-		if dom0 {
-			revertIndex(&index)
-			dom0 = false
+		name := normalizeXenName(sf[index["NAME"]])
+		for _, c := range xentopColumns {
+			i, ok := index[c.Header]
+			if !ok {
+				continue
+			}
+			if sf[i] == "n/a" {
+				continue
+			}
+			value, err := strconv.ParseFloat(sf[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse %s of %s: %s", c.Header, name, err)
+			}
+			stat[fmt.Sprintf(c.KeyFormat, name)] = value * c.Scale
 		}
 	}
 	if err := scanner.Err(); err != nil {
-		panic(err)
+		return nil, err
+	}
+	if index == nil {
+		return nil, fmt.Errorf("header of xentop is not found")
 	}
-
 	return stat, nil
 }
 
@@ -158,13 +171,12 @@ func (m XentopPlugin) GraphDefinition() map[string]mp.Graphs {
 // Do the plugin
 func Do() {
 	optTempfile := flag.String("tempfile", "", "Temp file name")
-	optXenVersion := flag.Int("xenversion", 4, "Xen Version")
+	// columns are detected from the header of xentop now
+	flag.Int("xenversion", 4, "Xen Version (deprecated, not used)")
 	flag.Parse()
 
 	var xentop XentopPlugin
 
-	xentop.XenVersion = *optXenVersion
-
 	helper := mp.NewMackerelPlugin(xentop)
 
 	if *optTempfile != "" {
@@ -181,38 +193,3 @@ func Do() {
 func normalizeXenName(raw string) string {
 	return strings.Replace(raw, ".", "_", -1)
 }
-
-func stringInSlice(a string, list []string) bool {
-	for _, b := range list {
-		if b == a {
-			return true
-		}
-	}
-	return false
-}
-
-func generateIndex(sf []string, index map[string]int) {
-	i := 0
-	for _, column := range sf {
-		index[column] = i
-		i++
-	}
-}
-
-func changeIndex(p *map[string]int) {
-	maxmemPer := (*p)["MAXMEM(%)"]
-	for key, value := range *p {
-		if value >= maxmemPer {
-			(*p)[key]++
-		}
-	}
-}
-
-func revertIndex(p *map[string]int) {
-	maxmemPer := (*p)["MAXMEM(%)"]
-	for key, value := range *p {
-		if value >= maxmemPer {
-			(*p)[key]--
-		}
-	}
-}
@@ -0,0 +1,71 @@
+package mpxentop
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphDefinition(t *testing.T) {
+	var xentop XentopPlugin
+
+	graphdef := xentop.GraphDefinition()
+	if len(graphdef) != 8 {
+		t.Errorf("GraphDef's size: %d should be 8", len(graphdef))
+	}
+}
+
+func TestParseXentop3(t *testing.T) {
+	f, err := os.Open("fixtures/xentop-3.x.txt")
+	assert.Nil(t, err)
+	defer f.Close()
+
+	stat, err := parseXentop(f)
+	assert.Nil(t, err)
+
+	assert.InDelta(t, 28413*100.0/60, stat["xentop.cpu.Domain-0.cpu"], 0.001)
+	assert.EqualValues(t, 25.0, stat["xentop.memory.Domain-0.memory"])
+	assert.EqualValues(t, 1048576*1024, stat["xentop.memory_size.Domain-0.current"])
+	assert.Nil(t, stat["xentop.memory_size.Domain-0.max"])
+	assert.EqualValues(t, 2, stat["xentop.vcpu.Domain-0.vcpu"])
+
+	assert.InDelta(t, 7265*100.0/60, stat["xentop.cpu.db_exampl.cpu"], 0.001)
+	assert.EqualValues(t, 1048576*1024, stat["xentop.memory_size.db_exampl.max"])
+	assert.EqualValues(t, 204812*1000, stat["xentop.nettx.db_exampl.nettx"])
+	assert.EqualValues(t, 318441*1000, stat["xentop.netrx.db_exampl.netrx"])
+	assert.EqualValues(t, 51328, stat["xentop.vbdrd.db_exampl.vbdrd"])
+	assert.EqualValues(t, 204891, stat["xentop.vbdwr.db_exampl.vbdwr"])
+
+	assert.EqualValues(t, 40322, stat["xentop.vbdwr.web01.vbdwr"])
+}
+
+func TestParseXentop4(t *testing.T) {
+	f, err := os.Open("fixtures/xentop-4.x.txt")
+	assert.Nil(t, err)
+	defer f.Close()
+
+	stat, err := parseXentop(f)
+	assert.Nil(t, err)
+
+	assert.InDelta(t, 61813*100.0/60, stat["xentop.cpu.Domain-0.cpu"], 0.001)
+	assert.EqualValues(t, 8, stat["xentop.vcpu.Domain-0.vcpu"])
+	assert.Nil(t, stat["xentop.memory_size.Domain-0.max"])
+
+	assert.InDelta(t, 10520*100.0/60, stat["xentop.cpu.db_example_com.cpu"], 0.001)
+	assert.EqualValues(t, 6.2, stat["xentop.memory.db_example_com.memory"])
+	assert.EqualValues(t, 2097152*1024, stat["xentop.memory_size.db_example_com.current"])
+	assert.EqualValues(t, 2098176*1024, stat["xentop.memory_size.db_example_com.max"])
+	assert.EqualValues(t, 2, stat["xentop.vcpu.db_example_com.vcpu"])
+	assert.EqualValues(t, 1520420*1000, stat["xentop.nettx.db_example_com.nettx"])
+	assert.EqualValues(t, 42551, stat["xentop.vbdrd.db_example_com.vbdrd"])
+	assert.EqualValues(t, 189534, stat["xentop.vbdwr.db_example_com.vbdwr"])
+
+	assert.EqualValues(t, 1, stat["xentop.vcpu.web01.vcpu"])
+}
+
+func TestParseXentopWithoutHeader(t *testing.T) {
+	_, err := parseXentop(strings.NewReader(""))
+	assert.NotNil(t, err)
+}